@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"encoding/json"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// auditLogger appends a record of every deletion to the audit log. Deletion
+// workers write to it while the daemon goroutine reconfigures it on reloads.
+type auditLogger struct {
+	mu     sync.Mutex
+	config *pkg.AuditLogConfig
+	// writer is nil while the audit log is off.
+	writer *lumberjack.Logger
+}
+
+var auditLog auditLogger
+
+// configure switches the audit log to config, nil turning it off. The open
+// file is kept when the settings did not change.
+func (a *auditLogger) configure(config *pkg.AuditLogConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if reflect.DeepEqual(a.config, config) {
+		return
+	}
+	a.closeLocked()
+	a.config = config
+	if config == nil {
+		return
+	}
+	maxSize := config.MaxSizeMB
+	if maxSize == 0 {
+		maxSize = 100
+	}
+	a.writer = &lumberjack.Logger{
+		Filename:   config.Path,
+		MaxSize:    maxSize,
+		MaxBackups: config.MaxBackups,
+		MaxAge:     config.MaxAgeDays,
+		Compress:   config.Compress,
+	}
+	log.Infoln("Writing audit log to", config.Path)
+}
+
+// path returns the file of the audit log, empty while it is off.
+func (a *auditLogger) path() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.config == nil {
+		return ""
+	}
+	return a.config.Path
+}
+
+func (a *auditLogger) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closeLocked()
+	a.config = nil
+}
+
+func (a *auditLogger) closeLocked() {
+	if a.writer == nil {
+		return
+	}
+	if err := a.writer.Close(); err != nil {
+		log.Errorln("Error closing audit log:", err)
+	}
+	a.writer = nil
+}
+
+// record appends record as a line of JSON.
+func (a *auditLogger) record(record pkg.AuditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.writer == nil {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Errorln("Error encoding audit record:", err)
+		return
+	}
+	if _, err := a.writer.Write(append(line, '\n')); err != nil {
+		log.Errorln("Error writing audit log:", err)
+	}
+}
+
+// auditDeletion records the outcome err of deleting path for config.
+func auditDeletion(config pkg.DeleteConfig, reason, path string, info FileInfo, err error) {
+	record := pkg.AuditRecord{
+		Time:    time.Now(),
+		Rule:    config.DisplayName(),
+		Action:  pkg.AuditActionDelete,
+		Path:    path,
+		Size:    info.Size,
+		ModTime: info.ModTime,
+		Reason:  reason,
+		Result:  pkg.AuditResultDeleted,
+	}
+	switch {
+	case os.IsNotExist(err):
+		record.Result = pkg.AuditResultNotFound
+	case err != nil:
+		record.Result = pkg.AuditResultError
+		record.Error = err.Error()
+	}
+	auditLog.record(record)
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enableAuditLog writes the audit log to a temporary file for the test.
+func enableAuditLog(t *testing.T, config pkg.AuditLogConfig) string {
+	t.Helper()
+	config.Path = filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog.configure(&config)
+	t.Cleanup(auditLog.close)
+	return config.Path
+}
+
+func readAuditRecords(t *testing.T, path string) []pkg.AuditRecord {
+	t.Helper()
+	var records []pkg.AuditRecord
+	skipped, err := pkg.ReadAuditLog(path, func(record pkg.AuditRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil || skipped != 0 {
+		t.Fatalf("reading the audit log skipped %d lines: %v", skipped, err)
+	}
+	return records
+}
+
+func TestAuditLogRecordsDeletions(t *testing.T) {
+	path := enableAuditLog(t, pkg.AuditLogConfig{})
+	r := testRule(t, pkg.DeleteConfig{Name: "logs", RetentionDays: 1}, 2, 100, time.Now().Add(-10*24*time.Hour), time.Hour)
+	start := time.Now()
+	if result := DeleteOldFiles(context.Background(), r); result.DeletedFiles != 2 {
+		t.Fatalf("deleted %d files", result.DeletedFiles)
+	}
+	info := FileInfo{Size: 10, ModTime: start}
+	auditDeletion(r.config, pkg.AuditReasonSize, "/gone.log", info, os.ErrNotExist)
+	auditDeletion(r.config, pkg.AuditReasonSize, "/busy.log", info, errors.New("permission denied"))
+
+	records := readAuditRecords(t, path)
+	if len(records) != 4 {
+		t.Fatalf("got %d records, want 4", len(records))
+	}
+	first := records[0]
+	if first.Rule != "logs" || first.Action != pkg.AuditActionDelete || first.Reason != pkg.AuditReasonRetention ||
+		first.Result != pkg.AuditResultDeleted || first.Size != 100 || filepath.Base(first.Path) != "file00.log" ||
+		first.Time.Before(start.Add(-time.Second)) || time.Since(first.ModTime) < 9*24*time.Hour {
+		t.Errorf("deletion recorded as %+v", first)
+	}
+	if records[2].Result != pkg.AuditResultNotFound || records[2].Error != "" {
+		t.Errorf("missing file recorded as %+v", records[2])
+	}
+	if records[3].Result != pkg.AuditResultError || records[3].Error != "permission denied" {
+		t.Errorf("failed deletion recorded as %+v", records[3])
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	path := enableAuditLog(t, pkg.AuditLogConfig{MaxSizeMB: 1})
+	config := pkg.DeleteConfig{Name: "logs"}
+	info := FileInfo{Size: 1, ModTime: time.Now()}
+	// Records of about 300 bytes, the log is rotated once past 1 MB
+	long := "/" + strings.Repeat("x", 100)
+	for range 6000 {
+		auditDeletion(config, pkg.AuditReasonRetention, long, info, nil)
+	}
+	auditLog.close()
+
+	files, err := pkg.AuditFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 || files[len(files)-1] != path {
+		t.Fatalf("audit files %v, want rotated backups followed by %s", files, path)
+	}
+	if records := readAuditRecords(t, path); len(records) != 6000 {
+		t.Errorf("read %d records from the log and its backups, want 6000", len(records))
+	}
+
+	// Turning the audit log off stops recording
+	auditDeletion(config, pkg.AuditReasonRetention, "/after.log", info, nil)
+	if records := readAuditRecords(t, path); len(records) != 6000 {
+		t.Errorf("read %d records after the audit log was turned off", len(records))
+	}
+}
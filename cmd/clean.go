@@ -3,93 +3,108 @@ package cmd
 import (
 	constant "FileCleanup/const"
 	"FileCleanup/pkg"
-	log "github.com/sirupsen/logrus"
-	"os"
+	"context"
 	"sync"
 	"time"
 )
 
-var (
-	mutex sync.Mutex
-)
+// mutex guards rules and the index of every rule.
+var mutex sync.Mutex
 
-func DeleteExcessFiles(config pkg.DeleteConfig) {
-	mutex.Lock()
-	defer mutex.Unlock()
+func DeleteExcessFiles(ctx context.Context, r *rule) runResult {
+	r.runMutex.Lock()
+	defer r.runMutex.Unlock()
+	config := r.config
+	logger := r.logger()
 
-	log.Println("Started processing deletion of excess files...")
-	var folderSize int64
-	var maxFolderSize int64
-	var numFilesToDelete int64
+	logger.Println("Started processing deletion of excess files...")
+	var maxFolderBytes int64
 	if config.MaxFolderPercentEnabled {
-		var totalSize float64
-		folderSize, _, totalSize = getFolderSizePercent(config)
-		maxFolderSize = config.MaxFolderSizePercent
-		numFilesToDelete = int64(totalSize) * (folderSize - maxFolderSize) / 100 / getFileAvgSizeMB()
-		log.Printf("Folder size is %d%% out of allowed %d%%", folderSize, maxFolderSize)
+		folderSize, _, totalSize, err := getFolderSizePercent(config, r.index)
+		if err != nil {
+			logger.Errorln("Error reading disk usage:", err)
+			errorsTotal.add(1, config.DisplayName(), "disk_usage")
+			return runResult{Errors: 1, Error: "reading disk usage: " + err.Error()}
+		}
+		maxFolderBytes = int64(totalSize*constant.MB) * config.MaxFolderSizePercent / 100
+		logger.Printf("Folder size is %d%% out of allowed %d%%", folderSize, config.MaxFolderSizePercent)
 	} else {
-		folderSize = getFolderSizeMB()
-		maxFolderSize = config.MaxFolderSizeMB
-		log.Printf("Folder size is %f GB out of allowed %f GB", float64(folderSize)/constant.GB, float64(config.MaxFolderSizeMB)/constant.GB)
-		numFilesToDelete = (folderSize - config.MaxFolderSizeMB) / getFileAvgSizeMB()
+		maxFolderBytes = config.MaxFolderSizeMB * constant.MB
+		logger.Printf("Folder size is %f GB out of allowed %f GB", float64(getFolderSizeMB(r.index))*constant.MB/constant.GB, float64(maxFolderBytes)/constant.GB)
 	}
 
-	deletedFiles := uint64(0)
-
-	if folderSize > maxFolderSize {
-		log.Printf("Deleting excess files - %d files", numFilesToDelete)
-		// Delete the oldest numFilesToDelete files
-		for range numFilesToDelete {
-			// Find the oldest file
-			var oldestFile string
-			var oldestTime time.Time
-			for path, fileInfo := range fileInfoMap {
-				if oldestTime.IsZero() || fileInfo.ModTime.Before(oldestTime) {
-					oldestTime = fileInfo.ModTime
-					oldestFile = path
-				}
-			}
-
-			// Delete the oldest file
-			if err := os.Remove(oldestFile); err != nil {
-				log.Errorln("Error deleting file:", err)
-				break // Exit loop on error
-			}
-			deletedFiles++
-			if AppConfig.IsDetailedLogEnabled {
-				log.Debugln("Deleted:", oldestFile)
-			}
-
-			// Update fileInfoMap after deletion
-			delete(fileInfoMap, oldestFile)
+	// Evict the oldest files until the folder fits its limit. The lock is only
+	// held while popping from the index so new files keep being registered.
+	deleter := newFileDeleter(config, pkg.AuditReasonSize, true)
+	deleter.Run(ctx, func() (string, FileInfo, bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.index.Size() <= maxFolderBytes {
+			return "", FileInfo{}, false
 		}
+		return r.index.PopOldest()
+	}, func(path string, info FileInfo, err error) {
+		if err != nil {
+			logger.Errorln("Error deleting file:", err)
+		}
+		mutex.Lock()
+		r.index.Put(path, info)
+		mutex.Unlock()
+	})
+	deletedFiles := deleter.Deleted()
+	logger.Println("Total deleted files", deletedFiles, "Remaining Folder size: ", getFolderSizeMB(r.index), "MB")
+	if deletedFiles > 0 {
+		schedulePersistFileIndex()
+	}
+	result := deleter.result()
+	mutex.Lock()
+	result.FolderBytes, result.LimitBytes = r.index.Size(), maxFolderBytes
+	mutex.Unlock()
+	if result.aboveLimit() {
+		logger.Warnf("Folder is still above its limit, %s of %s", pkg.FormatBytes(result.FolderBytes), pkg.FormatBytes(maxFolderBytes))
 	}
-	log.Println("Total deleted files", deletedFiles, "Remaining Folder size: ", getFolderSizeMB(), "MB")
+	return result
 }
 
-func DeleteOldFiles(config pkg.DeleteConfig) {
-	mutex.Lock()
-	defer mutex.Unlock()
+func DeleteOldFiles(ctx context.Context, r *rule) runResult {
+	r.runMutex.Lock()
+	defer r.runMutex.Unlock()
+	config := r.config
+	logger := r.logger()
 
-	log.Println("Started processing deletion of old files...")
-	currentTime := time.Now()
-	deletedFiles := uint64(0)
-	if len(fileInfoMap) == 0 {
-		log.Println("No files to delete")
-		return
+	logger.Println("Started processing deletion of old files...")
+	cutoff := time.Now().Add(-time.Duration(config.RetentionDays * float64(24*time.Hour)))
+	mutex.Lock()
+	empty := r.index.Len() == 0
+	mutex.Unlock()
+	if empty {
+		logger.Println("No files to delete")
+		return runResult{}
 	}
-	for path, fileInfo := range fileInfoMap {
-		if currentTime.Sub(fileInfo.ModTime).Hours()/24 > config.RetentionDays {
-			if err := os.Remove(path); err != nil {
-				log.Println("Error deleting file:", err)
-			} else {
-				deletedFiles++
-				if AppConfig.IsDetailedLogEnabled {
-					log.Println("Deleted:", path)
-				}
-			}
-			delete(fileInfoMap, path)
+	deleter := newFileDeleter(config, pkg.AuditReasonRetention, false)
+	deleter.Run(ctx, func() (string, FileInfo, bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		path, fileInfo, ok := r.index.Oldest()
+		if !ok || !fileInfo.ModTime.Before(cutoff) {
+			return "", FileInfo{}, false
+		}
+		r.index.Remove(path)
+		return path, fileInfo, true
+	}, func(path string, info FileInfo, err error) {
+		if err == nil {
+			// Not attempted because the run was cancelled
+			mutex.Lock()
+			r.index.Put(path, info)
+			mutex.Unlock()
+			return
 		}
+		logger.Println("Error deleting file:", err)
+	})
+	deletedFiles := deleter.Deleted()
+	logger.Printf("Total deleted files %d | Remaining folder size %d MB", deletedFiles, getFolderSizeMB(r.index))
+	if deletedFiles > 0 {
+		schedulePersistFileIndex()
 	}
-	log.Printf("Total deleted files %d | Remaining folder size %d MB", deletedFiles, getFolderSizeMB())
+	return deleter.result()
 }
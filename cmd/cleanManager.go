@@ -1,14 +1,16 @@
 package cmd
 
 import (
+	"FileCleanup/pkg"
 	"errors"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
+	"syscall"
 	"time"
 )
 
@@ -18,157 +20,156 @@ type FileInfo struct {
 }
 
 var (
-	fileInfoMap    map[string]FileInfo
-	ConfigFilePath string
+	// DrainTimeout is how long a shutdown or reload waits for running cleanups.
+	DrainTimeout time.Duration
 )
 
 func cleanCmd() *cobra.Command {
-	var compareCmd = &cobra.Command{
+	var cleanCmd = &cobra.Command{
 		Use:     "clean [...FLAGS]",
 		Short:   "Clean files based on configuration file",
-		Example: `fileCleanup --file / -f /path/to/config/file clean`,
-		Run: func(cmd *cobra.Command, args []string) {
-			fileInfoMap = make(map[string]FileInfo)
-
-			if ConfigFilePath != "" {
-				UnmarshalJson(ConfigFilePath, &AppConfig)
+		Example: `fileCleanup clean --config /path/to/config/file`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(ConfigFilePath); errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("no configuration file %s, create one with config init or setup", ConfigFilePath)
+			}
+			if err := pkg.ValidateFile(ConfigFilePath, AppConfig); err != nil {
+				return fmt.Errorf("invalid configuration %s, run config validate for details:\n%w", ConfigFilePath, err)
 			}
 
 			log.Infoln(`Initiating FileCleanup`)
-
-			// Populate fileModTimes with existing files in the target folder
-			for _, deleteConfig := range AppConfig.DeleteConfig {
-				if err := populateFileInfoMap(deleteConfig.TargetFolder); err != nil {
-					log.Fatal("Error populating fileModTimes:", err)
-				}
+			for _, warning := range AppConfig.Warnings {
+				log.Warningln(warning)
 			}
 
 			// Start watching for runtime changes
 			watcher, err := fsnotify.NewWatcher()
 			if err != nil {
-				panic(err)
+				return err
 			}
 			defer func(watcher *fsnotify.Watcher) {
 				err := watcher.Close()
 				if err != nil {
-					panic(err)
+					log.Errorln("Error closing watcher:", err)
 				}
 			}(watcher)
 
-			// Start the watcher goroutine
-			go func() {
-				for {
-					select {
-					case event := <-watcher.Events:
-						if event.Op&fsnotify.Create == fsnotify.Create {
-							if AppConfig.IsDetailedLogEnabled {
-								log.Debugln("File creation notification :: File " + event.Name)
-							}
-							for _, deleteConfig := range AppConfig.DeleteConfig {
-								if strings.HasPrefix(event.Name, deleteConfig.TargetFolder) {
-									HandleNewFile(event.Name)
-								}
-							}
-
-						}
-					case err := <-watcher.Errors:
-						if err != nil {
-							log.Println("Watcher error:", err)
-						}
-					}
-				}
-			}()
-
-			for _, deleteConfig := range AppConfig.DeleteConfig {
-				err = watcher.Add(deleteConfig.TargetFolder)
-				if err != nil {
-					log.Fatal(err)
-				}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			reloadCh := notifySignals(reloadSignals)
+			statusCh := notifySignals(statusSignals)
+
+			// Populate the rule indexes, watch the target folders and start the
+			// periodic deletion of old and excess files
+			d := &daemon{ctx: ctx, watcher: watcher, configPath: ConfigFilePath, startedAt: time.Now(), fileEvents: make(chan fsnotify.Event, fileEventQueueSize),
+				reloads: make(chan chan error), indexed: make(chan []*rule)}
+			go d.forwardFileEvents()
+			if HTTPAddress == "" {
+				HTTPAddress = AppConfig.HTTPAddress
 			}
-
-			deleteRetentionTickers := make([]*time.Ticker, len(AppConfig.DeleteConfig))
-			// Start a ticker for periodic deletion
-			for i, deleteConfig := range AppConfig.DeleteConfig {
-				ticker := time.NewTicker(time.Duration(deleteConfig.DeleteIntervalSeconds) * time.Second)
-				deleteRetentionTickers[i] = ticker
+			if HTTPAddress != "" {
+				if err := d.startHTTPServer(HTTPAddress); err != nil {
+					return fmt.Errorf("starting HTTP server: %w", err)
+				}
 			}
-
-			deleteExcessTickers := make([]*time.Ticker, len(AppConfig.DeleteConfig))
-			// Start a ticker for monitoring folder size and triggering deletion
-			for i, deleteConfig := range AppConfig.DeleteConfig {
-				ticker := time.NewTicker(time.Duration(deleteConfig.CheckSizeIntervalSecs) * time.Second)
-				deleteExcessTickers[i] = ticker
+			if socketPath, err := statusSocketPath(); err != nil {
+				log.Errorln("Not serving status:", err)
+			} else if err := d.startSocketServer(socketPath); err != nil {
+				log.Errorln("Not serving status:", err)
 			}
-			defer func() {
-				for _, ticker := range deleteExcessTickers {
-					ticker.Stop()
-				}
-				for _, ticker := range deleteRetentionTickers {
-					ticker.Stop()
-				}
-			}()
-
+			notifications.start()
+			digests.start(ctx)
+			d.applyConfig(AppConfig)
+			d.ready.Store(true)
+			d.watchConfigFile()
+
+			// Watcher events, signals and reloads are all handled on this
+			// goroutine, so AppConfig is only replaced while nothing reads it.
+			var reloadTimer <-chan time.Time
 			for {
-				for i, ticker := range deleteRetentionTickers {
-					select {
-					case <-ticker.C:
-						go DeleteOldFiles(AppConfig.DeleteConfig[i])
+				select {
+				case <-ctx.Done():
+					log.Infof("Shutting down, waiting up to %s for running cleanups", DrainTimeout)
+					d.stop()
+					persistFileIndex()
+					log.Infoln("FileCleanup stopped")
+					return nil
+				case event := <-d.fileEvents:
+					d.handleFileEvent(event)
+				case err := <-watcher.Errors:
+					if err != nil {
+						log.Println("Watcher error:", err)
+						errorsTotal.add(1, "", "watcher")
 					}
-				}
-				for i, ticker := range deleteExcessTickers {
-					select {
-					case <-ticker.C:
-						go DeleteExcessFiles(AppConfig.DeleteConfig[i])
+				case event := <-d.configEvents():
+					if d.isConfigEvent(event) {
+						reloadTimer = time.After(configReloadDelay)
 					}
+				case <-reloadTimer:
+					reloadTimer = nil
+					d.reloadConfig()
+				case <-reloadCh:
+					d.reloadConfig()
+				case reply := <-d.reloads:
+					reply <- d.reloadConfig()
+				case indexed := <-d.indexed:
+					d.startIndexed(indexed)
+				case <-statusCh:
+					d.logStatus()
 				}
 			}
-			<-make(chan struct{})
-		},
-		Args: func(cmd *cobra.Command, args []string) error {
-			if len(args) > 2 {
-				return errors.New("required at least 1 file to compare")
-			}
-			return nil
 		},
+		Args: cobra.NoArgs,
 	}
-	compareFlags(compareCmd)
-	return compareCmd
+	cleanFlags(cleanCmd)
+	return cleanCmd
 }
 
 func init() {
 	RootCmd.AddCommand(cleanCmd())
 }
-func compareFlags(cmd *cobra.Command) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatalln(err)
-		return
+func cleanFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&DrainTimeout, "drain-timeout", 30*time.Second, "How long to wait for running cleanups on shutdown or reload")
+	cmd.Flags().StringVar(&SocketPath, "socket", "", "Unix socket to answer the status and ctl commands on, overriding socket_path of the configuration")
+	cmd.Flags().StringVar(&HTTPAddress, "http-address", "", "Address to serve metrics, health and status on, such as 127.0.0.1:9464, overriding http_address of the configuration")
+	cmd.Flags().StringVarP(&ConfigFilePath, "file", "f", "", "Config file path")
+	_ = cmd.Flags().MarkDeprecated("file", "use --config instead")
+}
+
+// notifySignals relays sigs to the returned channel. The channel never
+// receives anything when sigs is empty.
+func notifySignals(sigs []os.Signal) <-chan os.Signal {
+	ch := make(chan os.Signal, 1)
+	if len(sigs) > 0 {
+		signal.Notify(ch, sigs...)
 	}
-	cmd.Flags().StringVarP(&ConfigFilePath, "file", "f", filepath.Join(home, ".fileCleanup", ".fileCleanup.json"), fmt.Sprintf("Config file path. default: %s", filepath.Join(home, ".fileCleanup", ".fileCleanup.json")))
+	return ch
 }
 
-func populateFileInfoMap(targetFolder string) error {
+func populateFileIndex(r *rule) error {
+	targetFolder := r.config.TargetFolder
 	if _, err := os.Stat(targetFolder); os.IsNotExist(err) {
-		log.Warningln("Target folder does not exist:", targetFolder)
+		r.logger().Warningln("Target folder does not exist:", targetFolder)
+		r.missing.Store(true)
 		return nil
 	}
-	if AppConfig.IsDetailedLogEnabled {
-		log.Traceln("Populating files to watch - folder:", targetFolder)
+	mutex.Lock()
+	detailed := AppConfig.IsDetailedLogEnabled
+	mutex.Unlock()
+	if detailed {
+		r.logger().Traceln("Populating files to watch - folder:", targetFolder)
 	}
-	err := filepath.Walk(targetFolder, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			fileInfoMap[path] = FileInfo{
-				Size:    info.Size(),
-				ModTime: info.ModTime(),
-			}
+	start := time.Now()
+	scanner := newFolderScanner(r.config.ScanConcurrency, r.index, nil)
+	scanner.visit = func(dir string) ([]string, error) {
+		if _, err := recordDir(dir); err != nil {
+			return nil, err
 		}
-		return nil
-	})
-	if err != nil {
+		return scanner.listDir(dir)
+	}
+	if err := scanner.Scan(filepath.Clean(targetFolder)); err != nil {
 		return err
 	}
+	r.logger().Infof("Indexed %s in %s - %d folders, %d files", targetFolder, time.Since(start), scanner.dirs.Load(), scanner.files.Load())
 	return nil
 }
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	constant "FileCleanup/const"
+	"FileCleanup/pkg"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testRule returns a rule over a temporary folder holding count files of size
+// bytes, the first one the oldest, with every file indexed.
+func testRule(t *testing.T, config pkg.DeleteConfig, count int, size int64, oldest time.Time, step time.Duration) *rule {
+	t.Helper()
+	config.TargetFolder = t.TempDir()
+	r := &rule{config: config, index: NewFileIndex()}
+	for i := range count {
+		path := filepath.Join(config.TargetFolder, fmt.Sprintf("file%02d.log", i))
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := oldest.Add(time.Duration(i) * step).Truncate(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		r.index.Put(path, FileInfo{Size: size, ModTime: modTime})
+	}
+	return r
+}
+
+// remaining returns the names of the files left in the target folder of r.
+func remaining(t *testing.T, r *rule) []string {
+	t.Helper()
+	entries, err := os.ReadDir(r.config.TargetFolder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestDeleteExcessFilesEvictsOldestFirst(t *testing.T) {
+	r := testRule(t, pkg.DeleteConfig{MaxFolderSizeMB: 1, DeleteWorkers: 2}, 6, constant.MB/2, time.Now().Add(-time.Hour), time.Minute)
+
+	result := DeleteExcessFiles(context.Background(), r)
+	if result.DeletedFiles != 4 || result.ReclaimedBytes != 2*constant.MB {
+		t.Errorf("deleted %d files of %d bytes, want 4 files of 2 MiB", result.DeletedFiles, result.ReclaimedBytes)
+	}
+	if result.FolderBytes > constant.MB || r.index.Size() != constant.MB {
+		t.Errorf("folder holds %d bytes after the run, want the 1 MiB limit", r.index.Size())
+	}
+	if names := remaining(t, r); fmt.Sprint(names) != "[file04.log file05.log]" {
+		t.Errorf("kept %v, want the two newest files", names)
+	}
+	if r.index.Len() != 2 {
+		t.Errorf("index holds %d files, want 2", r.index.Len())
+	}
+}
+
+func TestDeleteExcessFilesUnderLimit(t *testing.T) {
+	r := testRule(t, pkg.DeleteConfig{MaxFolderSizeMB: 10}, 3, 1024, time.Now().Add(-time.Hour), time.Minute)
+
+	if result := DeleteExcessFiles(context.Background(), r); result.DeletedFiles != 0 {
+		t.Errorf("deleted %d files from a folder under its limit", result.DeletedFiles)
+	}
+	if len(remaining(t, r)) != 3 {
+		t.Error("files were deleted from a folder under its limit")
+	}
+}
+
+func TestDeleteOldFilesStopsAtCutoff(t *testing.T) {
+	// Files modified 10, 9, ... 1 days ago with a retention of 5.5 days
+	r := testRule(t, pkg.DeleteConfig{RetentionDays: 5.5}, 10, 100, time.Now().Add(-10*24*time.Hour), 24*time.Hour)
+
+	result := DeleteOldFiles(context.Background(), r)
+	if result.DeletedFiles != 5 {
+		t.Errorf("deleted %d files, want the 5 older than the retention", result.DeletedFiles)
+	}
+	if names := remaining(t, r); fmt.Sprint(names) != "[file05.log file06.log file07.log file08.log file09.log]" {
+		t.Errorf("kept %v, want the 5 newest files", names)
+	}
+	if path, _, _ := r.index.Oldest(); filepath.Base(path) != "file05.log" {
+		t.Errorf("oldest indexed file is %s after the run, want file05.log", path)
+	}
+}
+
+func TestDeleteOldFilesMaxDeletesPerRun(t *testing.T) {
+	r := testRule(t, pkg.DeleteConfig{RetentionDays: 1, MaxDeletesPerRun: 3}, 8, 100, time.Now().Add(-30*24*time.Hour), time.Hour)
+
+	result := DeleteOldFiles(context.Background(), r)
+	if result.DeletedFiles != 3 || !result.Capped {
+		t.Errorf("deleted %d files, capped %v, want 3 and capped", result.DeletedFiles, result.Capped)
+	}
+	if r.index.Len() != 5 || len(remaining(t, r)) != 5 {
+		t.Errorf("index holds %d files and folder %d, want the 5 not deleted in both", r.index.Len(), len(remaining(t, r)))
+	}
+}
+
+func TestDeleteOldFilesSkipsFilesWrittenSinceIndexed(t *testing.T) {
+	r := testRule(t, pkg.DeleteConfig{RetentionDays: 7}, 3, 100, time.Now().Add(-10*24*time.Hour), time.Hour)
+	// The index still holds the old modification time of file01.log
+	rewritten := filepath.Join(r.config.TargetFolder, "file01.log")
+	if err := os.WriteFile(rewritten, []byte("written after it was indexed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := DeleteOldFiles(context.Background(), r)
+	if result.DeletedFiles != 2 {
+		t.Errorf("deleted %d files, want the 2 unchanged old ones", result.DeletedFiles)
+	}
+	if names := remaining(t, r); fmt.Sprint(names) != "[file01.log]" {
+		t.Errorf("kept %v, want the rewritten file", names)
+	}
+	info, ok := r.index.Get(rewritten)
+	if !ok || info.Size != int64(len("written after it was indexed")) || time.Since(info.ModTime) > time.Hour {
+		t.Errorf("rewritten file indexed as %+v, %v, want its current size and modification time", info, ok)
+	}
+}
+
+func TestDeleteExcessFilesReindexesChangedFiles(t *testing.T) {
+	r := testRule(t, pkg.DeleteConfig{MaxFolderSizeMB: 1}, 3, constant.MB/2, time.Now().Add(-time.Hour), time.Minute)
+	// file00.log shrank while keeping its modification time
+	oldest := filepath.Join(r.config.TargetFolder, "file00.log")
+	info, _ := r.index.Get(oldest)
+	if err := os.Truncate(oldest, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(oldest, info.ModTime, info.ModTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// Popping file00.log brings the index under the limit, so the run ends
+	// after handing it back at its current 10 bytes
+	if result := DeleteExcessFiles(context.Background(), r); result.DeletedFiles != 0 {
+		t.Errorf("deleted %d files, want the changed file to be skipped", result.DeletedFiles)
+	}
+	if info, ok := r.index.Get(oldest); !ok || info.Size != 10 {
+		t.Fatalf("file00.log indexed as %+v, %v, want its current 10 bytes", info, ok)
+	}
+
+	result := DeleteExcessFiles(context.Background(), r)
+	if result.DeletedFiles != 1 || result.ReclaimedBytes != 10 {
+		t.Errorf("deleted %d files of %d bytes, want file00.log at its current 10 bytes", result.DeletedFiles, result.ReclaimedBytes)
+	}
+	if names := remaining(t, r); fmt.Sprint(names) != "[file01.log file02.log]" {
+		t.Errorf("kept %v", names)
+	}
+}
@@ -0,0 +1,420 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// The block completion install adds to a shell rc file is enclosed in these
+// lines, so it can be updated and removed without touching the rest.
+const (
+	completionBlockStart = "# >>> FileCleanup completion >>>"
+	completionBlockEnd   = "# <<< FileCleanup completion <<<"
+)
+
+// completionShells lists the shells completion can be installed for.
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// completionTarget is where completion for a shell is installed: a script
+// file holding the completion and a marked block in an rc file, either of
+// which may be unused.
+type completionTarget struct {
+	shell  string
+	script string
+	rcFile string
+	block  []string
+	// legacy lists lines the setup of earlier versions appended to rcFile
+	// without markers, removed on install and uninstall.
+	legacy []string
+}
+
+func newCompletionTarget(shell, home string) (completionTarget, error) {
+	name := RootCmd.Name()
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if !filepath.IsAbs(configHome) {
+		configHome = filepath.Join(home, ".config")
+	}
+	switch shell {
+	case "bash":
+		line := fmt.Sprintf("source <(%s completion bash)", name)
+		return completionTarget{
+			shell:  shell,
+			rcFile: filepath.Join(home, ".bashrc"),
+			block:  []string{fmt.Sprintf("command -v %s >/dev/null 2>&1 && %s", name, line)},
+			legacy: []string{line},
+		}, nil
+	case "zsh":
+		rcDir := os.Getenv("ZDOTDIR")
+		if rcDir == "" {
+			rcDir = home
+		}
+		dir := filepath.Join(home, ".zsh", "completions")
+		return completionTarget{
+			shell:  shell,
+			script: filepath.Join(dir, "_"+name),
+			rcFile: filepath.Join(rcDir, ".zshrc"),
+			block:  []string{fmt.Sprintf("fpath=(%s $fpath)", dir), "autoload -Uz compinit && compinit"},
+		}, nil
+	case "fish":
+		// fish loads the completions folder by itself
+		return completionTarget{
+			shell:  shell,
+			script: filepath.Join(configHome, "fish", "completions", name+".fish"),
+		}, nil
+	case "powershell":
+		profile := filepath.Join(configHome, "powershell", "Microsoft.PowerShell_profile.ps1")
+		if runtime.GOOS == "windows" {
+			profile = filepath.Join(home, "Documents", "PowerShell", "Microsoft.PowerShell_profile.ps1")
+		}
+		line := fmt.Sprintf("%s completion powershell | Out-String | Invoke-Expression", name)
+		return completionTarget{
+			shell:  shell,
+			rcFile: profile,
+			block:  []string{line},
+			legacy: []string{line},
+		}, nil
+	}
+	return completionTarget{}, fmt.Errorf("unsupported shell %q, use one of %s", shell, strings.Join(completionShells, ", "))
+}
+
+// files lists the files the target writes to.
+func (t completionTarget) files() []string {
+	var files []string
+	for _, file := range []string{t.script, t.rcFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// installed reports whether completion is installed for the shell.
+func (t completionTarget) installed() bool {
+	if t.script != "" {
+		if _, err := os.Stat(t.script); err != nil {
+			return false
+		}
+	}
+	if t.rcFile != "" {
+		data, err := os.ReadFile(t.rcFile)
+		if err != nil {
+			return false
+		}
+		_, _, found := cutBlock(string(data))
+		return found
+	}
+	return true
+}
+
+func (t completionTarget) install() error {
+	if t.script != "" {
+		var buf bytes.Buffer
+		if err := genCompletion(t.shell, &buf); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(t.script), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(t.script, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	if t.rcFile == "" {
+		return nil
+	}
+	return editRCFile(t.rcFile, func(content string) string {
+		content = stripCompletion(content, t.legacy)
+		if content != "" {
+			if !strings.HasSuffix(content, "\n") {
+				content += "\n"
+			}
+			// The blank line separating the block is removed with it
+			content += "\n"
+		}
+		return content + completionBlockStart + "\n" + strings.Join(t.block, "\n") + "\n" + completionBlockEnd + "\n"
+	})
+}
+
+// uninstall removes what install wrote, reporting whether there was anything
+// to remove.
+func (t completionTarget) uninstall() (bool, error) {
+	removed := false
+	if t.script != "" {
+		if err := os.Remove(t.script); err == nil {
+			removed = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+	}
+	if t.rcFile == "" {
+		return removed, nil
+	}
+	if _, err := os.Stat(t.rcFile); errors.Is(err, os.ErrNotExist) {
+		return removed, nil
+	}
+	err := editRCFile(t.rcFile, func(content string) string {
+		edited := stripCompletion(content, t.legacy)
+		removed = removed || edited != content
+		return edited
+	})
+	return removed, err
+}
+
+// editRCFile replaces the content of the rc file at path with edit's result,
+// keeping its permissions. The file is created when missing.
+func editRCFile(path string, edit func(content string) string) error {
+	mode := os.FileMode(0o644)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if stat, err := os.Stat(path); err == nil {
+		mode = stat.Mode().Perm()
+	}
+	edited := edit(string(data))
+	if edited == string(data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(edited), mode)
+}
+
+// cutBlock splits content around the first marked completion block. The
+// block starts at the last start marker before its end marker, so a start
+// marker whose end was deleted by hand never swallows the lines after it.
+func cutBlock(content string) (before, after string, found bool) {
+	end := strings.Index(content, completionBlockEnd)
+	if end < 0 {
+		return content, "", false
+	}
+	start := strings.LastIndex(content[:end], completionBlockStart)
+	if start < 0 {
+		return content, "", false
+	}
+	after = content[end+len(completionBlockEnd):]
+	return content[:start], strings.TrimPrefix(after, "\n"), true
+}
+
+// stripBlock removes the first marked completion block and the blank line
+// install wrote before it.
+func stripBlock(content string) string {
+	before, after, found := cutBlock(content)
+	if !found {
+		return content
+	}
+	if strings.HasSuffix(before, "\n\n") {
+		before = strings.TrimSuffix(before, "\n")
+	}
+	return before + after
+}
+
+// stripCompletion removes every marked completion block, the markers left
+// over from blocks edited by hand and the legacy lines from content.
+func stripCompletion(content string, legacy []string) string {
+	for {
+		stripped := stripBlock(content)
+		if stripped == content {
+			break
+		}
+		content = stripped
+	}
+	return removeLines(content, append([]string{completionBlockStart, completionBlockEnd}, legacy...))
+}
+
+// removeLines removes the lines of content equal to one of lines.
+func removeLines(content string, lines []string) string {
+	if len(lines) == 0 {
+		return content
+	}
+	kept := strings.SplitAfter(content, "\n")[:0]
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		legacy := false
+		for _, l := range lines {
+			legacy = legacy || trimmed == l
+		}
+		if !legacy {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+func genCompletion(shell string, w io.Writer) error {
+	switch shell {
+	case "bash":
+		return RootCmd.GenBashCompletionV2(w, true)
+	case "zsh":
+		return RootCmd.GenZshCompletion(w)
+	case "fish":
+		return RootCmd.GenFishCompletion(w, true)
+	case "powershell":
+		return RootCmd.GenPowerShellCompletionWithDesc(w)
+	}
+	return fmt.Errorf("unsupported shell %q", shell)
+}
+
+// currentShell returns the shell FileCleanup runs in, as named by
+// completionShells.
+func currentShell() string {
+	shell := pkg.DetectShell()
+	if shell == "pwsh" {
+		return "powershell"
+	}
+	return shell
+}
+
+// completionShellArg returns the shell named by args, defaulting to the
+// current shell.
+func completionShellArg(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	shell := currentShell()
+	for _, known := range completionShells {
+		if shell == known {
+			return shell, nil
+		}
+	}
+	return "", fmt.Errorf("cannot install completion for shell %q, name one of %s", shell, strings.Join(completionShells, ", "))
+}
+
+// installCompletion installs completion for shell in home.
+func installCompletion(cmd *cobra.Command, shell, home string) error {
+	target, err := newCompletionTarget(shell, home)
+	if err != nil {
+		return err
+	}
+	if err := target.install(); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Installed %s completion in %s, open a new shell to use it\n", shell, strings.Join(target.files(), " and "))
+	return nil
+}
+
+func completionCmd() *cobra.Command {
+	var completionCmd = &cobra.Command{
+		Use:   "completion",
+		Short: "Print, install or remove the shell completion script",
+		Long: "Print the completion script of a shell, or install it for the current user. " +
+			"install adds a block enclosed in \"" + completionBlockStart + "\" markers to the shell rc file, uninstall removes it again.",
+		// Completion needs no configuration
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	}
+	for _, shell := range completionShells {
+		shell := shell
+		completionCmd.AddCommand(&cobra.Command{
+			Use:   shell,
+			Short: "Print the " + shell + " completion script",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return genCompletion(shell, cmd.OutOrStdout())
+			},
+		})
+	}
+	completionCmd.AddCommand(completionInstallCmd())
+	completionCmd.AddCommand(completionUninstallCmd())
+	completionCmd.AddCommand(completionStatusCmd())
+	return completionCmd
+}
+
+func completionInstallCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "install [SHELL]",
+		Short:     "Install completion for a shell, the current one by default",
+		Example:   `fileCleanup completion install zsh`,
+		Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: completionShells,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			shell, err := completionShellArg(args)
+			if err != nil {
+				return err
+			}
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			return installCompletion(cmd, shell, home)
+		},
+	}
+}
+
+func completionUninstallCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "uninstall [SHELL]",
+		Short:     "Remove completion from a shell, from every shell by default",
+		Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: completionShells,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			shells := completionShells
+			if len(args) > 0 {
+				shells = args
+			}
+			for _, shell := range shells {
+				target, err := newCompletionTarget(shell, home)
+				if err != nil {
+					return err
+				}
+				removed, err := target.uninstall()
+				if err != nil {
+					return err
+				}
+				if removed {
+					fmt.Fprintf(cmd.OutOrStdout(), "Removed %s completion from %s\n", shell, strings.Join(target.files(), " and "))
+				} else if len(args) > 0 {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s completion is not installed\n", shell)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func completionStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the shells completion is installed for",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			current := currentShell()
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SHELL\tINSTALLED\tFILES")
+			for _, shell := range completionShells {
+				target, err := newCompletionTarget(shell, home)
+				if err != nil {
+					return err
+				}
+				name := shell
+				if shell == current {
+					name += " (current)"
+				}
+				fmt.Fprintf(w, "%s\t%t\t%s\n", name, target.installed(), strings.Join(target.files(), ", "))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(completionCmd())
+}
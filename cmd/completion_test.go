@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const bashBlock = completionBlockStart + "\ncommand -v FileCleanup >/dev/null 2>&1 && source <(FileCleanup completion bash)\n" + completionBlockEnd + "\n"
+
+// bashTarget returns the bash completion target of a temporary home whose
+// .bashrc holds content.
+func bashTarget(t *testing.T, content string) completionTarget {
+	t.Helper()
+	target, err := newCompletionTarget("bash", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target.rcFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+func readRCFile(t *testing.T, target completionTarget) string {
+	t.Helper()
+	data, err := os.ReadFile(target.rcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCompletionInstallUninstall(t *testing.T) {
+	tests := []struct {
+		name      string
+		original  string
+		installed string
+		// uninstalled is the content after uninstall, the original when empty
+		uninstalled string
+	}{
+		{"empty file", "", bashBlock, ""},
+		{"content", "export EDITOR=vim\n", "export EDITOR=vim\n\n" + bashBlock, ""},
+		{"trailing blank lines", "export EDITOR=vim\n\n\n", "export EDITOR=vim\n\n\n\n" + bashBlock, ""},
+		{"no final newline", "export EDITOR=vim", "export EDITOR=vim\n\n" + bashBlock, "export EDITOR=vim\n"},
+		{"legacy line", "export EDITOR=vim\nsource <(FileCleanup completion bash)\nalias ll='ls -l'\n",
+			"export EDITOR=vim\nalias ll='ls -l'\n\n" + bashBlock, "export EDITOR=vim\nalias ll='ls -l'\n"},
+		{"unterminated block", "export EDITOR=vim\n" + completionBlockStart + "\nalias ll='ls -l'\n",
+			"export EDITOR=vim\nalias ll='ls -l'\n\n" + bashBlock, "export EDITOR=vim\nalias ll='ls -l'\n"},
+		{"stray end marker", "export EDITOR=vim\n" + completionBlockEnd + "\nalias ll='ls -l'\n",
+			"export EDITOR=vim\nalias ll='ls -l'\n\n" + bashBlock, "export EDITOR=vim\nalias ll='ls -l'\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := bashTarget(t, test.original)
+			if target.installed() {
+				t.Error("reported as installed before install")
+			}
+			for range 2 {
+				if err := target.install(); err != nil {
+					t.Fatal(err)
+				}
+				if got := readRCFile(t, target); got != test.installed {
+					t.Fatalf("installed as %q, want %q", got, test.installed)
+				}
+			}
+			if !target.installed() {
+				t.Error("not reported as installed")
+			}
+			if stat, err := os.Stat(target.rcFile); runtime.GOOS != "windows" && (err != nil || stat.Mode().Perm() != 0o600) {
+				t.Errorf("rc file mode changed: %v, %v", stat.Mode(), err)
+			}
+
+			removed, err := target.uninstall()
+			if err != nil || !removed {
+				t.Fatalf("uninstall removed %v, %v", removed, err)
+			}
+			want := test.uninstalled
+			if want == "" {
+				want = test.original
+			}
+			if got := readRCFile(t, target); got != want {
+				t.Errorf("uninstalled to %q, want %q", got, want)
+			}
+			if removed, err := target.uninstall(); err != nil || removed {
+				t.Errorf("second uninstall removed %v, %v", removed, err)
+			}
+		})
+	}
+}
+
+func TestCompletionUninstallKeepsContentAroundBlock(t *testing.T) {
+	original := "# .bashrc\nexport EDITOR=vim\n"
+	target := bashTarget(t, original)
+	if err := target.install(); err != nil {
+		t.Fatal(err)
+	}
+	// Lines added after the block by hand or by other tools
+	after := "\n# >>> conda initialize >>>\nconda activate\n# <<< conda initialize <<<\n"
+	if err := os.WriteFile(target.rcFile, []byte(readRCFile(t, target)+after), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reinstalling replaces the block in place of appending a second one
+	if err := target.install(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRCFile(t, target); strings.Count(got, completionBlockStart) != 1 {
+		t.Errorf("reinstalled to %q", got)
+	}
+	if _, err := target.uninstall(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRCFile(t, target); got != original+after {
+		t.Errorf("uninstalled to %q, want %q", got, original+after)
+	}
+}
+
+func TestCompletionUninstallWithoutRCFile(t *testing.T) {
+	target, err := newCompletionTarget("bash", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := target.uninstall(); err != nil || removed {
+		t.Errorf("uninstall removed %v, %v", removed, err)
+	}
+	if _, err := os.Stat(target.rcFile); !os.IsNotExist(err) {
+		t.Error("uninstall created the rc file")
+	}
+}
+
+func TestCompletionScriptTargets(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("ZDOTDIR", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	zsh, err := newCompletionTarget("zsh", home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fish, err := newCompletionTarget("fish", home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zsh.rcFile != filepath.Join(home, ".zshrc") || fish.script != filepath.Join(home, ".config", "fish", "completions", "FileCleanup.fish") || fish.rcFile != "" {
+		t.Fatalf("zsh %+v, fish %+v", zsh, fish)
+	}
+
+	for _, target := range []completionTarget{zsh, fish} {
+		if err := target.install(); err != nil {
+			t.Fatal(err)
+		}
+		if !target.installed() {
+			t.Errorf("%s not reported as installed", target.shell)
+		}
+		if script, err := os.ReadFile(target.script); err != nil || !strings.Contains(string(script), "FileCleanup") {
+			t.Errorf("%s script %s not written: %v", target.shell, target.script, err)
+		}
+	}
+	if got := readRCFile(t, zsh); !strings.Contains(got, "fpath=("+filepath.Join(home, ".zsh", "completions")+" $fpath)") {
+		t.Errorf(".zshrc holds %q", got)
+	}
+
+	// A deleted script means completion is no longer installed
+	if err := os.Remove(zsh.script); err != nil {
+		t.Fatal(err)
+	}
+	if zsh.installed() {
+		t.Error("zsh reported as installed without its script")
+	}
+	for _, target := range []completionTarget{zsh, fish} {
+		if removed, err := target.uninstall(); err != nil || !removed {
+			t.Errorf("%s uninstall removed %v, %v", target.shell, removed, err)
+		}
+	}
+	if got := readRCFile(t, zsh); got != "" {
+		t.Errorf(".zshrc left with %q", got)
+	}
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func configCmd() *cobra.Command {
+	var cfgCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Manage the configuration file",
+	}
+	cfgCmd.AddCommand(configInitCmd())
+	cfgCmd.AddCommand(configValidateCmd())
+	cfgCmd.AddCommand(configSchemaCmd())
+	cfgCmd.AddCommand(configMigrateCmd())
+	return cfgCmd
+}
+
+func configValidateCmd() *cobra.Command {
+	var validateCmd = &cobra.Command{
+		Use:     "validate [FILE]",
+		Short:   "Check the configuration file for errors",
+		Long:    "Check every rule of the configuration file for invalid or conflicting values, overlapping target folders and target folders that are files. Each problem is reported with its JSON path and line number, missing target folders are only warnings. FILE defaults to the --config path.",
+		Example: `fileCleanup config validate /etc/fileCleanup/config.yaml`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := ConfigFilePath
+			if len(args) == 1 {
+				path = args[0]
+			}
+			config, err := pkg.LoadConfig(path)
+			if err != nil {
+				return err
+			}
+			for _, warning := range append(config.Warnings, config.FolderWarnings()...) {
+				fmt.Println("warning:", warning)
+			}
+			err = pkg.ValidateFile(path, config)
+			var problems pkg.ValidationErrors
+			if !errors.As(err, &problems) {
+				if err != nil {
+					return err
+				}
+				fmt.Println("Configuration", path, "is valid")
+				return nil
+			}
+			for _, problem := range problems {
+				fmt.Println(problem.Error())
+			}
+			return fmt.Errorf("found %d problems in %s", len(problems), path)
+		},
+	}
+	return validateCmd
+}
+
+func configSchemaCmd() *cobra.Command {
+	var output string
+	var schemaCmd = &cobra.Command{
+		Use:     "schema [...FLAGS]",
+		Short:   "Print the JSON Schema of the configuration file",
+		Long:    "Print the JSON Schema of the configuration file, for editors and configuration management tools. Generated JSON configuration files reference the published copy with their $schema key.",
+		Example: `fileCleanup config schema -o config.schema.json`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := pkg.JSONSchema()
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				_, err = cmd.OutOrStdout().Write(data)
+				return err
+			}
+			return os.WriteFile(output, data, 0o644)
+		},
+	}
+	schemaCmd.Flags().StringVarP(&output, "output", "o", "", "File to write the schema to instead of stdout")
+	return schemaCmd
+}
+
+func configMigrateCmd() *cobra.Command {
+	var dryRun bool
+	var migrateCmd = &cobra.Command{
+		Use:     "migrate [FILE]",
+		Short:   "Upgrade the configuration file to the current version",
+		Long:    "Upgrade the configuration file to the current version of the format. The original file is kept next to it with a .v<version>.bak suffix. FILE defaults to the --config path.",
+		Example: `fileCleanup config migrate --dry-run`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := ConfigFilePath
+			if len(args) == 1 {
+				path = args[0]
+			}
+			version, notes, err := pkg.MigrateConfigFile(path, dryRun)
+			if err != nil {
+				return err
+			}
+			if version == pkg.CurrentConfigVersion {
+				fmt.Printf("Configuration %s is already at version %d\n", path, version)
+				return nil
+			}
+			for _, note := range notes {
+				fmt.Println(note)
+			}
+			if dryRun {
+				fmt.Printf("Configuration %s would be migrated from version %d to %d\n", path, version, pkg.CurrentConfigVersion)
+			} else {
+				fmt.Printf("Migrated configuration %s from version %d to %d\n", path, version, pkg.CurrentConfigVersion)
+			}
+			return nil
+		},
+	}
+	migrateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the changes without writing the file")
+	return migrateCmd
+}
+
+func init() {
+	RootCmd.AddCommand(configCmd())
+}
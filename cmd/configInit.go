@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	constant "FileCleanup/const"
+	"FileCleanup/pkg"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// initSettings are the answers of the config init wizard, also settable with
+// flags. Durations and sizes take the forms of the configuration file.
+type initSettings struct {
+	name           string
+	targetFolder   string
+	retention      string
+	maxSize        string
+	deleteInterval string
+	checkInterval  string
+	logFilePath    string
+}
+
+func defaultInitSettings() initSettings {
+	return initSettings{
+		retention:      "5d",
+		maxSize:        "1000MiB",
+		deleteInterval: "1d",
+		checkInterval:  "12h",
+		logFilePath:    pkg.DefaultConfig().LogFilePath,
+	}
+}
+
+// config builds the configuration the settings describe. Without a target
+// folder the example rule of DefaultConfig is kept.
+func (s initSettings) config() (pkg.Config, error) {
+	config := pkg.DefaultConfig()
+	config.LogFilePath = s.logFilePath
+	rule := &config.DeleteConfig[0]
+	if s.targetFolder != "" {
+		rule.TargetFolder = s.targetFolder
+		rule.Name = filepath.Base(filepath.Clean(s.targetFolder))
+	}
+	if s.name != "" {
+		rule.Name = s.name
+	}
+
+	retention, err := pkg.ParseDuration(s.retention)
+	if err != nil {
+		return pkg.Config{}, err
+	}
+	rule.RetentionDays = retention.Hours() / 24
+	maxSize, err := pkg.ParseSize(s.maxSize)
+	if err != nil {
+		return pkg.Config{}, err
+	}
+	if rule.MaxFolderSizeMB, err = pkg.WholeUnits(s.maxSize, maxSize, constant.MB); err != nil {
+		return pkg.Config{}, err
+	}
+	deleteInterval, err := pkg.ParseDuration(s.deleteInterval)
+	if err != nil {
+		return pkg.Config{}, err
+	}
+	seconds, err := pkg.WholeUnits(s.deleteInterval, int64(deleteInterval), int64(time.Second))
+	if err != nil {
+		return pkg.Config{}, err
+	}
+	rule.DeleteIntervalSeconds = int(seconds)
+	checkInterval, err := pkg.ParseDuration(s.checkInterval)
+	if err != nil {
+		return pkg.Config{}, err
+	}
+	if seconds, err = pkg.WholeUnits(s.checkInterval, int64(checkInterval), int64(time.Second)); err != nil {
+		return pkg.Config{}, err
+	}
+	rule.CheckSizeIntervalSecs = int(seconds)
+	return config, nil
+}
+
+// prompt asks for every setting, offering the current values as defaults.
+func (s *initSettings) prompt(p *prompter) error {
+	for {
+		folder, err := p.askValid("Folder to clean up", s.targetFolder, func(answer string) error {
+			if answer == "" {
+				return errors.New("a folder is required")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		expanded, err := pkg.ExpandValue(folder)
+		if err != nil {
+			fmt.Fprintln(p.out, err)
+			continue
+		}
+		if stat, err := os.Stat(expanded); err == nil && !stat.IsDir() {
+			fmt.Fprintln(p.out, expanded, "is not a folder")
+			continue
+		} else if errors.Is(err, os.ErrNotExist) {
+			create, err := p.confirm(fmt.Sprintf("Folder %s does not exist, create it?", expanded), true)
+			if err != nil {
+				return err
+			}
+			if !create {
+				continue
+			}
+			if err := os.MkdirAll(expanded, 0o755); err != nil {
+				fmt.Fprintln(p.out, err)
+				continue
+			}
+		}
+		s.targetFolder = folder
+		break
+	}
+
+	name := s.name
+	if name == "" {
+		name = filepath.Base(filepath.Clean(s.targetFolder))
+	}
+	var err error
+	if s.name, err = p.ask("Rule name", name); err != nil {
+		return err
+	}
+	parseDuration := func(answer string) error {
+		_, err := pkg.ParseDuration(answer)
+		return err
+	}
+	parseSeconds := func(answer string) error {
+		d, err := pkg.ParseDuration(answer)
+		if err == nil {
+			_, err = pkg.WholeUnits(answer, int64(d), int64(time.Second))
+		}
+		return err
+	}
+	parseSize := func(answer string) error {
+		size, err := pkg.ParseSize(answer)
+		if err == nil {
+			_, err = pkg.WholeUnits(answer, size, constant.MB)
+		}
+		return err
+	}
+	if s.retention, err = p.askValid("Delete files older than", s.retention, parseDuration); err != nil {
+		return err
+	}
+	if s.maxSize, err = p.askValid("Delete the oldest files while the folder is larger than", s.maxSize, parseSize); err != nil {
+		return err
+	}
+	if s.deleteInterval, err = p.askValid("Look for old files every", s.deleteInterval, parseSeconds); err != nil {
+		return err
+	}
+	if s.checkInterval, err = p.askValid("Check the folder size every", s.checkInterval, parseSeconds); err != nil {
+		return err
+	}
+	s.logFilePath, err = p.ask("Folder to write the log to", s.logFilePath)
+	return err
+}
+
+// writeInitConfig writes the configuration settings describe to output,
+// asking for the settings first when interactive.
+func writeInitConfig(cmd *cobra.Command, settings initSettings, format, output string, interactive bool) error {
+	if interactive {
+		if err := settings.prompt(newPrompter(os.Stdin, cmd.OutOrStdout())); err != nil {
+			return err
+		}
+	} else if settings.targetFolder != "" {
+		if folder, err := pkg.ExpandValue(settings.targetFolder); err == nil {
+			if _, err := os.Stat(folder); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), "warning: target folder", folder, "does not exist")
+			}
+		}
+	}
+	config, err := settings.config()
+	if err != nil {
+		return err
+	}
+
+	data, err := pkg.MarshalConfig(config, format)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(output, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Created configuration file", output)
+	if settings.targetFolder == "" {
+		fmt.Fprintln(cmd.OutOrStdout(), "Set target_folder of the example rule before running clean")
+	}
+	return nil
+}
+
+func configInitCmd() *cobra.Command {
+	var (
+		format         string
+		output         string
+		force          bool
+		nonInteractive bool
+	)
+	settings := defaultInitSettings()
+	var initCmd = &cobra.Command{
+		Use:   "init [...FLAGS]",
+		Short: "Create a configuration file",
+		Long: "Create a configuration file. On a terminal the settings of the first rule are asked for, offering the flag values as defaults. " +
+			"With --non-interactive, or when not run on a terminal, the flags are used as given and the example rule is kept when --target-folder is not set.",
+		Example: `fileCleanup config init
+fileCleanup config init --non-interactive --target-folder /var/log/app --retention 2w --max-size 10GiB --format yaml`,
+		Args: cobra.NoArgs,
+		// The file to create may be missing or broken, so it is not loaded.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return resolveConfigFilePath() },
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format = strings.ToLower(format)
+			if output == "" {
+				output = strings.TrimSuffix(ConfigFilePath, filepath.Ext(ConfigFilePath)) + "." + format
+			}
+			if detected, err := pkg.ConfigFormat(output); err != nil {
+				return err
+			} else if detected != format {
+				return fmt.Errorf("output file %s does not match format %s", output, format)
+			}
+			if _, err := os.Stat(output); err == nil && !force {
+				return fmt.Errorf("%s already exists, use --force to overwrite it", output)
+			} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+
+			return writeInitConfig(cmd, settings, format, output, !nonInteractive && isTerminal(os.Stdin))
+		},
+	}
+	flags := initCmd.Flags()
+	flags.StringVar(&format, "format", pkg.FormatJSON, "Configuration format: "+strings.Join(pkg.ConfigFormats, ", "))
+	_ = initCmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(pkg.ConfigFormats, cobra.ShellCompDirectiveNoFileComp))
+	flags.StringVarP(&output, "output", "o", "", "File to write, defaults to the --config path with the extension of --format")
+	flags.BoolVar(&force, "force", false, "Overwrite an existing file")
+	flags.BoolVar(&nonInteractive, "non-interactive", false, "Do not prompt, use the flags as given")
+	flags.StringVar(&settings.name, "name", "", "Name of the rule, defaults to the name of the target folder")
+	flags.StringVar(&settings.targetFolder, "target-folder", "", "Folder to clean up")
+	_ = initCmd.MarkFlagDirname("target-folder")
+	flags.StringVar(&settings.retention, "retention", settings.retention, "Delete files older than this duration")
+	flags.StringVar(&settings.maxSize, "max-size", settings.maxSize, "Delete the oldest files while the folder is larger than this size")
+	flags.StringVar(&settings.deleteInterval, "delete-interval", settings.deleteInterval, "How often old files are deleted")
+	flags.StringVar(&settings.checkInterval, "check-interval", settings.checkInterval, "How often the folder size is checked")
+	flags.StringVar(&settings.logFilePath, "log-file-path", settings.logFilePath, "Folder the log is written to")
+	return initCmd
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import "testing"
+
+func TestInitSettingsRoundLikeTheLoader(t *testing.T) {
+	settings := defaultInitSettings()
+	settings.maxSize, settings.deleteInterval, settings.checkInterval = "1.4MiB", "90.4s", "1.5s"
+	config, err := settings.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := config.DeleteConfig[0]
+	if rule.MaxFolderSizeMB != 1 || rule.DeleteIntervalSeconds != 90 || rule.CheckSizeIntervalSecs != 2 {
+		t.Errorf("rounded to %d MB, %ds and %ds, want 1 MB, 90s and 2s", rule.MaxFolderSizeMB, rule.DeleteIntervalSeconds, rule.CheckSizeIntervalSecs)
+	}
+
+	for _, settings := range []initSettings{
+		{retention: "5d", maxSize: "500KB", deleteInterval: "1d", checkInterval: "1h"},
+		{retention: "5d", maxSize: "1GiB", deleteInterval: "400ms", checkInterval: "1h"},
+	} {
+		if _, err := settings.config(); err == nil {
+			t.Errorf("%+v: expected a value rounding to 0 to be rejected", settings)
+		}
+	}
+}
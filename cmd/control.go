@@ -0,0 +1,295 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// jobRunResult is the outcome of running a job on request.
+type jobRunResult struct {
+	Job    jobKind   `json:"job"`
+	Result runResult `json:"result"`
+}
+
+// plannedDeletion is a file a run would delete.
+type plannedDeletion struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// dryRunResult lists the files a job would delete if it ran now. CapReached
+// is set when max_deletes_per_run would stop the run before the job is done.
+type dryRunResult struct {
+	Rule        string            `json:"rule"`
+	Job         jobKind           `json:"job"`
+	FolderBytes int64             `json:"folder_bytes"`
+	LimitBytes  int64             `json:"limit_bytes,omitempty"`
+	Cutoff      *time.Time        `json:"cutoff,omitempty"`
+	TotalFiles  int               `json:"total_files"`
+	TotalBytes  int64             `json:"total_bytes"`
+	CapReached  bool              `json:"cap_reached,omitempty"`
+	Files       []plannedDeletion `json:"files"`
+}
+
+// controlRoutes adds the control API to mux, every route wrapped in wrap.
+func (d *daemon) controlRoutes(mux *http.ServeMux, wrap func(http.HandlerFunc) http.HandlerFunc) {
+	mux.HandleFunc("POST /rules/{name}/run", wrap(d.serveRun))
+	mux.HandleFunc("POST /rules/{name}/pause", wrap(d.servePause(true)))
+	mux.HandleFunc("POST /rules/{name}/resume", wrap(d.servePause(false)))
+	mux.HandleFunc("GET /rules/{name}/dry-run", wrap(d.serveDryRun))
+	mux.HandleFunc("POST /reload", wrap(d.serveReload))
+	mux.HandleFunc("GET /events", wrap(d.serveEvents))
+	mux.HandleFunc("POST /digest", wrap(d.serveDigest))
+}
+
+// requireToken lets requests through that carry control_token as a bearer
+// token. Without a control_token the control API is not served over HTTP.
+func requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		token := AppConfig.ControlToken
+		mutex.Unlock()
+		if token == "" {
+			writeError(w, http.StatusForbidden, errors.New("the control API is only served on the Unix socket, set control_token to serve it over HTTP"))
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="FileCleanup"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+		next(w, req)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// findActiveRule returns the active rule named name, by its name or its target
+// folder.
+func findActiveRule(name string) (*rule, error) {
+	active := activeRules()
+	for _, r := range active {
+		if r.config.Name == name {
+			return r, nil
+		}
+	}
+	for _, r := range active {
+		if filepath.Clean(r.config.TargetFolder) == filepath.Clean(name) {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("no active rule named %s", name)
+}
+
+// requestJobs returns the rule of the request and its jobs named by the job
+// query parameter, all of them when it is not set.
+func requestJobs(req *http.Request) (*rule, []*scheduledJob, error) {
+	r, err := findActiveRule(req.PathValue("name"))
+	if err != nil {
+		return nil, nil, err
+	}
+	kind := req.URL.Query().Get("job")
+	if kind == "" {
+		return r, r.sched.jobs, nil
+	}
+	job := r.sched.job(jobKind(kind))
+	if job == nil {
+		return nil, nil, fmt.Errorf("rule %s has no %s job, expected %s or %s", r.config.DisplayName(), kind, retentionJob, sizeJob)
+	}
+	return r, []*scheduledJob{job}, nil
+}
+
+func (d *daemon) serveRun(w http.ResponseWriter, req *http.Request) {
+	r, jobs, err := requestJobs(req)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if !r.indexed.Load() {
+		writeError(w, http.StatusConflict, fmt.Errorf("rule %s is still indexing", r.config.DisplayName()))
+		return
+	}
+	r.logger().Infoln("Running cleanup on request")
+	results := make([]jobRunResult, 0, len(jobs))
+	for _, job := range jobs {
+		result, err := job.runNow(req.Context())
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		results = append(results, jobRunResult{Job: job.kind, Result: result})
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (d *daemon) servePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		r, err := findActiveRule(req.PathValue("name"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		state, event, message := "active", eventRuleResumed, "Resumed on request"
+		if paused {
+			state, event, message = "paused", eventRulePaused, "Paused on request, scheduled cleanups are skipped"
+		}
+		if r.paused.Swap(paused) != paused {
+			r.logger().Infoln(message)
+			events.publish(daemonEvent{Type: event, Rule: r.config.DisplayName()})
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"rule": r.config.DisplayName(), "state": state})
+	}
+}
+
+func (d *daemon) serveDryRun(w http.ResponseWriter, req *http.Request) {
+	r, jobs, err := requestJobs(req)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if !r.indexed.Load() {
+		writeError(w, http.StatusConflict, fmt.Errorf("rule %s is still indexing", r.config.DisplayName()))
+		return
+	}
+	limit := -1
+	if value := req.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit: %w", err))
+			return
+		}
+	}
+	results := make([]dryRunResult, 0, len(jobs))
+	for _, job := range jobs {
+		result, err := r.dryRun(job.kind, time.Now(), limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		results = append(results, result)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// dryRun returns the files the job of kind would delete from the current
+// index if it ran at now, oldest first, listing at most files of them unless
+// files is negative. Like a run, the plan stops at max_deletes_per_run.
+func (r *rule) dryRun(kind jobKind, now time.Time, files int) (dryRunResult, error) {
+	result := dryRunResult{Rule: r.config.DisplayName(), Job: kind, Files: []plannedDeletion{}}
+	var limit int64
+	var cutoff time.Time
+	switch kind {
+	case sizeJob:
+		var err error
+		if limit, err = sizeLimit(r.config); err != nil {
+			return result, fmt.Errorf("reading disk usage: %w", err)
+		}
+		result.LimitBytes = limit
+	case retentionJob:
+		cutoff = now.Add(-time.Duration(r.config.RetentionDays * float64(24*time.Hour)))
+		result.Cutoff = &cutoff
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	result.FolderBytes = r.index.Size()
+	remaining := result.FolderBytes
+	r.index.Ascend(func(path string, info FileInfo) bool {
+		if kind == sizeJob && remaining <= limit || kind == retentionJob && !info.ModTime.Before(cutoff) {
+			return false
+		}
+		if r.config.MaxDeletesPerRun > 0 && result.TotalFiles >= r.config.MaxDeletesPerRun {
+			result.CapReached = true
+			return false
+		}
+		remaining -= info.Size
+		if files < 0 || len(result.Files) < files {
+			result.Files = append(result.Files, plannedDeletion{Path: path, Size: info.Size, ModTime: info.ModTime})
+		}
+		result.TotalFiles++
+		result.TotalBytes += info.Size
+		return true
+	})
+	return result, nil
+}
+
+func (d *daemon) serveReload(w http.ResponseWriter, req *http.Request) {
+	reply := make(chan error, 1)
+	select {
+	case d.reloads <- reply:
+	case <-d.ctx.Done():
+		writeError(w, http.StatusServiceUnavailable, errors.New("shutting down"))
+		return
+	case <-req.Context().Done():
+		return
+	}
+	if err := <-reply; err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// serveDigest sends the email digest since the previous one now.
+func (d *daemon) serveDigest(w http.ResponseWriter, req *http.Request) {
+	result, _, err := digests.send(req.Context(), true)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// serveEvents streams the events of the daemon as JSON Lines until the client
+// goes away or the daemon stops. The rule and type query parameters, comma
+// separated, filter them.
+func (d *daemon) serveEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	var rules, types []string
+	if value := req.URL.Query().Get("rule"); value != "" {
+		rules = strings.Split(value, ",")
+	}
+	if value := req.URL.Query().Get("type"); value != "" {
+		types = strings.Split(value, ",")
+	}
+
+	ch := events.subscribe(types...)
+	defer events.unsubscribe(ch)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event := <-ch:
+			if len(rules) > 0 && !slices.Contains(rules, event.Rule) {
+				continue
+			}
+			if err := encoder.Encode(event); err != nil {
+				log.Debugln("Event stream closed:", err)
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// controlClient sends requests to a running daemon, over its Unix socket or
+// over HTTP when an address is given.
+type controlClient struct {
+	base  string
+	token string
+	// target names the daemon in errors.
+	target string
+	client *http.Client
+}
+
+// newControlClient returns a client for the daemon at address, such as
+// http://127.0.0.1:9464, or on the socket of statusSocketPath when address is
+// empty. token is only sent over HTTP.
+func newControlClient(address, token string) (*controlClient, error) {
+	if address != "" {
+		if _, err := url.Parse(address); err != nil {
+			return nil, err
+		}
+		return &controlClient{base: strings.TrimSuffix(address, "/"), token: token, target: address, client: &http.Client{}}, nil
+	}
+	path, err := statusSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}
+	// The host is ignored, every request goes to the socket
+	return &controlClient{base: "http://fileCleanup", target: path, client: &http.Client{Transport: transport}}, nil
+}
+
+// request sends a request to the daemon and returns its successful response.
+// The error of a failed request is the one reported by the daemon.
+func (c *controlClient) request(ctx context.Context, method, path string, query url.Values) (*http.Response, error) {
+	address := c.base + path
+	if len(query) > 0 {
+		address += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, address, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("no FileCleanup daemon answers on %s, is clean running? %w", c.target, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error != "" {
+			return nil, errors.New(body.Error)
+		}
+		return nil, fmt.Errorf("daemon answered %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// call sends a request to the daemon and decodes its JSON answer into out.
+func (c *controlClient) call(ctx context.Context, method, path string, query url.Values, out any) error {
+	resp, err := c.request(ctx, method, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func rulePath(name, action string) string {
+	return "/rules/" + url.PathEscape(name) + "/" + action
+}
+
+func ctlCmd() *cobra.Command {
+	var address, token string
+	var client *controlClient
+	var ctlCmd = &cobra.Command{
+		Use:   "ctl",
+		Short: "Control a running clean daemon",
+		Long: "Run, pause and resume rules, reload the configuration, preview cleanups, follow the events and send the email digest of a running clean daemon. " +
+			"Requests go to the Unix socket of the daemon, or with --url to its http_address, which requires control_token.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := RootCmd.PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			if token == "" {
+				token = AppConfig.ControlToken
+			}
+			var err error
+			client, err = newControlClient(address, token)
+			return err
+		},
+	}
+	ctlCmd.PersistentFlags().StringVar(&SocketPath, "socket", "", "Unix socket of the daemon, overriding socket_path of the configuration")
+	ctlCmd.PersistentFlags().StringVar(&address, "url", "", "Send the requests over HTTP to this address, such as http://127.0.0.1:9464")
+	ctlCmd.PersistentFlags().StringVar(&token, "token", "", "Bearer token for --url, defaults to control_token of the configuration")
+	ctlCmd.MarkFlagsMutuallyExclusive("socket", "url")
+
+	jobFlag := func(cmd *cobra.Command, job *string) {
+		cmd.Flags().StringVar(job, "job", "", "Only the retention or the size job, both by default")
+		_ = cmd.RegisterFlagCompletionFunc("job", cobra.FixedCompletions([]string{string(retentionJob), string(sizeJob)}, cobra.ShellCompDirectiveNoFileComp))
+	}
+	jobQuery := func(job string) url.Values {
+		if job == "" {
+			return nil
+		}
+		return url.Values{"job": {job}}
+	}
+
+	var runJob string
+	var runJSON bool
+	var runCmd = &cobra.Command{
+		Use:               "run RULE",
+		Short:             "Run the cleanup of a rule now and wait for it to finish",
+		Long:              "Run the cleanup of a rule now, even when it is paused, and wait for it to finish. A run already in progress is finished first.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRuleNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var results []jobRunResult
+			if err := client.call(cmd.Context(), http.MethodPost, rulePath(args[0], "run"), jobQuery(runJob), &results); err != nil {
+				return err
+			}
+			if runJSON {
+				return writeIndentedJSON(cmd.OutOrStdout(), results)
+			}
+			for _, result := range results {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", result.Job, formatRunResult(result.Result))
+			}
+			return nil
+		},
+	}
+	jobFlag(runCmd, &runJob)
+	runCmd.Flags().BoolVar(&runJSON, "json", false, "Print the results as JSON")
+
+	pauseCmd := func(action, short string) *cobra.Command {
+		return &cobra.Command{
+			Use:               action + " RULE",
+			Short:             short,
+			Args:              cobra.ExactArgs(1),
+			ValidArgsFunction: completeRuleNames,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var state struct {
+					Rule  string `json:"rule"`
+					State string `json:"state"`
+				}
+				if err := client.call(cmd.Context(), http.MethodPost, rulePath(args[0], action), nil, &state); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Rule %s is %s\n", state.Rule, state.State)
+				return nil
+			},
+		}
+	}
+
+	var reloadCmd = &cobra.Command{
+		Use:   "reload",
+		Short: "Reload the configuration of the daemon",
+		Long:  "Reload the configuration of the daemon like SIGHUP does. An invalid configuration is reported and the running one is kept.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var answer map[string]string
+			if err := client.call(cmd.Context(), http.MethodPost, "/reload", nil, &answer); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Configuration reloaded")
+			return nil
+		},
+	}
+
+	var digestCmd = &cobra.Command{
+		Use:   "digest",
+		Short: "Send the email digest now",
+		Long: "Send the email digest covering the time since the previous one now, even when nothing was deleted. " +
+			"The next scheduled digest starts from here.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var sent digest
+			if err := client.call(cmd.Context(), http.MethodPost, "/digest", nil, &sent); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Sent the digest since %s: %d files deleted, %s freed, %d errors\n",
+				sent.Since.Local().Format(time.DateTime), sent.DeletedFiles, pkg.FormatBytes(sent.ReclaimedBytes), sent.Errors)
+			return nil
+		},
+	}
+
+	var dryRunJob string
+	var dryRunLimit int
+	var dryRunJSON bool
+	var dryRunCmd = &cobra.Command{
+		Use:   "dry-run RULE",
+		Short: "List the files the cleanup of a rule would delete now",
+		Long: "List the files the cleanup of a rule would delete if it ran now, according to the live index of the daemon. " +
+			"Nothing is deleted.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRuleNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := jobQuery(dryRunJob)
+			if query == nil {
+				query = url.Values{}
+			}
+			query.Set("limit", strconv.Itoa(dryRunLimit))
+			var results []dryRunResult
+			if err := client.call(cmd.Context(), http.MethodGet, rulePath(args[0], "dry-run"), query, &results); err != nil {
+				return err
+			}
+			if dryRunJSON {
+				return writeIndentedJSON(cmd.OutOrStdout(), results)
+			}
+			writeDryRun(cmd.OutOrStdout(), results)
+			return nil
+		},
+	}
+	jobFlag(dryRunCmd, &dryRunJob)
+	dryRunCmd.Flags().IntVarP(&dryRunLimit, "limit", "n", 20, "List at most this many files per job, -1 for all")
+	dryRunCmd.Flags().BoolVar(&dryRunJSON, "json", false, "Print the plan as JSON")
+
+	var eventRules, eventTypeFilter []string
+	var eventsJSON bool
+	var eventsCmd = &cobra.Command{
+		Use:   "events",
+		Short: "Follow the events of the daemon",
+		Long: "Print the events of the daemon as they happen: jobs starting, finishing and being skipped, files added and deleted, " +
+			"failed deletions, paused and resumed rules and configuration reloads.",
+		Example: `fileCleanup ctl events --rule logs --type file_deleted,delete_failed`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{}
+			if len(eventRules) > 0 {
+				query.Set("rule", strings.Join(eventRules, ","))
+			}
+			if len(eventTypeFilter) > 0 {
+				query.Set("type", strings.Join(eventTypeFilter, ","))
+			}
+			resp, err := client.request(cmd.Context(), http.MethodGet, "/events", query)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				if eventsJSON {
+					fmt.Fprintln(cmd.OutOrStdout(), scanner.Text())
+					continue
+				}
+				var event daemonEvent
+				if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), formatEvent(event))
+			}
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+			return errors.New("the daemon closed the event stream")
+		},
+	}
+	eventsCmd.Flags().StringSliceVar(&eventRules, "rule", nil, "Only events of these rules")
+	eventsCmd.Flags().StringSliceVar(&eventTypeFilter, "type", nil, "Only events of these types: "+strings.Join(eventTypes, ", "))
+	eventsCmd.Flags().BoolVar(&eventsJSON, "json", false, "Print the events as JSON Lines")
+	_ = eventsCmd.RegisterFlagCompletionFunc("rule", completeRuleNames)
+	_ = eventsCmd.RegisterFlagCompletionFunc("type", cobra.FixedCompletions(eventTypes, cobra.ShellCompDirectiveNoFileComp))
+
+	ctlCmd.AddCommand(runCmd)
+	ctlCmd.AddCommand(pauseCmd("pause", "Stop running the scheduled cleanups of a rule until it is resumed"))
+	ctlCmd.AddCommand(pauseCmd("resume", "Run the scheduled cleanups of a paused rule again"))
+	ctlCmd.AddCommand(reloadCmd)
+	ctlCmd.AddCommand(dryRunCmd)
+	ctlCmd.AddCommand(eventsCmd)
+	ctlCmd.AddCommand(digestCmd)
+	return ctlCmd
+}
+
+func writeIndentedJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func writeDryRun(w io.Writer, results []dryRunResult) {
+	for i, result := range results {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		switch result.Job {
+		case sizeJob:
+			fmt.Fprintf(w, "%s job of %s: folder is %s of %s allowed, %d files of %s would be deleted\n", result.Job, result.Rule,
+				pkg.FormatBytes(result.FolderBytes), pkg.FormatBytes(result.LimitBytes), result.TotalFiles, pkg.FormatBytes(result.TotalBytes))
+		default:
+			fmt.Fprintf(w, "%s job of %s: %d files of %s modified before %s would be deleted\n", result.Job, result.Rule,
+				result.TotalFiles, pkg.FormatBytes(result.TotalBytes), result.Cutoff.Local().Format(time.DateTime))
+		}
+		if result.CapReached {
+			fmt.Fprintf(w, "max_deletes_per_run would stop the run after %d files\n", result.TotalFiles)
+		}
+		if len(result.Files) == 0 {
+			continue
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "MODIFIED\tSIZE\tPATH")
+		for _, file := range result.Files {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", file.ModTime.Local().Format(time.DateTime), pkg.FormatBytes(file.Size), file.Path)
+		}
+		tw.Flush()
+		if len(result.Files) < result.TotalFiles {
+			fmt.Fprintf(w, "... and %d more files\n", result.TotalFiles-len(result.Files))
+		}
+	}
+}
+
+// formatEvent formats event as a line of key=value pairs.
+func formatEvent(event daemonEvent) string {
+	parts := []string{event.Time.Local().Format(time.DateTime), event.Type}
+	if event.Rule != "" {
+		parts = append(parts, "rule="+strconv.Quote(event.Rule))
+	}
+	if event.Job != "" {
+		parts = append(parts, "job="+string(event.Job))
+	}
+	if event.Path != "" {
+		parts = append(parts, "path="+strconv.Quote(event.Path), "size="+strconv.Quote(pkg.FormatBytes(event.Size)))
+	}
+	if event.Reason != "" {
+		parts = append(parts, "reason="+event.Reason)
+	}
+	if event.Result != nil {
+		parts = append(parts, "result="+strconv.Quote(formatRunResult(*event.Result)))
+	} else if event.Error != "" {
+		parts = append(parts, "error="+strconv.Quote(event.Error))
+	}
+	return strings.Join(parts, " ")
+}
+
+func init() {
+	RootCmd.AddCommand(ctlCmd())
+}
@@ -0,0 +1,441 @@
+package cmd
+
+import (
+	constant "FileCleanup/const"
+	"FileCleanup/pkg"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// configReloadDelay debounces the bursts of events editors produce when saving.
+const configReloadDelay = 500 * time.Millisecond
+
+// fileEventQueueSize is the number of watcher events queued while the daemon
+// goroutine is busy, such as with a reload.
+const fileEventQueueSize = 4096
+
+// rule is the runtime state of a single DeleteConfig entry.
+type rule struct {
+	config pkg.DeleteConfig
+	// index is guarded by mutex.
+	index *FileIndex
+	sched *scheduler
+	// runMutex makes sure only one deletion run works on the index at a time.
+	runMutex sync.Mutex
+	// paused rules skip their scheduled runs.
+	paused atomic.Bool
+	// indexed is set once the first scan of the target folder finished, the
+	// jobs of the rule only start then.
+	indexed atomic.Bool
+	// missing is set when the target folder did not exist when it was
+	// scanned. A reload rebuilds the rule so a folder mounted since is picked up.
+	missing atomic.Bool
+}
+
+// rules holds the active rules keyed by their target folder. It is guarded by
+// mutex and only replaced by the daemon goroutine.
+var rules = make(map[string]*rule)
+
+// logger returns a log entry tagged with the name of the rule.
+func (r *rule) logger() *log.Entry {
+	return log.WithField("rule", r.config.DisplayName())
+}
+
+func ruleKey(config pkg.DeleteConfig) string {
+	return filepath.Clean(config.TargetFolder)
+}
+
+// isWithin reports whether path is folder or lies below it.
+func isWithin(path, folder string) bool {
+	path, folder = filepath.Clean(path), filepath.Clean(folder)
+	return path == folder || strings.HasPrefix(path, strings.TrimSuffix(folder, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// activeRules returns the active rules in configuration order.
+func activeRules() []*rule {
+	mutex.Lock()
+	defer mutex.Unlock()
+	result := make([]*rule, 0, len(rules))
+	for _, deleteConfig := range AppConfig.DeleteConfig {
+		if r, ok := rules[ruleKey(deleteConfig)]; ok {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// daemon wires the file watcher, the scheduler of every rule and the
+// configuration file watcher together.
+type daemon struct {
+	ctx           context.Context
+	watcher       *fsnotify.Watcher
+	configWatcher *fsnotify.Watcher
+	configPath    string
+	// fileEvents queues the events of watcher for the daemon goroutine, its
+	// length is exported as the watcher queue length.
+	fileEvents chan fsnotify.Event
+	// servers serve metrics, health and status over HTTP and the socket.
+	servers   []*http.Server
+	startedAt time.Time
+	// ready is set once the initial configuration is applied.
+	ready atomic.Bool
+	// reloads receives the reload requests of the control API, answered with
+	// the outcome of the reload.
+	reloads chan chan error
+	// indexed receives the rules whose first scan finished in the background.
+	indexed chan []*rule
+	// retiring tracks the schedulers of replaced rules still being stopped.
+	retiring sync.WaitGroup
+}
+
+// isReady reports whether the initial configuration is applied and the index
+// of every active rule is populated.
+func (d *daemon) isReady() bool {
+	if !d.ready.Load() {
+		return false
+	}
+	for _, r := range activeRules() {
+		if !r.indexed.Load() {
+			return false
+		}
+	}
+	return true
+}
+
+// applyConfig makes config the active configuration. Rules that did not change
+// keep running untouched; only added, changed and removed rules have their
+// watches, index and schedules rebuilt. Disabled rules are not run.
+func (d *daemon) applyConfig(config pkg.Config) {
+	mutex.Lock()
+	previous := make(map[string]*rule, len(rules))
+	for key, r := range rules {
+		previous[key] = r
+	}
+	mutex.Unlock()
+
+	var added, kept []*rule
+	next := make(map[string]*rule, len(config.DeleteConfig))
+	disabled := make(map[string]bool)
+	for _, deleteConfig := range config.DeleteConfig {
+		key := ruleKey(deleteConfig)
+		if !deleteConfig.Enabled {
+			disabled[key] = true
+			continue
+		}
+		if r, ok := previous[key]; ok && reflect.DeepEqual(r.config, deleteConfig) && !r.missing.Load() {
+			next[key] = r
+			kept = append(kept, r)
+			delete(previous, key)
+			continue
+		}
+		r := &rule{config: deleteConfig, index: NewFileIndex()}
+		if old, ok := previous[key]; ok {
+			// A changed rule stays paused
+			r.paused.Store(old.paused.Load())
+		}
+		// The scheduler is created before the rule is published so readers
+		// of rules never see it without one
+		r.sched = newScheduler(r)
+		next[key] = r
+		added = append(added, r)
+	}
+
+	// Whatever is left in previous was changed or removed. Their schedulers
+	// are drained concurrently in the background so the daemon keeps handling
+	// events, the rules replacing them are only scanned once they stopped.
+	retired := d.retire(previous)
+	for key, r := range previous {
+		if _, ok := next[key]; !ok {
+			if err := d.watcher.Remove(r.config.TargetFolder); err != nil {
+				log.Debugln("Error removing watch:", err)
+			}
+			if disabled[key] {
+				r.logger().Infoln("Disabled rule")
+			} else {
+				r.logger().Infoln("Removed rule")
+			}
+		}
+	}
+
+	mutex.Lock()
+	AppConfig = config
+	rules = next
+	pruneDirModTimes()
+	mutex.Unlock()
+	auditLog.configure(config.AuditLog)
+	notifications.configure(config.Webhooks)
+	digests.configure(config.Email)
+
+	// Folders are watched before they are scanned so no new file is missed.
+	// The scan runs in the background to keep the daemon responsive, the jobs
+	// of the rules are started by startIndexed once it finished.
+	for _, r := range added {
+		// A missing folder is reported when it is scanned
+		if err := d.watcher.Add(r.config.TargetFolder); err != nil && !errors.Is(err, os.ErrNotExist) {
+			r.logger().Errorln("Error watching folder:", err)
+			errorsTotal.add(1, r.config.DisplayName(), "watch")
+		}
+	}
+	if len(added) > 0 {
+		go func() {
+			// The runs of the replaced rules may still be deleting files
+			select {
+			case <-retired:
+			case <-d.ctx.Done():
+				return
+			}
+			if err := loadFileIndex(added); err != nil {
+				log.Errorln("Error populating fileIndex:", err)
+			}
+			select {
+			case d.indexed <- added:
+			case <-d.ctx.Done():
+			}
+		}()
+	}
+	if len(kept) > 0 && len(added)+len(previous) > 0 {
+		log.Infof("Configuration applied - %d rules kept, %d added or changed, %d removed or replaced", len(kept), len(added), len(previous))
+	}
+}
+
+// retire stops the schedulers of the replaced or removed rules concurrently.
+// The returned channel is closed once all of them are stopped.
+func (d *daemon) retire(retired map[string]*rule) <-chan struct{} {
+	done := make(chan struct{})
+	d.retiring.Add(1)
+	go func() {
+		defer d.retiring.Done()
+		defer close(done)
+		var wg sync.WaitGroup
+		for _, r := range retired {
+			if r.sched == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(r *rule) {
+				defer wg.Done()
+				if !r.sched.Stop(DrainTimeout) {
+					r.logger().Warningln("Drain timeout exceeded, replacing rule while its cleanups are still running")
+				}
+			}(r)
+		}
+		wg.Wait()
+	}()
+	return done
+}
+
+// startIndexed starts the jobs of the rules whose first scan finished. Rules
+// replaced or removed by a reload in the meantime are left alone.
+func (d *daemon) startIndexed(indexed []*rule) {
+	for _, r := range indexed {
+		mutex.Lock()
+		active := rules[ruleKey(r.config)] == r
+		mutex.Unlock()
+		if !active {
+			continue
+		}
+		r.indexed.Store(true)
+		r.sched.Start(d.ctx)
+	}
+}
+
+// reloadConfig re-reads and validates the configuration files. The running
+// configuration is kept when the new one cannot be loaded.
+func (d *daemon) reloadConfig() error {
+	log.Infoln("Reloading configuration from", d.configPath)
+	config, err := pkg.LoadConfig(d.configPath)
+	if err != nil {
+		log.Errorln("Keeping current configuration:", err)
+		events.publish(daemonEvent{Type: eventReloadFailed, Error: err.Error()})
+		return err
+	}
+	if err := pkg.ValidateFile(d.configPath, config); err != nil {
+		log.Errorln("Keeping current configuration, new configuration is invalid:", err)
+		events.publish(daemonEvent{Type: eventReloadFailed, Error: err.Error()})
+		return err
+	}
+	for _, warning := range config.Warnings {
+		log.Warningln(warning)
+	}
+	if config.HTTPAddress != AppConfig.HTTPAddress && HTTPAddress == "" {
+		log.Warningln("http_address changed, restart FileCleanup to apply it")
+	}
+	d.applyConfig(config)
+	d.watchConfigSources()
+	log.Infoln("Configuration reloaded")
+	events.publish(daemonEvent{Type: eventConfigReloaded})
+	return nil
+}
+
+// watchConfigFile watches the directory of the configuration file, editors
+// usually replace the file instead of writing it in place, along with the
+// directories of its conf.d and included files.
+func (d *daemon) watchConfigFile() {
+	if d.configPath == "" {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Errorln("Error watching configuration file:", err)
+		return
+	}
+	if err := watcher.Add(filepath.Dir(d.configPath)); err != nil {
+		log.Errorln("Error watching configuration file:", err)
+		watcher.Close()
+		return
+	}
+	d.configWatcher = watcher
+	d.watchConfigSources()
+}
+
+// watchConfigSources adds the conf.d directory and the directories of the
+// include patterns to the configuration file watcher. Missing directories are
+// skipped, conf.d is picked up once it is created.
+func (d *daemon) watchConfigSources() {
+	if d.configWatcher == nil {
+		return
+	}
+	dirs := []string{d.dropInDir()}
+	for _, pattern := range d.includePatterns() {
+		dirs = append(dirs, filepath.Dir(pattern))
+	}
+	for _, dir := range dirs {
+		if err := d.configWatcher.Add(dir); err != nil {
+			log.Debugln("Not watching configuration directory:", err)
+		}
+	}
+}
+
+func (d *daemon) dropInDir() string {
+	return filepath.Join(filepath.Dir(d.configPath), pkg.DropInDirName)
+}
+
+// includePatterns returns the include patterns of the active configuration
+// as absolute patterns.
+func (d *daemon) includePatterns() []string {
+	patterns := make([]string, len(AppConfig.Include))
+	for i, pattern := range AppConfig.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(d.configPath), pattern)
+		}
+		patterns[i] = pattern
+	}
+	return patterns
+}
+
+// configEvents returns the events of the configuration file watcher, or nil
+// when the file is not watched.
+// forwardFileEvents moves the events of the watcher to fileEvents until the
+// daemon stops or the watcher is closed.
+func (d *daemon) forwardFileEvents() {
+	for {
+		select {
+		case event, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+			select {
+			case d.fileEvents <- event:
+			case <-d.ctx.Done():
+				return
+			}
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *daemon) configEvents() <-chan fsnotify.Event {
+	if d.configWatcher == nil {
+		return nil
+	}
+	return d.configWatcher.Events
+}
+
+// isConfigEvent reports whether event changes the configuration file, the
+// conf.d directory or one of its files, or a file matching an include pattern.
+func (d *daemon) isConfigEvent(event fsnotify.Event) bool {
+	name := filepath.Clean(event.Name)
+	if name == filepath.Clean(d.configPath) {
+		return event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0
+	}
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+		return false
+	}
+	if name == d.dropInDir() {
+		return true
+	}
+	if filepath.Dir(name) == d.dropInDir() {
+		_, err := pkg.ConfigFormat(name)
+		return err == nil && !strings.HasPrefix(filepath.Base(name), ".")
+	}
+	for _, pattern := range d.includePatterns() {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handleFileEvent registers newly created files with every rule watching them.
+func (d *daemon) handleFileEvent(event fsnotify.Event) {
+	watcherEventsTotal.add(1, eventOp(event))
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		forgetDir(event.Name)
+	}
+	if event.Op&fsnotify.Create != fsnotify.Create {
+		return
+	}
+	if AppConfig.IsDetailedLogEnabled {
+		log.Debugln("File creation notification :: File " + event.Name)
+	}
+	HandleNewFile(event.Name)
+}
+
+// stop stops every rule, waiting up to DrainTimeout for running cleanups.
+func (d *daemon) stop() {
+	var wg sync.WaitGroup
+	var timedOut atomic.Bool
+	for _, r := range activeRules() {
+		wg.Add(1)
+		go func(r *rule) {
+			defer wg.Done()
+			if !r.sched.Stop(DrainTimeout) {
+				timedOut.Store(true)
+			}
+		}(r)
+	}
+	wg.Wait()
+	d.retiring.Wait()
+	if timedOut.Load() {
+		log.Warningln("Drain timeout exceeded, exiting while cleanups are still running")
+	}
+	if d.configWatcher != nil {
+		d.configWatcher.Close()
+	}
+	d.stopHTTPServers()
+	notifications.wait(5 * time.Second)
+	auditLog.close()
+}
+
+// logStatus writes the state of every rule and its jobs to the log.
+func (d *daemon) logStatus() {
+	for _, r := range activeRules() {
+		mutex.Lock()
+		files, size := r.index.Len(), r.index.Size()
+		mutex.Unlock()
+		log.Infof("Rule %s | %s | %d files indexed | %d MB", r.config.DisplayName(), r.config.TargetFolder, files, size/constant.MB)
+		r.sched.LogStatus()
+	}
+}
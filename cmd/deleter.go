@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenBucket is a rate limiter refilled continuously at the rate passed to
+// take. Tokens may go negative so a single large file is never blocked forever,
+// the following callers simply wait longer.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	// now returns the current time, time.Now when nil.
+	now func() time.Time
+}
+
+// take reserves n tokens and returns how long the caller has to wait before
+// using them. A non-positive rate disables the limit.
+func (b *tokenBucket) take(rate float64, n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.now != nil {
+		now = b.now()
+	}
+	if rate <= 0 {
+		b.tokens = 0
+		b.last = now
+		return 0
+	}
+	if b.last.IsZero() {
+		b.tokens = rate
+	} else {
+		// Allow bursts of at most one second worth of tokens
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*rate, rate)
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+type deleteJob struct {
+	path string
+	info FileInfo
+}
+
+// fileDeleter removes files with a pool of workers while honouring the rule's
+// files/sec and bytes/sec limits.
+type fileDeleter struct {
+	config pkg.DeleteConfig
+	// reason is recorded in the audit log for every deleted file.
+	reason      string
+	stopOnError bool
+
+	files tokenBucket
+	bytes tokenBucket
+
+	deleted   atomic.Uint64
+	reclaimed atomic.Int64
+	failed    atomic.Uint64
+	// firstError is the first failed deletion, guarded by errorOnce.
+	firstError string
+	errorOnce  sync.Once
+	// capped is set when the run stopped at the max_deletes_per_run of the
+	// rule.
+	capped   bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newFileDeleter(config pkg.DeleteConfig, reason string, stopOnError bool) *fileDeleter {
+	return &fileDeleter{
+		config:      config,
+		reason:      reason,
+		stopOnError: stopOnError,
+		stop:        make(chan struct{}),
+	}
+}
+
+// Run deletes the files returned by next until it reports no more files, ctx
+// is cancelled, max_deletes_per_run files were taken, or a deletion fails when
+// the deleter stops on errors. Files
+// already handed to a worker are still deleted after ctx is cancelled. Files
+// that fail to be removed are handed to onError; err is nil for a file that was
+// taken from next but never attempted because the deleter stopped, or that
+// changed on disk since it was indexed, then with its current information.
+func (d *fileDeleter) Run(ctx context.Context, next func() (string, FileInfo, bool), onError func(path string, info FileInfo, err error)) {
+	workers := max(d.config.DeleteWorkers, 1)
+	jobs := make(chan deleteJob, workers)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				d.delete(ctx, job, onError)
+			}
+		}()
+	}
+
+	taken := 0
+produce:
+	for {
+		select {
+		case <-d.stop:
+			break produce
+		case <-ctx.Done():
+			break produce
+		default:
+		}
+		path, info, ok := next()
+		if !ok {
+			break
+		}
+		if d.config.MaxDeletesPerRun > 0 && taken >= d.config.MaxDeletesPerRun {
+			d.capped = true
+			onError(path, info, nil)
+			log.WithField("rule", d.config.DisplayName()).Warnf("Stopping after %d files, max_deletes_per_run reached", taken)
+			break
+		}
+		taken++
+		select {
+		case jobs <- deleteJob{path: path, info: info}:
+		case <-d.stop:
+			onError(path, info, nil)
+			break produce
+		case <-ctx.Done():
+			onError(path, info, nil)
+			break produce
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func (d *fileDeleter) delete(ctx context.Context, job deleteJob, onError func(path string, info FileInfo, err error)) {
+	filesPerSecond, bytesPerSecond := d.config.DeleteRateLimits(time.Now())
+	wait := max(d.files.take(filesPerSecond, 1), d.bytes.take(float64(bytesPerSecond), float64(job.info.Size)))
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			// Do not keep the daemon waiting on the throttle while shutting down
+			timer.Stop()
+			onError(job.path, job.info, nil)
+			return
+		}
+	}
+
+	// The watcher does not report writes, so a file may have changed since it
+	// was indexed. It is handed back with its current information instead of
+	// being deleted, a following pick sees whether it still qualifies.
+	if stat, err := os.Lstat(job.path); err == nil {
+		if current := (FileInfo{Size: stat.Size(), ModTime: stat.ModTime()}); current.Size != job.info.Size || !current.ModTime.Equal(job.info.ModTime) {
+			log.WithField("rule", d.config.DisplayName()).Debugln("Changed since it was indexed, skipped:", job.path)
+			onError(job.path, current, nil)
+			return
+		}
+	}
+
+	err := os.Remove(job.path)
+	auditDeletion(d.config, d.reason, job.path, job.info, err)
+	event := daemonEvent{Type: eventFileDeleted, Rule: d.config.DisplayName(), Path: job.path, Size: job.info.Size, Reason: d.reason}
+	switch {
+	case err == nil:
+		deletedFilesTotal.add(1, d.config.DisplayName(), d.reason)
+		reclaimedBytesTotal.add(float64(job.info.Size), d.config.DisplayName(), d.reason)
+		events.publish(event)
+	case !os.IsNotExist(err):
+		errorsTotal.add(1, d.config.DisplayName(), "delete")
+		event.Type, event.Error = eventDeleteFailed, err.Error()
+		events.publish(event)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		d.failed.Add(1)
+		d.errorOnce.Do(func() { d.firstError = err.Error() })
+		onError(job.path, job.info, err)
+		if d.stopOnError {
+			d.stopOnce.Do(func() { close(d.stop) })
+		}
+		return
+	}
+	d.deleted.Add(1)
+	d.reclaimed.Add(job.info.Size)
+	if AppConfig.IsDetailedLogEnabled {
+		log.WithField("rule", d.config.DisplayName()).Debugln("Deleted:", job.path)
+	}
+}
+
+// Deleted returns the number of files removed so far.
+func (d *fileDeleter) Deleted() uint64 {
+	return d.deleted.Load()
+}
+
+// result returns the totals of a finished run.
+func (d *fileDeleter) result() runResult {
+	return runResult{
+		DeletedFiles:   d.deleted.Load(),
+		ReclaimedBytes: d.reclaimed.Load(),
+		Errors:         d.failed.Load(),
+		FirstError:     d.firstError,
+		Capped:         d.capped,
+	}
+}
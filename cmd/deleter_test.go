@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+// testClock is a clock only moved by the test.
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func (c *testClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestTokenBucket(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	bucket := tokenBucket{now: clock.Now}
+
+	// A full second worth of tokens is available at first
+	for i := range 10 {
+		if wait := bucket.take(10, 1); wait != 0 {
+			t.Fatalf("take %d waits %s within the burst", i, wait)
+		}
+	}
+	if wait := bucket.take(10, 1); wait != 100*time.Millisecond {
+		t.Errorf("take after the burst waits %s, want 100ms", wait)
+	}
+	// Reserved tokens go negative so the next caller waits longer
+	if wait := bucket.take(10, 1); wait != 200*time.Millisecond {
+		t.Errorf("second take after the burst waits %s, want 200ms", wait)
+	}
+
+	clock.advance(200 * time.Millisecond)
+	if wait := bucket.take(10, 1); wait != 100*time.Millisecond {
+		t.Errorf("take after refilling 2 tokens waits %s, want 100ms", wait)
+	}
+
+	// Idle time refills at most one second worth of tokens
+	clock.advance(time.Hour)
+	for i := range 10 {
+		if wait := bucket.take(10, 1); wait != 0 {
+			t.Fatalf("take %d after idling waits %s", i, wait)
+		}
+	}
+	if wait := bucket.take(10, 1); wait == 0 {
+		t.Error("idling allowed a burst larger than one second")
+	}
+}
+
+func TestTokenBucketLargeTake(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	bucket := tokenBucket{now: clock.Now}
+
+	// A file larger than the rate is let through, the next one waits for it
+	if wait := bucket.take(1000, 3000); wait != 2*time.Second {
+		t.Errorf("3000 bytes at 1000/s waits %s, want 2s", wait)
+	}
+	clock.advance(2 * time.Second)
+	if wait := bucket.take(1000, 500); wait != 500*time.Millisecond {
+		t.Errorf("500 bytes after the debt is paid waits %s, want 500ms", wait)
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	bucket := tokenBucket{now: clock.Now}
+	for range 1000 {
+		if wait := bucket.take(0, 1<<30); wait != 0 {
+			t.Fatalf("take without a rate waits %s", wait)
+		}
+	}
+	// Huge files without a limit leave no debt behind, and a limit coming
+	// into effect, such as a throttle window opening, starts without a burst
+	if wait := bucket.take(10, 1); wait != 100*time.Millisecond {
+		t.Errorf("first take with a rate waits %s, want 100ms", wait)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"syscall"
+)
+
+// diskUsage returns the bytes available to the current user and the total
+// size of the filesystem holding path.
+func diskUsage(path string) (int64, int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), int64(stat.Blocks) * int64(stat.Bsize), nil
+}
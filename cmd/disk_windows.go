@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// diskUsage returns the bytes available to the current user and the total
+// size of the drive holding path.
+func diskUsage(path string) (int64, int64, error) {
+	kernelDLL, err := syscall.LoadDLL("kernel32.dll")
+	if err != nil {
+		return 0, 0, err
+	}
+	GetDiskFreeSpaceExW, err := kernelDLL.FindProc("GetDiskFreeSpaceExW")
+	if err != nil {
+		return 0, 0, err
+	}
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var freeSize, totalSize, avail int64
+	r1, _, lastErr := GetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&freeSize)),
+		uintptr(unsafe.Pointer(&totalSize)),
+		uintptr(unsafe.Pointer(&avail)),
+	)
+	if r1 == 0 {
+		return 0, 0, lastErr
+	}
+	return freeSize, totalSize, nil
+}
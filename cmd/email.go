@@ -0,0 +1,874 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// maxDigestErrors bounds the error messages a digest lists per rule.
+const maxDigestErrors = 10
+
+// digestRule is what a rule deleted since the previous digest and the current
+// size of its folder.
+type digestRule struct {
+	Name           string   `json:"name"`
+	TargetFolder   string   `json:"target_folder,omitempty"`
+	Paused         bool     `json:"paused,omitempty"`
+	Runs           int      `json:"runs"`
+	DeletedFiles   uint64   `json:"deleted_files"`
+	ReclaimedBytes int64    `json:"reclaimed_bytes"`
+	Errors         uint64   `json:"errors"`
+	ErrorMessages  []string `json:"error_messages,omitempty"`
+	FolderBytes    int64    `json:"folder_bytes"`
+	LimitBytes     int64    `json:"limit_bytes,omitempty"`
+	AboveLimit     bool     `json:"above_limit,omitempty"`
+}
+
+func (r *digestRule) addError(message string) {
+	if len(r.ErrorMessages) < maxDigestErrors {
+		r.ErrorMessages = append(r.ErrorMessages, message)
+	}
+}
+
+// add adds the runs, deletions and errors of other to r.
+func (r *digestRule) add(other digestRule) {
+	r.Runs += other.Runs
+	r.DeletedFiles += other.DeletedFiles
+	r.ReclaimedBytes += other.ReclaimedBytes
+	r.Errors += other.Errors
+	for _, message := range other.ErrorMessages {
+		r.addError(message)
+	}
+}
+
+// digest is the data the email templates are rendered with.
+type digest struct {
+	Host           string       `json:"host"`
+	Since          time.Time    `json:"since"`
+	Until          time.Time    `json:"until"`
+	DeletedFiles   uint64       `json:"deleted_files"`
+	ReclaimedBytes int64        `json:"reclaimed_bytes"`
+	Errors         uint64       `json:"errors"`
+	Rules          []digestRule `json:"rules"`
+}
+
+// total sums the totals of the rules up.
+func (d *digest) total() {
+	d.DeletedFiles, d.ReclaimedBytes, d.Errors = 0, 0, 0
+	for _, r := range d.Rules {
+		d.DeletedFiles += r.DeletedFiles
+		d.ReclaimedBytes += r.ReclaimedBytes
+		d.Errors += r.Errors
+	}
+}
+
+// empty reports whether nothing was deleted and nothing failed.
+func (d digest) empty() bool {
+	for _, r := range d.Rules {
+		if r.DeletedFiles > 0 || r.Errors > 0 || len(r.ErrorMessages) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// digester collects what the rules deleted and emails it once a day.
+type digester struct {
+	mu     sync.Mutex
+	config *pkg.EmailConfig
+	since  time.Time
+	// stats holds the runs, deletions and errors of every rule since the
+	// previous digest, by rule name.
+	stats map[string]*digestRule
+	// changed wakes the schedule up when the configuration changes.
+	changed chan struct{}
+}
+
+var digests = &digester{since: time.Now(), stats: make(map[string]*digestRule), changed: make(chan struct{}, 1)}
+
+// configure replaces the email settings, nil stops sending digests.
+func (g *digester) configure(config *pkg.EmailConfig) {
+	g.mu.Lock()
+	g.config = config
+	g.mu.Unlock()
+	select {
+	case g.changed <- struct{}{}:
+	default:
+	}
+}
+
+// start collects the results of the runs and sends the digest at the
+// configured time until ctx is cancelled. The digest carries on from the one
+// sent before the daemon was last stopped.
+func (g *digester) start(ctx context.Context) {
+	if sent, err := loadLastDigest(); err != nil {
+		log.Warningln("Error reading when the last email digest was sent:", err)
+	} else if !sent.IsZero() {
+		g.mu.Lock()
+		g.since = sent
+		g.mu.Unlock()
+	}
+	ch := events.subscribe(eventJobFinished, eventDeleteFailed)
+	go func() {
+		defer events.unsubscribe(ch)
+		for {
+			select {
+			case event := <-ch:
+				g.record(event)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go g.schedule(ctx)
+}
+
+func (g *digester) record(event daemonEvent) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stats, ok := g.stats[event.Rule]
+	if !ok {
+		stats = &digestRule{Name: event.Rule}
+		g.stats[event.Rule] = stats
+	}
+	switch event.Type {
+	case eventJobFinished:
+		if event.Result == nil {
+			return
+		}
+		stats.Runs++
+		stats.DeletedFiles += event.Result.DeletedFiles
+		stats.ReclaimedBytes += event.Result.ReclaimedBytes
+		stats.Errors += event.Result.Errors
+		if event.Result.Error != "" {
+			stats.addError(fmt.Sprintf("%s cleanup stopped: %s", event.Job, event.Result.Error))
+		}
+	case eventDeleteFailed:
+		// take reads the failed deletions from the audit log when it is on
+		if auditLog.path() == "" {
+			stats.addError(event.Error)
+		}
+	}
+}
+
+func (g *digester) schedule(ctx context.Context) {
+	for {
+		g.mu.Lock()
+		config := g.config
+		g.mu.Unlock()
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if config != nil {
+			if next, err := config.NextDigest(time.Now()); err == nil {
+				log.Debugln("Next email digest at", next.Format(time.DateTime))
+				timer = time.NewTimer(time.Until(next))
+				fire = timer.C
+			}
+		}
+		select {
+		case <-fire:
+			if _, _, err := g.send(ctx, false); err != nil {
+				log.Errorln("Error sending the email digest:", err)
+			}
+		case <-g.changed:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// take returns the digest until now and starts the next one, along with the
+// stats it was built from for putBack. With the audit log on, the deletions
+// and their errors are summed up from it, so they survive restarts; the runs
+// and why they stopped are only counted in memory.
+func (g *digester) take(now time.Time) (digest, map[string]*digestRule) {
+	host, _ := os.Hostname()
+	var current []digestRule
+	for _, r := range activeRules() {
+		entry := digestRule{Name: r.config.DisplayName(), TargetFolder: r.config.TargetFolder, Paused: r.paused.Load()}
+		mutex.Lock()
+		entry.FolderBytes = r.index.Size()
+		mutex.Unlock()
+		if limit, err := sizeLimit(r.config); err != nil {
+			r.logger().Debugln("Error reading disk usage for the email digest:", err)
+		} else {
+			entry.LimitBytes = limit
+			entry.AboveLimit = limit > 0 && entry.FolderBytes > limit
+		}
+		current = append(current, entry)
+	}
+
+	g.mu.Lock()
+	d := digest{Host: host, Since: g.since, Until: now, Rules: current}
+	stats := g.stats
+	g.since, g.stats = now, make(map[string]*digestRule)
+	g.mu.Unlock()
+
+	totals := make(map[string]*digestRule, len(stats))
+	for name, s := range stats {
+		copied := *s
+		totals[name] = &copied
+	}
+	if path := auditLog.path(); path != "" {
+		audited, err := auditDigest(path, d.Since, now)
+		if err != nil {
+			log.Errorln("Error reading the audit log for the email digest, using the totals since the daemon started:", err)
+		} else {
+			for _, s := range totals {
+				s.DeletedFiles, s.ReclaimedBytes, s.Errors = 0, 0, 0
+			}
+			for name, a := range audited {
+				s, ok := totals[name]
+				if !ok {
+					s = &digestRule{Name: name}
+					totals[name] = s
+				}
+				s.add(*a)
+			}
+		}
+	}
+
+	for i := range d.Rules {
+		if s, ok := totals[d.Rules[i].Name]; ok {
+			d.Rules[i].add(*s)
+			delete(totals, d.Rules[i].Name)
+		}
+	}
+	// Rules removed by a reload still report what they deleted before.
+	removed := make([]digestRule, 0, len(totals))
+	for _, s := range totals {
+		removed = append(removed, *s)
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Name < removed[j].Name })
+	d.Rules = append(d.Rules, removed...)
+	d.total()
+	return d, stats
+}
+
+// auditDigest sums up the deletions and errors of the audit log at path from
+// since until until by rule. A log nothing was written to yet is empty.
+func auditDigest(path string, since, until time.Time) (map[string]*digestRule, error) {
+	totals := make(map[string]*digestRule)
+	if files, err := pkg.AuditFiles(path); err != nil || len(files) == 0 {
+		return totals, err
+	}
+	filter := pkg.AuditFilter{Actions: []string{pkg.AuditActionDelete}, Since: since, Until: until}
+	skipped, err := pkg.ReadAuditLog(path, func(record pkg.AuditRecord) error {
+		if !filter.Match(record) {
+			return nil
+		}
+		r, ok := totals[record.Rule]
+		if !ok {
+			r = &digestRule{Name: record.Rule}
+			totals[record.Rule] = r
+		}
+		switch record.Result {
+		case pkg.AuditResultDeleted:
+			r.DeletedFiles++
+			r.ReclaimedBytes += record.Size
+		case pkg.AuditResultError:
+			r.Errors++
+			r.addError(record.Error)
+		}
+		return nil
+	})
+	if skipped > 0 {
+		log.Warningf("Skipped %d lines of the audit log that are not records", skipped)
+	}
+	return totals, err
+}
+
+// putBack adds the stats of a digest since since that could not be sent to
+// the next one.
+func (g *digester) putBack(since time.Time, stats map[string]*digestRule) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.since = since
+	for name, r := range stats {
+		pending, ok := g.stats[name]
+		if !ok {
+			g.stats[name] = r
+			continue
+		}
+		merged := *r
+		merged.add(*pending)
+		g.stats[name] = &merged
+	}
+}
+
+// lastDigestFile holds when the previous digest was sent, in the
+// configuration directory.
+const lastDigestFile = "last-digest"
+
+// loadLastDigest returns when the previous digest was sent, zero if none was.
+func loadLastDigest() (time.Time, error) {
+	dir, err := pkg.ConfigDir()
+	if err != nil {
+		return time.Time{}, err
+	}
+	content, err := os.ReadFile(filepath.Join(dir, lastDigestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(string(content)))
+}
+
+// saveLastDigest records that the digest until sent was sent.
+func saveLastDigest(sent time.Time) {
+	dir, err := pkg.ConfigDir()
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, lastDigestFile), []byte(sent.Format(time.RFC3339Nano)+"\n"), 0o644)
+	}
+	if err != nil {
+		log.Errorln("Error saving when the email digest was sent:", err)
+	}
+}
+
+// send emails the digest since the previous one. Unless force is set an empty
+// digest is not sent with skip_empty. A digest that could not be sent is
+// included in the next one.
+func (g *digester) send(ctx context.Context, force bool) (digest, bool, error) {
+	g.mu.Lock()
+	config := g.config
+	g.mu.Unlock()
+	if config == nil {
+		return digest{}, false, errors.New("email is not configured")
+	}
+	d, stats := g.take(time.Now())
+	if !force && config.SkipEmpty && d.empty() {
+		log.Infoln("Nothing was deleted and nothing failed, not sending the email digest")
+		saveLastDigest(d.Until)
+		return d, false, nil
+	}
+	message, err := composeEmail(*config, d)
+	if err == nil {
+		err = sendEmail(ctx, *config, message)
+	}
+	if err != nil {
+		g.putBack(d.Since, stats)
+		errorsTotal.add(1, "", "email")
+		return d, false, err
+	}
+	saveLastDigest(d.Until)
+	log.Infof("Sent the email digest to %s: %d files deleted, %s freed, %d errors",
+		strings.Join(config.To, ", "), d.DeletedFiles, pkg.FormatBytes(d.ReclaimedBytes), d.Errors)
+	return d, true, nil
+}
+
+// composeEmail renders the digest into a message with a plain text and an
+// HTML alternative.
+func composeEmail(config pkg.EmailConfig, d digest) ([]byte, error) {
+	subjectTemplate, textTemplate, htmlTemplate, err := config.EmailTemplates()
+	if err != nil {
+		return nil, err
+	}
+	var subject, text, html bytes.Buffer
+	if err := subjectTemplate.Execute(&subject, d); err != nil {
+		return nil, fmt.Errorf("rendering subject: %w", err)
+	}
+	if err := textTemplate.Execute(&text, d); err != nil {
+		return nil, fmt.Errorf("rendering text body: %w", err)
+	}
+	if err := htmlTemplate.Execute(&html, d); err != nil {
+		return nil, fmt.Errorf("rendering HTML body: %w", err)
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	to := make([]string, 0, len(config.To))
+	for _, address := range config.To {
+		parsed, err := mail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+		to = append(to, parsed.String())
+	}
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var message bytes.Buffer
+	parts := multipart.NewWriter(&message)
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject.String()), " ")))
+	fmt.Fprintf(&message, "Date: %s\r\n", d.Until.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Message-ID: <%x@%s>\r\n", id, domain)
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: %s\r\n\r\n", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": parts.Boundary()}))
+	for _, part := range []struct {
+		contentType string
+		body        []byte
+	}{{"text/plain; charset=utf-8", text.Bytes()}, {"text/html; charset=utf-8", html.Bytes()}} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(w)
+		if _, err := encoder.Write(part.body); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return message.Bytes(), nil
+}
+
+// sendEmail delivers message to the recipients of config.
+func sendEmail(ctx context.Context, config pkg.EmailConfig, message []byte) error {
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	mode := config.TLSMode()
+	tlsConfig := &tls.Config{ServerName: config.Host, InsecureSkipVerify: config.InsecureSkipVerify}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	if mode == pkg.EmailTLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", config.Address())
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", config.Address())
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if host, err := os.Hostname(); err == nil {
+		if err := client.Hello(host); err != nil {
+			return err
+		}
+	}
+	if mode == pkg.EmailTLSAuto || mode == pkg.EmailTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("STARTTLS: %w", err)
+			}
+		} else if mode == pkg.EmailTLSStartTLS {
+			return errors.New("the server does not offer STARTTLS")
+		}
+	}
+	if config.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("the server does not offer authentication")
+		}
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range config.To {
+		recipient, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("to: %w", err)
+		}
+		if err := client.Rcpt(recipient.Address); err != nil {
+			return fmt.Errorf("%s: %w", recipient.Address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// sampleDigest returns a digest of the rules of config with made up totals.
+func sampleDigest(config pkg.Config) digest {
+	host, _ := os.Hostname()
+	now := time.Now()
+	d := digest{Host: host, Since: now.Add(-24 * time.Hour), Until: now}
+	for _, rule := range config.DeleteConfig {
+		if !rule.Enabled {
+			continue
+		}
+		n := len(d.Rules) + 1
+		entry := digestRule{Name: rule.DisplayName(), TargetFolder: rule.TargetFolder, Runs: 24,
+			DeletedFiles: uint64(212 * n), ReclaimedBytes: int64(n) * 750 << 20, FolderBytes: 3 << 30}
+		if limit, err := sizeLimit(rule); err == nil && limit > 0 {
+			entry.LimitBytes, entry.FolderBytes = limit, limit*8/10
+		}
+		if n == 1 {
+			entry.Errors = 2
+			entry.ErrorMessages = []string{
+				"remove " + filepath.Join(rule.TargetFolder, "app.log.1") + ": permission denied",
+				"remove " + filepath.Join(rule.TargetFolder, "app.log.2") + ": permission denied",
+			}
+		}
+		d.Rules = append(d.Rules, entry)
+	}
+	if len(d.Rules) == 0 {
+		d.Rules = []digestRule{{Name: "example", TargetFolder: "/var/log/app", Runs: 24, DeletedFiles: 212, ReclaimedBytes: 1503238553, FolderBytes: 12 << 30, LimitBytes: 10 << 30, AboveLimit: true}}
+	}
+	d.total()
+	return d
+}
+
+func notifyEmailCmd() *cobra.Command {
+	var server string
+	var to []string
+	var preview bool
+	var notifyEmailCmd = &cobra.Command{
+		Use:   "email",
+		Short: "Send a sample email digest",
+		Long: "Send a digest of the rules of the configuration with made up totals to the recipients of the email block. " +
+			"--server and --to send it elsewhere, --preview prints the message instead of sending it. " +
+			"ctl digest makes a running daemon send its real digest now.",
+		Example: `fileCleanup notify email --preview
+fileCleanup notify email --server 127.0.0.1:2525 --to ops@example.com`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var config pkg.EmailConfig
+			if AppConfig.Email != nil {
+				config = *AppConfig.Email
+			}
+			if server != "" {
+				host, port, err := net.SplitHostPort(server)
+				if err != nil {
+					return fmt.Errorf("--server: %w", err)
+				}
+				if config.Port, err = strconv.Atoi(port); err != nil {
+					return fmt.Errorf("--server: %w", err)
+				}
+				config.Host = host
+			}
+			if len(to) > 0 {
+				config.To = to
+			}
+			if config.From == "" {
+				config.From = "FileCleanup <filecleanup@localhost>"
+			}
+			if len(config.To) == 0 {
+				config.To = []string{"ops@example.com"}
+				if !preview {
+					return fmt.Errorf("no email in %s, add an email block or pass --server and --to", ConfigFilePath)
+				}
+			}
+			message, err := composeEmail(config, sampleDigest(AppConfig))
+			if err != nil {
+				return err
+			}
+			if preview {
+				_, err := cmd.OutOrStdout().Write(message)
+				return err
+			}
+			if config.Host == "" {
+				return fmt.Errorf("no email in %s, add an email block or pass --server and --to", ConfigFilePath)
+			}
+			if err := sendEmail(cmd.Context(), config, message); err != nil {
+				return fmt.Errorf("sending to %s: %w", config.Address(), err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Sent a sample digest to %s through %s\n", strings.Join(config.To, ", "), config.Address())
+			return nil
+		},
+	}
+	notifyEmailCmd.Flags().StringVar(&server, "server", "", "Send through this SMTP server, host:port, instead of the configured one")
+	notifyEmailCmd.Flags().StringSliceVar(&to, "to", nil, "Send to these addresses instead of the configured recipients")
+	notifyEmailCmd.Flags().BoolVar(&preview, "preview", false, "Print the message instead of sending it")
+	return notifyEmailCmd
+}
+
+func notifyListenSMTPCmd() *cobra.Command {
+	var address string
+	var startTLS, raw bool
+	var notifyListenSMTPCmd = &cobra.Command{
+		Use:   "listen-smtp",
+		Short: "Print the emails sent to a local SMTP server",
+		Long: "Stand in for an SMTP server: accept every message sent to the address and print it until interrupted. " +
+			"Any credentials are accepted. --starttls offers STARTTLS with a self-signed certificate, " +
+			"which needs insecure_skip_verify on the sending side.",
+		Example: `fileCleanup notify listen-smtp --address 127.0.0.1:2525 &
+fileCleanup notify email --server 127.0.0.1:2525 --to ops@example.com`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var tlsConfig *tls.Config
+			if startTLS {
+				certificate, err := selfSignedCertificate()
+				if err != nil {
+					return err
+				}
+				tlsConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+			}
+			listener, err := net.Listen("tcp", address)
+			if err != nil {
+				return err
+			}
+			go func() {
+				<-cmd.Context().Done()
+				listener.Close()
+			}()
+			fmt.Fprintf(cmd.OutOrStdout(), "Listening on smtp://%s\n", listener.Addr())
+			var mu sync.Mutex
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					if cmd.Context().Err() != nil {
+						return nil
+					}
+					return err
+				}
+				go serveSMTP(conn, tlsConfig, func(envelope smtpEnvelope) {
+					mu.Lock()
+					defer mu.Unlock()
+					writeEnvelope(cmd.OutOrStdout(), envelope, raw)
+				})
+			}
+		},
+	}
+	notifyListenSMTPCmd.Flags().StringVar(&address, "address", "127.0.0.1:2525", "Address to listen on")
+	notifyListenSMTPCmd.Flags().BoolVar(&startTLS, "starttls", false, "Offer STARTTLS with a self-signed certificate")
+	notifyListenSMTPCmd.Flags().BoolVar(&raw, "raw", false, "Print the messages as received instead of decoding their parts")
+	return notifyListenSMTPCmd
+}
+
+// smtpEnvelope is a message received by listen-smtp.
+type smtpEnvelope struct {
+	From string
+	To   []string
+	User string
+	TLS  bool
+	Data []byte
+}
+
+// serveSMTP answers an SMTP session on conn, calling receive for every
+// message. STARTTLS is offered when tlsConfig is set.
+func serveSMTP(conn net.Conn, tlsConfig *tls.Config, receive func(smtpEnvelope)) {
+	defer func() { conn.Close() }()
+	text := textproto.NewConn(conn)
+	var envelope smtpEnvelope
+	reply := func(format string, args ...any) bool {
+		return text.PrintfLine(format, args...) == nil
+	}
+	if !reply("220 FileCleanup test server ready") {
+		return
+	}
+	for {
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Minute))
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, argument, _ := strings.Cut(line, " ")
+		ok := true
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			lines := []string{"FileCleanup", "8BITMIME", "AUTH PLAIN"}
+			if tlsConfig != nil && !envelope.TLS {
+				lines = append(lines, "STARTTLS")
+			}
+			for i, l := range lines {
+				separator := "-"
+				if i == len(lines)-1 {
+					separator = " "
+				}
+				ok = ok && reply("250%s%s", separator, l)
+			}
+		case "HELO", "NOOP":
+			ok = reply("250 OK")
+		case "STARTTLS":
+			if tlsConfig == nil || envelope.TLS {
+				ok = reply("502 STARTTLS not offered")
+				break
+			}
+			if !reply("220 Ready to start TLS") {
+				return
+			}
+			tlsConn := tls.Server(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, text = tlsConn, textproto.NewConn(tlsConn)
+			envelope = smtpEnvelope{TLS: true}
+		case "AUTH":
+			mechanism, response, _ := strings.Cut(argument, " ")
+			if !strings.EqualFold(mechanism, "PLAIN") {
+				ok = reply("504 Only PLAIN is supported")
+				break
+			}
+			if response == "" {
+				if !reply("334 ") {
+					return
+				}
+				if response, err = text.ReadLine(); err != nil {
+					return
+				}
+			}
+			credentials, err := base64.StdEncoding.DecodeString(response)
+			fields := bytes.Split(credentials, []byte{0})
+			if err != nil || len(fields) != 3 {
+				ok = reply("501 Malformed credentials")
+				break
+			}
+			envelope.User = string(fields[1])
+			ok = reply("235 Authentication succeeded")
+		case "MAIL":
+			envelope.From = smtpPath(argument)
+			ok = reply("250 OK")
+		case "RCPT":
+			envelope.To = append(envelope.To, smtpPath(argument))
+			ok = reply("250 OK")
+		case "DATA":
+			if envelope.From == "" || len(envelope.To) == 0 {
+				ok = reply("503 MAIL and RCPT first")
+				break
+			}
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			if envelope.Data, err = text.ReadDotBytes(); err != nil {
+				return
+			}
+			receive(envelope)
+			envelope = smtpEnvelope{TLS: envelope.TLS, User: envelope.User}
+			ok = reply("250 OK")
+		case "RSET":
+			envelope = smtpEnvelope{TLS: envelope.TLS, User: envelope.User}
+			ok = reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			ok = reply("502 Command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// smtpPath returns the address of a MAIL FROM or RCPT TO argument.
+func smtpPath(argument string) string {
+	_, path, _ := strings.Cut(argument, ":")
+	path, _, _ = strings.Cut(strings.TrimSpace(path), " ")
+	return strings.Trim(path, "<>")
+}
+
+// writeEnvelope prints a received message, with the parts of multipart
+// messages decoded unless raw is set.
+func writeEnvelope(w io.Writer, envelope smtpEnvelope, raw bool) {
+	security := "plain text"
+	if envelope.TLS {
+		security = "TLS"
+	}
+	if envelope.User != "" {
+		security += ", authenticated as " + envelope.User
+	}
+	fmt.Fprintf(w, "%s message from %s to %s (%s)\n", time.Now().Format(time.DateTime), envelope.From, strings.Join(envelope.To, ", "), security)
+	message, err := mail.ReadMessage(bytes.NewReader(envelope.Data))
+	if raw || err != nil {
+		fmt.Fprintf(w, "%s\n\n", bytes.TrimSpace(envelope.Data))
+		return
+	}
+	decoder := new(mime.WordDecoder)
+	for _, name := range []string{"From", "To", "Subject", "Date"} {
+		value, err := decoder.DecodeHeader(message.Header.Get(name))
+		if err != nil {
+			value = message.Header.Get(name)
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, value)
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		body, _ := io.ReadAll(message.Body)
+		fmt.Fprintf(w, "\n%s\n\n", bytes.TrimSpace(body))
+		return
+	}
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := io.ReadAll(part)
+		fmt.Fprintf(w, "\n--- %s\n%s\n", part.Header.Get("Content-Type"), bytes.TrimSpace(body))
+	}
+	fmt.Fprintln(w)
+}
+
+// selfSignedCertificate returns a certificate for localhost valid for a day.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "FileCleanup test server"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
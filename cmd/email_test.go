@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startSMTP serves the test SMTP server on a loopback port and returns the
+// email config sending to it and the messages it receives.
+func startSMTP(t *testing.T, tlsConfig *tls.Config) (pkg.EmailConfig, <-chan smtpEnvelope) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan smtpEnvelope, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, tlsConfig, func(envelope smtpEnvelope) { received <- envelope })
+		}
+	}()
+	address := listener.Addr().(*net.TCPAddr)
+	config := pkg.EmailConfig{Host: "127.0.0.1", Port: address.Port, TLS: pkg.EmailTLSNone, From: "FileCleanup <cleanup@example.com>", To: []string{"ops@example.com"}}
+	return config, received
+}
+
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	certificate, err := selfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}}
+}
+
+func receiveEnvelope(t *testing.T, received <-chan smtpEnvelope) smtpEnvelope {
+	t.Helper()
+	select {
+	case envelope := <-received:
+		return envelope
+	default:
+		t.Fatal("no message received")
+		return smtpEnvelope{}
+	}
+}
+
+func TestSendEmailPlain(t *testing.T) {
+	config, received := startSMTP(t, nil)
+	if err := sendEmail(context.Background(), config, []byte("Subject: test\r\n\r\nbody\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	envelope := receiveEnvelope(t, received)
+	if envelope.From != "cleanup@example.com" || !slices.Equal(envelope.To, []string{"ops@example.com"}) {
+		t.Errorf("envelope from %q to %v", envelope.From, envelope.To)
+	}
+	if envelope.TLS || envelope.User != "" {
+		t.Errorf("plain session used TLS %v, user %q", envelope.TLS, envelope.User)
+	}
+	if !strings.Contains(string(envelope.Data), "body") {
+		t.Errorf("data %q", envelope.Data)
+	}
+}
+
+func TestSendEmailStartTLS(t *testing.T) {
+	config, received := startSMTP(t, testTLSConfig(t))
+	config.TLS = pkg.EmailTLSStartTLS
+	config.InsecureSkipVerify = true
+	if err := sendEmail(context.Background(), config, []byte("Subject: test\r\n\r\nbody\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if envelope := receiveEnvelope(t, received); !envelope.TLS {
+		t.Error("message was not sent over TLS")
+	}
+
+	// The self-signed certificate is refused without insecure_skip_verify
+	config.InsecureSkipVerify = false
+	if err := sendEmail(context.Background(), config, []byte("Subject: test\r\n\r\nbody\r\n")); err == nil {
+		t.Error("expected the certificate to be refused")
+	}
+}
+
+func TestSendEmailStartTLSNotOffered(t *testing.T) {
+	config, received := startSMTP(t, nil)
+	config.TLS = pkg.EmailTLSStartTLS
+	err := sendEmail(context.Background(), config, []byte("Subject: test\r\n\r\nbody\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("got %v, want an error about STARTTLS", err)
+	}
+	select {
+	case <-received:
+		t.Error("message sent without STARTTLS")
+	default:
+	}
+
+	// auto sends in the clear when STARTTLS is not offered
+	config.TLS = pkg.EmailTLSAuto
+	if err := sendEmail(context.Background(), config, []byte("Subject: test\r\n\r\nbody\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	receiveEnvelope(t, received)
+}
+
+func TestSendEmailAuthPlain(t *testing.T) {
+	config, received := startSMTP(t, nil)
+	config.Username, config.Password = "ops", "secret"
+	if err := sendEmail(context.Background(), config, []byte("Subject: test\r\n\r\nbody\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if envelope := receiveEnvelope(t, received); envelope.User != "ops" {
+		t.Errorf("authenticated as %q, want ops", envelope.User)
+	}
+}
+
+func TestSendEmailRecipients(t *testing.T) {
+	config, received := startSMTP(t, nil)
+	config.To = []string{"ops@example.com", "Backup Team <backup@example.com>", "oncall@example.org"}
+	if err := sendEmail(context.Background(), config, []byte("Subject: test\r\n\r\nbody\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ops@example.com", "backup@example.com", "oncall@example.org"}
+	if envelope := receiveEnvelope(t, received); !slices.Equal(envelope.To, want) {
+		t.Errorf("sent to %v, want %v", envelope.To, want)
+	}
+}
+
+func TestComposeEmailMultipart(t *testing.T) {
+	config, received := startSMTP(t, nil)
+	config.To = []string{"ops@example.com", "backup@example.com"}
+	d := sampleDigest(pkg.Config{})
+	message, err := composeEmail(config, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendEmail(context.Background(), config, message); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(receiveEnvelope(t, received).Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || !strings.Contains(subject, "212 files deleted") {
+		t.Errorf("subject %q, %v", subject, err)
+	}
+	if to, err := parsed.Header.AddressList("To"); err != nil || len(to) != 2 {
+		t.Errorf("To %v, %v", to, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type %q, %v", mediaType, err)
+	}
+
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	var types []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		types = append(types, contentType)
+		// NextPart decodes quoted-printable parts itself
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), "/var/log/app") {
+			t.Errorf("%s part does not list the rule:\n%s", contentType, body)
+		}
+	}
+	if !slices.Equal(types, []string{"text/plain", "text/html"}) {
+		t.Errorf("parts %v, want text/plain then text/html", types)
+	}
+}
+
+func TestComposeEmailReadsTemplateFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "digest.txt")
+	config := pkg.EmailConfig{From: "cleanup@example.com", To: []string{"ops@example.com"}, TextTemplateFile: path}
+	if _, err := composeEmail(config, sampleDigest(pkg.Config{})); err == nil || !strings.Contains(err.Error(), "text_template_file") {
+		t.Fatalf("got %v, want an error about the missing text_template_file", err)
+	}
+
+	if err := os.WriteFile(path, []byte("Deleted {{.DeletedFiles}} files on {{.Host}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	message, err := composeEmail(config, sampleDigest(pkg.Config{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	parsed, _ := mail.ReadMessage(bytes.NewReader(message))
+	_, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	part, err := multipart.NewReader(parsed.Body, params["boundary"]).NextRawPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(&body, quotedprintable.NewReader(part)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(body.String(), "Deleted 212 files on ") {
+		t.Errorf("text part %q was not rendered from the file", body.String())
+	}
+}
+
+func TestDigestSumsUpAuditLogSinceLastSent(t *testing.T) {
+	t.Setenv(pkg.HomeEnv, t.TempDir())
+	enableAuditLog(t, pkg.AuditLogConfig{})
+	lastSent := time.Now().Add(-24 * time.Hour)
+	for _, record := range []pkg.AuditRecord{
+		{Time: lastSent.Add(-time.Hour), Rule: "logs", Action: pkg.AuditActionDelete, Path: "/logs/old.log", Size: 1000, Result: pkg.AuditResultDeleted},
+		{Time: lastSent.Add(time.Hour), Rule: "logs", Action: pkg.AuditActionDelete, Path: "/logs/a.log", Size: 100, Result: pkg.AuditResultDeleted},
+		{Time: lastSent.Add(2 * time.Hour), Rule: "logs", Action: pkg.AuditActionDelete, Path: "/logs/b.log", Size: 200, Result: pkg.AuditResultDeleted},
+		{Time: lastSent.Add(3 * time.Hour), Rule: "logs", Action: pkg.AuditActionDelete, Path: "/logs/c.log", Result: pkg.AuditResultError, Error: "remove /logs/c.log: permission denied"},
+		{Time: lastSent.Add(4 * time.Hour), Rule: "cache", Action: pkg.AuditActionDelete, Path: "/cache/gone", Size: 50, Result: pkg.AuditResultNotFound},
+	} {
+		auditLog.record(record)
+	}
+	saveLastDigest(lastSent)
+
+	g := &digester{since: time.Now(), stats: make(map[string]*digestRule), changed: make(chan struct{}, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	g.start(ctx)
+	if !g.since.Equal(lastSent) {
+		t.Fatalf("digest since %s, want the last sent time %s", g.since, lastSent)
+	}
+	// Only the runs are taken from memory, the deletions from the audit log
+	g.record(daemonEvent{Type: eventJobFinished, Rule: "logs", Job: retentionJob, Result: &runResult{DeletedFiles: 7, ReclaimedBytes: 7000}})
+
+	// A digest that could not be sent is not counted twice
+	config, received := startSMTP(t, nil)
+	unreachable := config
+	unreachable.Port = 1
+	g.configure(&unreachable)
+	if _, _, err := g.send(ctx, true); err == nil {
+		t.Fatal("expected sending to an unreachable server to fail")
+	}
+	g.configure(&config)
+	d, sent, err := g.send(ctx, true)
+	if err != nil || !sent {
+		t.Fatalf("sent %v: %v", sent, err)
+	}
+	receiveEnvelope(t, received)
+
+	if !d.Since.Equal(lastSent) {
+		t.Errorf("digest since %s, want %s", d.Since, lastSent)
+	}
+	if d.DeletedFiles != 2 || d.ReclaimedBytes != 300 || d.Errors != 1 {
+		t.Errorf("digest totals %d files, %d bytes, %d errors, want 2, 300 and 1", d.DeletedFiles, d.ReclaimedBytes, d.Errors)
+	}
+	if len(d.Rules) != 2 || d.Rules[1].Name != "logs" || d.Rules[1].Runs != 1 ||
+		!slices.Equal(d.Rules[1].ErrorMessages, []string{"remove /logs/c.log: permission denied"}) {
+		t.Errorf("digest rules %+v", d.Rules)
+	}
+	if saved, err := loadLastDigest(); err != nil || !saved.Equal(d.Until) {
+		t.Errorf("last digest saved as %s, %v, want %s", saved, err, d.Until)
+	}
+}
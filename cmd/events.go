@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Types of the events published on the event bus.
+const (
+	eventJobStarted     = "job_started"
+	eventJobFinished    = "job_finished"
+	eventJobSkipped     = "job_skipped"
+	eventFileAdded      = "file_added"
+	eventFileDeleted    = "file_deleted"
+	eventDeleteFailed   = "delete_failed"
+	eventRulePaused     = "rule_paused"
+	eventRuleResumed    = "rule_resumed"
+	eventConfigReloaded = "config_reloaded"
+	eventReloadFailed   = "config_reload_failed"
+
+	// Published after job_finished for the runs notifications are sent for.
+	eventSafetyCapReached  = pkg.NotifySafetyCapReached
+	eventDeleteErrors      = pkg.NotifyDeleteErrors
+	eventSizeLimitExceeded = pkg.NotifySizeLimitExceeded
+)
+
+// eventTypes lists every event type, for completions.
+var eventTypes = []string{eventJobStarted, eventJobFinished, eventJobSkipped, eventFileAdded, eventFileDeleted,
+	eventDeleteFailed, eventRulePaused, eventRuleResumed, eventConfigReloaded, eventReloadFailed,
+	eventSafetyCapReached, eventDeleteErrors, eventSizeLimitExceeded}
+
+// daemonEvent is something that happened in the daemon, streamed by the
+// control API.
+type daemonEvent struct {
+	Time   time.Time  `json:"time"`
+	Type   string     `json:"type"`
+	Rule   string     `json:"rule,omitempty"`
+	Job    jobKind    `json:"job,omitempty"`
+	Path   string     `json:"path,omitempty"`
+	Size   int64      `json:"size,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+	Result *runResult `json:"result,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// eventBus fans the published events out to its subscribers. A subscriber
+// that does not keep up misses events instead of slowing the daemon down.
+type eventBus struct {
+	mu sync.Mutex
+	// subscribers maps the channel of every subscriber to the event types
+	// it receives, all of them when empty.
+	subscribers map[chan daemonEvent][]string
+}
+
+var events = &eventBus{subscribers: make(map[chan daemonEvent][]string)}
+
+// subscribe returns a channel receiving the events of types published from
+// now on, all events when no type is given.
+func (b *eventBus) subscribe(types ...string) chan daemonEvent {
+	ch := make(chan daemonEvent, 256)
+	b.mu.Lock()
+	b.subscribers[ch] = types
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch chan daemonEvent) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
+
+func (b *eventBus) publish(event daemonEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch, types := range b.subscribers {
+		if len(types) > 0 && !slices.Contains(types, event.Type) {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
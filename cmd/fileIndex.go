@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"container/heap"
+)
+
+// FileIndex keeps the watched files ordered by modification time so the oldest
+// file can be found and evicted in O(log n) instead of scanning every entry.
+// FileIndex is not safe for concurrent use; callers guard it with mutex.
+type FileIndex struct {
+	entries map[string]*indexEntry
+	order   modTimeHeap
+	size    int64
+}
+
+type indexEntry struct {
+	path string
+	info FileInfo
+	pos  int
+}
+
+func NewFileIndex() *FileIndex {
+	return &FileIndex{
+		entries: make(map[string]*indexEntry),
+	}
+}
+
+// Put adds the file to the index or updates it when it is already tracked.
+func (idx *FileIndex) Put(path string, info FileInfo) {
+	if entry, ok := idx.entries[path]; ok {
+		idx.size += info.Size - entry.info.Size
+		entry.info = info
+		heap.Fix(&idx.order, entry.pos)
+		return
+	}
+	entry := &indexEntry{path: path, info: info}
+	idx.entries[path] = entry
+	idx.size += info.Size
+	heap.Push(&idx.order, entry)
+}
+
+// Get returns the indexed information of path.
+func (idx *FileIndex) Get(path string) (FileInfo, bool) {
+	entry, ok := idx.entries[path]
+	if !ok {
+		return FileInfo{}, false
+	}
+	return entry.info, true
+}
+
+// Remove drops path from the index and returns the information it held.
+func (idx *FileIndex) Remove(path string) (FileInfo, bool) {
+	entry, ok := idx.entries[path]
+	if !ok {
+		return FileInfo{}, false
+	}
+	heap.Remove(&idx.order, entry.pos)
+	delete(idx.entries, path)
+	idx.size -= entry.info.Size
+	return entry.info, true
+}
+
+// Oldest returns the least recently modified file without removing it.
+func (idx *FileIndex) Oldest() (string, FileInfo, bool) {
+	if len(idx.order) == 0 {
+		return "", FileInfo{}, false
+	}
+	return idx.order[0].path, idx.order[0].info, true
+}
+
+// PopOldest removes and returns the least recently modified file.
+func (idx *FileIndex) PopOldest() (string, FileInfo, bool) {
+	if len(idx.order) == 0 {
+		return "", FileInfo{}, false
+	}
+	entry := heap.Pop(&idx.order).(*indexEntry)
+	delete(idx.entries, entry.path)
+	idx.size -= entry.info.Size
+	return entry.path, entry.info, true
+}
+
+// Len returns the number of indexed files.
+func (idx *FileIndex) Len() int {
+	return len(idx.entries)
+}
+
+// Size returns the total size in bytes of all indexed files.
+func (idx *FileIndex) Size() int64 {
+	return idx.size
+}
+
+// Range calls fn for every indexed file in no particular order until fn returns false.
+func (idx *FileIndex) Range(fn func(path string, info FileInfo) bool) {
+	for path, entry := range idx.entries {
+		if !fn(path, entry.info) {
+			return
+		}
+	}
+}
+
+// Ascend calls fn for the indexed files from the least recently modified
+// until fn returns false, without changing the index. Visiting k files costs
+// O(k log k) however many files are indexed.
+func (idx *FileIndex) Ascend(fn func(path string, info FileInfo) bool) {
+	if len(idx.order) == 0 {
+		return
+	}
+	frontier := &positionHeap{order: idx.order, positions: []int{0}}
+	for frontier.Len() > 0 {
+		pos := heap.Pop(frontier).(int)
+		entry := idx.order[pos]
+		if !fn(entry.path, entry.info) {
+			return
+		}
+		// The children of an entry are the only ones that can be next
+		for _, child := range [2]int{2*pos + 1, 2*pos + 2} {
+			if child < len(idx.order) {
+				heap.Push(frontier, child)
+			}
+		}
+	}
+}
+
+// modTimeHeap is a min-heap of index entries ordered by ModTime.
+type modTimeHeap []*indexEntry
+
+func (h modTimeHeap) Len() int { return len(h) }
+
+func (h modTimeHeap) Less(i, j int) bool { return h[i].info.ModTime.Before(h[j].info.ModTime) }
+
+func (h modTimeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *modTimeHeap) Push(x any) {
+	entry := x.(*indexEntry)
+	entry.pos = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *modTimeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.pos = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// positionHeap is a min-heap of positions in order, by the ModTime of the
+// entry at each position.
+type positionHeap struct {
+	order     modTimeHeap
+	positions []int
+}
+
+func (h *positionHeap) Len() int { return len(h.positions) }
+
+func (h *positionHeap) Less(i, j int) bool { return h.order.Less(h.positions[i], h.positions[j]) }
+
+func (h *positionHeap) Swap(i, j int) {
+	h.positions[i], h.positions[j] = h.positions[j], h.positions[i]
+}
+
+func (h *positionHeap) Push(x any) { h.positions = append(h.positions, x.(int)) }
+
+func (h *positionHeap) Pop() any {
+	n := len(h.positions)
+	pos := h.positions[n-1]
+	h.positions = h.positions[:n-1]
+	return pos
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestFileIndexAscend(t *testing.T) {
+	idx := NewFileIndex()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, n := range rand.New(rand.NewSource(1)).Perm(500) {
+		idx.Put(fmt.Sprintf("file%d", i), FileInfo{Size: 1, ModTime: base.Add(time.Duration(n) * time.Second)})
+	}
+
+	var visited []time.Time
+	idx.Ascend(func(path string, info FileInfo) bool {
+		visited = append(visited, info.ModTime)
+		return true
+	})
+	if len(visited) != idx.Len() {
+		t.Fatalf("visited %d files, want %d", len(visited), idx.Len())
+	}
+	for i, modTime := range visited {
+		if want := base.Add(time.Duration(i) * time.Second); !modTime.Equal(want) {
+			t.Fatalf("file %d modified at %s, want %s", i, modTime, want)
+		}
+	}
+
+	count := 0
+	idx.Ascend(func(path string, info FileInfo) bool {
+		count++
+		return count < 10
+	})
+	if count != 10 {
+		t.Errorf("Ascend went on after fn returned false, %d calls", count)
+	}
+
+	// Walking leaves the index untouched
+	for i := range 500 {
+		_, info, ok := idx.PopOldest()
+		if !ok || !info.ModTime.Equal(base.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("PopOldest %d = %s, %v after Ascend", i, info.ModTime, ok)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Output formats of the history and report commands.
+var auditOutputFormats = []string{"table", "csv", "json"}
+
+// auditQuery holds the flags history and report share.
+type auditQuery struct {
+	auditLog string
+	rules    []string
+	since    string
+	until    string
+	output   string
+}
+
+func (q *auditQuery) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&q.auditLog, "audit-log", "", "Audit log to read, defaults to audit_log.path of the configuration")
+	flags.StringSliceVar(&q.rules, "rule", nil, "Only records of these rules")
+	flags.StringVar(&q.since, "since", "", "Only records from this time on, as 2006-01-02, RFC 3339 or a duration ago such as 7d")
+	flags.StringVar(&q.until, "until", "", "Only records before this time, in the forms of --since")
+	flags.StringVarP(&q.output, "output", "o", "table", "Output format: "+strings.Join(auditOutputFormats, ", "))
+	_ = cmd.MarkFlagFilename("audit-log", "jsonl")
+	_ = cmd.RegisterFlagCompletionFunc("rule", completeRuleNames)
+	_ = cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(auditOutputFormats, cobra.ShellCompDirectiveNoFileComp))
+}
+
+// filter builds the filter of the shared flags and checks the output format.
+func (q *auditQuery) filter(now time.Time) (pkg.AuditFilter, error) {
+	if !matchAnyFormat(q.output) {
+		return pkg.AuditFilter{}, fmt.Errorf("unknown output format %q, use one of %s", q.output, strings.Join(auditOutputFormats, ", "))
+	}
+	filter := pkg.AuditFilter{Rules: q.rules}
+	var err error
+	if filter.Since, err = parseTimeFlag(q.since, now); err != nil {
+		return pkg.AuditFilter{}, fmt.Errorf("invalid --since: %w", err)
+	}
+	if filter.Until, err = parseTimeFlag(q.until, now); err != nil {
+		return pkg.AuditFilter{}, fmt.Errorf("invalid --until: %w", err)
+	}
+	return filter, nil
+}
+
+// read calls fn with the records of the audit log that pass filter.
+func (q *auditQuery) read(cmd *cobra.Command, filter pkg.AuditFilter, fn func(pkg.AuditRecord)) error {
+	path := q.auditLog
+	if path == "" {
+		if AppConfig.AuditLog == nil {
+			return errors.New("the configuration has no audit_log, give the file with --audit-log")
+		}
+		path = AppConfig.AuditLog.Path
+	}
+	skipped, err := pkg.ReadAuditLog(path, func(record pkg.AuditRecord) error {
+		if filter.Match(record) {
+			fn(record)
+		}
+		return nil
+	})
+	if skipped > 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "warning: skipped %d lines of the audit log %s or its rotated files that are not records\n", skipped, path)
+	}
+	return err
+}
+
+func matchAnyFormat(format string) bool {
+	for _, known := range auditOutputFormats {
+		if format == known {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTimeFlag parses a point in time given as a date, a date and time, or a
+// duration before now. The empty string is the zero time.
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateOnly, "2006-01-02 15:04", time.DateTime} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := pkg.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date, time or duration", value)
+}
+
+func historyCmd() *cobra.Command {
+	var (
+		query   auditQuery
+		path    string
+		actions []string
+		results []string
+		limit   int
+	)
+	var historyCmd = &cobra.Command{
+		Use:   "history [...FLAGS]",
+		Short: "Show past deletions from the audit log",
+		Long:  "Show the records of the audit log, oldest first, including its rotated files. The flags narrow the records down.",
+		Example: `fileCleanup history --rule uploads --since 7d
+fileCleanup history --path '*.log' --result error -o csv`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter, err := query.filter(time.Now())
+			if err != nil {
+				return err
+			}
+			filter.Path = path
+			filter.Actions = actions
+			filter.Results = results
+
+			var records []pkg.AuditRecord
+			if err := query.read(cmd, filter, func(record pkg.AuditRecord) {
+				records = append(records, record)
+				// Keep the most recent records
+				if limit > 0 && len(records) > limit {
+					records = records[1:]
+				}
+			}); err != nil {
+				return err
+			}
+			return writeHistory(cmd.OutOrStdout(), query.output, records)
+		},
+	}
+	query.addFlags(historyCmd)
+	flags := historyCmd.Flags()
+	flags.StringVar(&path, "path", "", "Only files matching this glob pattern, matched against the file name when it has no path separator")
+	flags.StringSliceVar(&actions, "action", nil, "Only these actions: "+pkg.AuditActionDelete)
+	flags.StringSliceVar(&results, "result", nil, "Only these results: "+strings.Join([]string{pkg.AuditResultDeleted, pkg.AuditResultNotFound, pkg.AuditResultError}, ", "))
+	flags.IntVarP(&limit, "limit", "n", 0, "Only the most recent records, 0 shows all")
+	_ = historyCmd.RegisterFlagCompletionFunc("action", cobra.FixedCompletions([]string{pkg.AuditActionDelete}, cobra.ShellCompDirectiveNoFileComp))
+	_ = historyCmd.RegisterFlagCompletionFunc("result", cobra.FixedCompletions([]string{pkg.AuditResultDeleted, pkg.AuditResultNotFound, pkg.AuditResultError}, cobra.ShellCompDirectiveNoFileComp))
+	return historyCmd
+}
+
+func writeHistory(w io.Writer, format string, records []pkg.AuditRecord) error {
+	switch format {
+	case "json":
+		if records == nil {
+			records = []pkg.AuditRecord{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case "csv":
+		out := csv.NewWriter(w)
+		out.Write([]string{"time", "rule", "action", "path", "size", "mtime", "reason", "result", "error"})
+		for _, r := range records {
+			out.Write([]string{r.Time.Format(time.RFC3339Nano), r.Rule, r.Action, r.Path, strconv.FormatInt(r.Size, 10),
+				r.ModTime.Format(time.RFC3339Nano), r.Reason, r.Result, r.Error})
+		}
+		out.Flush()
+		return out.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tRULE\tACTION\tREASON\tRESULT\tSIZE\tPATH\tERROR")
+		for _, r := range records {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Time.Local().Format(time.DateTime), r.Rule, r.Action, r.Reason,
+				r.Result, pkg.FormatBytes(r.Size), r.Path, r.Error)
+		}
+		return tw.Flush()
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd())
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTPAddress overrides http_address of the configuration, set with the
+// --http-address flag of clean.
+var HTTPAddress string
+
+// handler routes the requests of the HTTP server and the socket. The routes
+// of the control API are wrapped in control.
+func (d *daemon) handler(control func(http.HandlerFunc) http.HandlerFunc) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", d.serveMetrics)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, req *http.Request) {
+		if !d.isReady() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "indexing"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, d.status())
+	})
+	d.controlRoutes(mux, control)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Debugln("Error writing response:", err)
+	}
+}
+
+// serve serves handler on listener until stopHTTPServers.
+func (d *daemon) serve(listener net.Listener, handler http.Handler) {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	d.servers = append(d.servers, server)
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Errorln("HTTP server stopped:", err)
+		}
+	}()
+}
+
+// startHTTPServer serves metrics, health and status on address, along with
+// the control API to requests carrying control_token.
+func (d *daemon) startHTTPServer(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	d.serve(listener, d.handler(requireToken))
+	log.Infof("Serving metrics, health and status on http://%s", listener.Addr())
+	return nil
+}
+
+// startSocketServer serves the status and ctl commands on the Unix socket at
+// path, access to it being limited by its permissions. A socket left behind
+// by a daemon that did not shut down is replaced, one of a running daemon is
+// not.
+func (d *daemon) startSocketServer(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return errors.New("another FileCleanup is serving " + path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	// Only the user running the daemon may query and later control it
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return err
+	}
+	d.serve(listener, d.handler(func(next http.HandlerFunc) http.HandlerFunc { return next }))
+	log.Infoln("Serving status and control on", path)
+	return nil
+}
+
+// stopHTTPServers stops the servers, giving running requests a moment to
+// finish.
+func (d *daemon) stopHTTPServers() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range d.servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorln("Error stopping HTTP server:", err)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package cmd
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// indexSnapshotVersion is bumped whenever the layout of indexSnapshot changes.
+// Snapshots written with another version are ignored and the folders re-walked.
+const indexSnapshotVersion = 1
+
+// dirModTimes holds the modification time of every scanned directory. It is
+// guarded by mutex and persisted alongside the rule indexes so unchanged
+// directories can be restored without listing them again.
+var dirModTimes = make(map[string]time.Time)
+
+type indexSnapshot struct {
+	Version int
+	SavedAt time.Time
+	Dirs    map[string]time.Time
+	Files   map[string]FileInfo
+}
+
+// loadIndexSnapshot reads the snapshot stored at path. A missing file is not an
+// error and results in a nil snapshot.
+func loadIndexSnapshot(path string) (*indexSnapshot, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var snapshot indexSnapshot
+	if err := gob.NewDecoder(file).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("decoding index %s: %w", path, err)
+	}
+	if snapshot.Version != indexSnapshotVersion {
+		return nil, fmt.Errorf("index %s has version %d, expected %d", path, snapshot.Version, indexSnapshotVersion)
+	}
+	return &snapshot, nil
+}
+
+// saveIndexSnapshot writes the index of every active rule to path. Each rule is
+// copied under its own hold of mutex so new files are only held up by the copy
+// of a single index. The snapshot is written to a temporary file first and
+// renamed so a crash never leaves a truncated index behind.
+func saveIndexSnapshot(path string) error {
+	mutex.Lock()
+	active := make([]*rule, 0, len(rules))
+	files := 0
+	for _, r := range rules {
+		active = append(active, r)
+		files += r.index.Len()
+	}
+	snapshot := indexSnapshot{
+		Version: indexSnapshotVersion,
+		SavedAt: time.Now(),
+		Dirs:    make(map[string]time.Time, len(dirModTimes)),
+		Files:   make(map[string]FileInfo, files),
+	}
+	for dir, modTime := range dirModTimes {
+		snapshot.Dirs[dir] = modTime
+	}
+	mutex.Unlock()
+	for _, r := range active {
+		mutex.Lock()
+		r.index.Range(func(path string, info FileInfo) bool {
+			snapshot.Files[path] = info
+			return true
+		})
+		mutex.Unlock()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(&snapshot); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// indexPersistDelay is how long the changes of cleanup runs are collected
+// before the index is saved.
+const indexPersistDelay = time.Minute
+
+var indexPersist struct {
+	// save serializes the writers of the snapshot.
+	save  sync.Mutex
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// persistFileIndex saves the index when a persistent index is configured,
+// replacing a pending delayed save.
+func persistFileIndex() {
+	indexPersist.mu.Lock()
+	if indexPersist.timer != nil {
+		indexPersist.timer.Stop()
+		indexPersist.timer = nil
+	}
+	indexPersist.mu.Unlock()
+
+	mutex.Lock()
+	path, detailed := AppConfig.IndexFilePath, AppConfig.IsDetailedLogEnabled
+	mutex.Unlock()
+	if path == "" {
+		return
+	}
+	indexPersist.save.Lock()
+	defer indexPersist.save.Unlock()
+	start := time.Now()
+	if err := saveIndexSnapshot(path); err != nil {
+		log.Errorln("Error saving file index:", err)
+		return
+	}
+	if detailed {
+		log.Debugf("Saved file index to %s in %s", path, time.Since(start))
+	}
+}
+
+// schedulePersistFileIndex saves the index indexPersistDelay from now unless a
+// save is already pending, so runs deleting files in quick succession share
+// one save. The daemon saves the index again when it stops.
+func schedulePersistFileIndex() {
+	indexPersist.mu.Lock()
+	defer indexPersist.mu.Unlock()
+	if indexPersist.timer != nil {
+		return
+	}
+	indexPersist.timer = time.AfterFunc(indexPersistDelay, persistFileIndex)
+}
+
+// pruneDirModTimes forgets the directories outside the target folders of the
+// active rules. It must be called with mutex held.
+func pruneDirModTimes() {
+	for dir := range dirModTimes {
+		kept := false
+		for _, r := range rules {
+			if isWithin(dir, r.config.TargetFolder) {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			delete(dirModTimes, dir)
+		}
+	}
+}
+
+// forgetDir drops a removed directory and everything below it from
+// dirModTimes. Paths that are not known directories are ignored.
+func forgetDir(dir string) {
+	dir = filepath.Clean(dir)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := dirModTimes[dir]; !ok {
+		return
+	}
+	for known := range dirModTimes {
+		if isWithin(known, dir) {
+			delete(dirModTimes, known)
+		}
+	}
+}
+
+// indexReconciler restores rule indexes from a snapshot. Directories whose
+// modification time did not change since the snapshot was taken are not
+// listed again, their files are only stat'ed; new or modified directories are
+// listed from disk.
+type indexReconciler struct {
+	snapshot    *indexSnapshot
+	filesByDir  map[string][]string
+	subdirs     map[string][]string
+	restored    atomic.Int64
+	changed     atomic.Int64
+	rescanned   atomic.Int64
+	scannedDirs atomic.Int64
+}
+
+func newIndexReconciler(snapshot *indexSnapshot) *indexReconciler {
+	r := &indexReconciler{
+		snapshot:   snapshot,
+		filesByDir: make(map[string][]string),
+		subdirs:    make(map[string][]string),
+	}
+	for path := range snapshot.Files {
+		dir := filepath.Dir(path)
+		r.filesByDir[dir] = append(r.filesByDir[dir], path)
+	}
+	for dir := range snapshot.Dirs {
+		parent := filepath.Dir(dir)
+		if parent != dir {
+			r.subdirs[parent] = append(r.subdirs[parent], dir)
+		}
+	}
+	return r
+}
+
+// restoreDir returns the files of the unchanged directory dir. Writing to a
+// file in place does not change the modification time of its directory, so
+// only the names are taken from the snapshot: every file is stat'ed again and
+// the ones that are gone are dropped.
+func (r *indexReconciler) restoreDir(dir string) (map[string]FileInfo, error) {
+	files := make(map[string]FileInfo, len(r.filesByDir[dir]))
+	for _, path := range r.filesByDir[dir] {
+		stat, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			r.changed.Add(1)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		info := FileInfo{Size: stat.Size(), ModTime: stat.ModTime()}
+		if known := r.snapshot.Files[path]; known.Size != info.Size || !known.ModTime.Equal(info.ModTime) {
+			r.changed.Add(1)
+		}
+		files[path] = info
+	}
+	return files, nil
+}
+
+func (r *indexReconciler) reconcile(targetFolder string, concurrency int, index *FileIndex) error {
+	scanner := newFolderScanner(concurrency, index, nil)
+	scanner.visit = func(dir string) ([]string, error) {
+		modTime, err := recordDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if known, ok := r.snapshot.Dirs[dir]; ok && known.Equal(modTime) {
+			files, err := r.restoreDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			mutex.Lock()
+			for path, info := range files {
+				index.Put(path, info)
+			}
+			mutex.Unlock()
+			r.restored.Add(int64(len(files)))
+			scanner.files.Add(int64(len(files)))
+			return r.subdirs[dir], nil
+		}
+
+		r.rescanned.Add(1)
+		return scanner.listDir(dir)
+	}
+	if err := scanner.Scan(targetFolder); err != nil {
+		return err
+	}
+	r.scannedDirs.Add(scanner.dirs.Load())
+	return nil
+}
+
+// loadFileIndex fills the index of the given rules, reconciling a persisted
+// snapshot when one is configured and falling back to a full scan.
+func loadFileIndex(rs []*rule) error {
+	if len(rs) == 0 {
+		return nil
+	}
+	mutex.Lock()
+	path := AppConfig.IndexFilePath
+	mutex.Unlock()
+	var snapshot *indexSnapshot
+	if path != "" {
+		var err error
+		snapshot, err = loadIndexSnapshot(path)
+		if err != nil {
+			log.Warningln("Ignoring persisted file index:", err)
+			snapshot = nil
+		}
+	}
+
+	if snapshot == nil {
+		for _, r := range rs {
+			if err := populateFileIndex(r); err != nil {
+				return err
+			}
+		}
+		persistFileIndex()
+		return nil
+	}
+
+	start := time.Now()
+	reconciler := newIndexReconciler(snapshot)
+	for _, r := range rs {
+		if _, err := os.Stat(r.config.TargetFolder); os.IsNotExist(err) {
+			r.logger().Warningln("Target folder does not exist:", r.config.TargetFolder)
+			r.missing.Store(true)
+			continue
+		}
+		if err := reconciler.reconcile(filepath.Clean(r.config.TargetFolder), r.config.ScanConcurrency, r.index); err != nil {
+			return err
+		}
+	}
+	log.Infof("Reconciled file index in %s - restored %d files, %d changed since the snapshot, rescanned %d of %d folders",
+		time.Since(start), reconciler.restored.Load(), reconciler.changed.Load(), reconciler.rescanned.Load(), reconciler.scannedDirs.Load())
+	persistFileIndex()
+	return nil
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconcileRestatsFilesOfUnchangedDirs(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-8 * 24 * time.Hour).Truncate(time.Second)
+	keep, gone := filepath.Join(sub, "keep.log"), filepath.Join(sub, "gone.log")
+	for _, path := range []string{keep, gone} {
+		if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The snapshot taken before the daemon stopped
+	snapshot := &indexSnapshot{
+		Version: indexSnapshotVersion,
+		Dirs:    make(map[string]time.Time),
+		Files: map[string]FileInfo{
+			keep: {Size: 4, ModTime: old},
+			gone: {Size: 4, ModTime: old},
+		},
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{root, sub} {
+		if err := os.Chtimes(dir, old, old); err != nil {
+			t.Fatal(err)
+		}
+		snapshot.Dirs[dir] = old
+	}
+
+	// Appending to a file leaves the modification time of its directory alone
+	file, err := os.OpenFile(keep, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString("written while the daemon was stopped\n"); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if stat, _ := os.Stat(sub); !stat.ModTime().Equal(old) {
+		t.Fatal("appending changed the modification time of the directory")
+	}
+
+	r := &rule{config: pkg.DeleteConfig{TargetFolder: root, RetentionDays: 7}, index: NewFileIndex()}
+	reconciler := newIndexReconciler(snapshot)
+	if err := reconciler.reconcile(root, 2, r.index); err != nil {
+		t.Fatal(err)
+	}
+	if reconciler.rescanned.Load() != 0 {
+		t.Errorf("rescanned %d folders, want none", reconciler.rescanned.Load())
+	}
+	if reconciler.restored.Load() != 1 || reconciler.changed.Load() != 2 {
+		t.Errorf("restored %d files with %d changed, want 1 with 2 changed", reconciler.restored.Load(), reconciler.changed.Load())
+	}
+	if _, ok := r.index.Get(gone); ok {
+		t.Error("a deleted file was restored from the snapshot")
+	}
+	info, ok := r.index.Get(keep)
+	if !ok || info.Size == 4 || !info.ModTime.After(old) {
+		t.Fatalf("keep.log restored as %+v, want its current size and modification time", info)
+	}
+
+	if result := DeleteOldFiles(context.Background(), r); result.DeletedFiles != 0 {
+		t.Errorf("retention deleted %d files", result.DeletedFiles)
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Errorf("the file written after the snapshot was deleted: %v", err)
+	}
+}
@@ -11,22 +11,27 @@ import (
 
 var lumberjackLogger *lumberjack.Logger
 
+// InitLogger logs to stderr and, when the configuration sets log_file_path,
+// to a rotated file in that folder.
 func InitLogger() {
-	lumberjackLogger := &lumberjack.Logger{
-		Filename:   path.Join(AppConfig.LogFilePath, "/FileCleanup/log/FileCleanup.log"),
-		MaxSize:    1,
-		MaxBackups: 3,
-		MaxAge:     28,
-		Compress:   true,
-	}
+	var output io.Writer = os.Stderr
+	if AppConfig.LogFilePath != "" {
+		lumberjackLogger := &lumberjack.Logger{
+			Filename:   path.Join(AppConfig.LogFilePath, "/FileCleanup/log/FileCleanup.log"),
+			MaxSize:    1,
+			MaxBackups: 3,
+			MaxAge:     28,
+			Compress:   true,
+		}
 
-	// Fork writing into two outputs
-	multiWriter := io.MultiWriter(os.Stderr, lumberjackLogger)
+		// Fork writing into two outputs
+		output = io.MultiWriter(os.Stderr, lumberjackLogger)
+	}
 
 	logFormatter := new(log.TextFormatter)
 	logFormatter.TimestampFormat = time.DateTime // or RFC3339
 	logFormatter.FullTimestamp = true
 
 	log.SetFormatter(logFormatter)
-	log.SetOutput(multiWriter)
+	log.SetOutput(output)
 }
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// counterVec is a Prometheus counter with labels, kept in memory for the
+// lifetime of the daemon.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// add increases the counter of labelValues, given in the order of the labels,
+// by v.
+func (c *counterVec) add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	c.values[strings.Join(labelValues, "\xff")] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	samples := make([]metricSample, 0, len(c.values))
+	for key, value := range c.values {
+		samples = append(samples, metricSample{labels: c.labelPairs(strings.Split(key, "\xff")), value: value})
+	}
+	c.mu.Unlock()
+	writeMetric(w, c.name, c.help, "counter", samples)
+}
+
+func (c *counterVec) labelPairs(values []string) []string {
+	pairs := make([]string, 0, 2*len(c.labels))
+	for i, label := range c.labels {
+		pairs = append(pairs, label, values[i])
+	}
+	return pairs
+}
+
+var (
+	deletedFilesTotal   = newCounterVec("filecleanup_deleted_files_total", "Files deleted, by rule and reason.", "rule", "reason")
+	reclaimedBytesTotal = newCounterVec("filecleanup_reclaimed_bytes_total", "Bytes freed by deleting files, by rule and reason.", "rule", "reason")
+	errorsTotal         = newCounterVec("filecleanup_errors_total", "Errors, by rule and operation.", "rule", "operation")
+	watcherEventsTotal  = newCounterVec("filecleanup_watcher_events_total", "File system events received from the watcher, by operation.", "op")
+)
+
+// metricSample is a value with its labels given as name, value pairs.
+type metricSample struct {
+	labels []string
+	value  float64
+}
+
+// writeMetric writes a metric in the Prometheus text exposition format,
+// its samples sorted by their labels.
+func writeMetric(w io.Writer, name, help, kind string, samples []metricSample) {
+	lines := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		var b strings.Builder
+		b.WriteString(name)
+		if len(sample.labels) > 0 {
+			b.WriteByte('{')
+			for i := 0; i < len(sample.labels); i += 2 {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				fmt.Fprintf(&b, "%s=\"%s\"", sample.labels[i], escapeLabelValue(sample.labels[i+1]))
+			}
+			b.WriteByte('}')
+		}
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatFloat(sample.value, 'g', -1, 64))
+		lines = append(lines, b.String())
+	}
+	sort.Strings(lines)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// eventOp names the operation of a watcher event for metrics.
+func eventOp(event fsnotify.Event) string {
+	for _, op := range []fsnotify.Op{fsnotify.Create, fsnotify.Write, fsnotify.Remove, fsnotify.Rename, fsnotify.Chmod} {
+		if event.Has(op) {
+			return strings.ToLower(op.String())
+		}
+	}
+	return "unknown"
+}
+
+// serveMetrics writes the counters together with gauges read from the active
+// rules, their jobs and their disks at the time of the scrape.
+func (d *daemon) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	var files, bytes, paused, diskFree, diskTotal, lastRun, lastDuration, running []metricSample
+	for _, r := range activeRules() {
+		name := r.config.DisplayName()
+		mutex.Lock()
+		files = append(files, metricSample{labels: []string{"rule", name}, value: float64(r.index.Len())})
+		bytes = append(bytes, metricSample{labels: []string{"rule", name}, value: float64(r.index.Size())})
+		mutex.Unlock()
+		value := 0.0
+		if r.paused.Load() {
+			value = 1
+		}
+		paused = append(paused, metricSample{labels: []string{"rule", name}, value: value})
+
+		if free, total, err := diskUsage(r.config.TargetFolder); err == nil {
+			diskFree = append(diskFree, metricSample{labels: []string{"rule", name}, value: float64(free)})
+			diskTotal = append(diskTotal, metricSample{labels: []string{"rule", name}, value: float64(total)})
+		}
+
+		for _, job := range r.sched.jobs {
+			labels := []string{"rule", name, "job", string(job.kind)}
+			job.mu.Lock()
+			if !job.lastRun.IsZero() {
+				lastRun = append(lastRun, metricSample{labels: labels, value: float64(job.lastRun.UnixNano()) / float64(time.Second)})
+				lastDuration = append(lastDuration, metricSample{labels: labels, value: job.lastDuration.Seconds()})
+			}
+			value := 0.0
+			if job.running {
+				value = 1
+			}
+			running = append(running, metricSample{labels: labels, value: value})
+			job.mu.Unlock()
+		}
+	}
+
+	writeMetric(w, "filecleanup_rule_indexed_files", "Files in the index of the rule.", "gauge", files)
+	writeMetric(w, "filecleanup_rule_indexed_bytes", "Total size of the files in the index of the rule.", "gauge", bytes)
+	writeMetric(w, "filecleanup_rule_paused", "Whether the scheduled runs of the rule are paused.", "gauge", paused)
+	writeMetric(w, "filecleanup_disk_free_bytes", "Free space of the disk holding the target folder of the rule.", "gauge", diskFree)
+	writeMetric(w, "filecleanup_disk_total_bytes", "Size of the disk holding the target folder of the rule.", "gauge", diskTotal)
+	writeMetric(w, "filecleanup_job_last_run_timestamp_seconds", "Start of the last run of the job, as a Unix timestamp.", "gauge", lastRun)
+	writeMetric(w, "filecleanup_job_last_run_duration_seconds", "Duration of the last run of the job.", "gauge", lastDuration)
+	writeMetric(w, "filecleanup_job_running", "Whether the job is running.", "gauge", running)
+	writeMetric(w, "filecleanup_watcher_queue_length", "Watcher events queued and not yet handled.", "gauge",
+		[]metricSample{{value: float64(len(d.fileEvents))}})
+	deletedFilesTotal.write(w)
+	reclaimedBytesTotal.write(w)
+	errorsTotal.write(w)
+	watcherEventsTotal.write(w)
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestWatcherQueueLength(t *testing.T) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	dir := t.TempDir()
+	if err := watcher.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &daemon{ctx: ctx, watcher: watcher, fileEvents: make(chan fsnotify.Event, fileEventQueueSize)}
+	go d.forwardFileEvents()
+
+	// The events queue up while the daemon goroutine does not handle them
+	for i := range 10 {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.log", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.fileEvents) < 10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	recorder := httptest.NewRecorder()
+	d.serveMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	queued := len(d.fileEvents)
+	if queued < 10 || !strings.Contains(recorder.Body.String(), fmt.Sprintf("filecleanup_watcher_queue_length %d\n", queued)) {
+		t.Errorf("%d events queued, metrics:\n%s", queued, recorder.Body.String())
+	}
+}
@@ -0,0 +1,398 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// notification is the data the body templates of the webhooks are rendered
+// with.
+type notification struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`
+	Rule    string    `json:"rule"`
+	Job     jobKind   `json:"job"`
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+	Result  runResult `json:"result"`
+}
+
+// newNotification describes event, one of job_finished and the events
+// published after it.
+func newNotification(event daemonEvent) notification {
+	host, _ := os.Hostname()
+	n := notification{Event: event.Type, Time: event.Time, Host: host, Rule: event.Rule, Job: event.Job}
+	if event.Result != nil {
+		n.Result = *event.Result
+	}
+	switch event.Type {
+	case eventJobFinished:
+		n.Event = pkg.NotifyRunCompleted
+		n.Title = fmt.Sprintf("%s cleanup of %s finished on %s", n.Job, n.Rule, host)
+		n.Message = fmt.Sprintf("Deleted %d files and freed %s.", n.Result.DeletedFiles, pkg.FormatBytes(n.Result.ReclaimedBytes))
+		if n.Result.Errors > 0 {
+			n.Message += fmt.Sprintf(" %d files could not be deleted.", n.Result.Errors)
+		}
+		if n.Result.Error != "" {
+			n.Message += " The run stopped: " + n.Result.Error
+		}
+	case eventSafetyCapReached:
+		n.Title = fmt.Sprintf("Safety cap reached for %s on %s", n.Rule, host)
+		n.Message = fmt.Sprintf("The %s cleanup stopped after %d files, the max_deletes_per_run of the rule. The next run continues.", n.Job, n.Result.DeletedFiles+n.Result.Errors)
+	case eventDeleteErrors:
+		n.Title = fmt.Sprintf("%d files of %s could not be deleted on %s", n.Result.Errors, n.Rule, host)
+		n.Message = fmt.Sprintf("The %s cleanup failed to delete %d files, the first error was: %s", n.Job, n.Result.Errors, n.Result.FirstError)
+	case eventSizeLimitExceeded:
+		n.Title = fmt.Sprintf("%s is still above its size limit on %s", n.Rule, host)
+		n.Message = fmt.Sprintf("After the size cleanup the folder holds %s of %s allowed.", pkg.FormatBytes(n.Result.FolderBytes), pkg.FormatBytes(n.Result.LimitBytes))
+	}
+	return n
+}
+
+// notifier posts the notifications of the daemon to the webhooks of the
+// configuration.
+type notifier struct {
+	mu       sync.Mutex
+	webhooks []pkg.WebhookConfig
+	wg       sync.WaitGroup
+	// stop ends the subscription of start, which closes stopped once the
+	// queued events are handed to notify. cancel abandons the deliveries.
+	stop    chan struct{}
+	stopped chan struct{}
+	cancel  context.CancelFunc
+}
+
+var notifications = &notifier{}
+
+// configure replaces the webhooks notified from now on.
+func (n *notifier) configure(webhooks []pkg.WebhookConfig) {
+	n.mu.Lock()
+	n.webhooks = webhooks
+	n.mu.Unlock()
+}
+
+// start notifies the webhooks of the events published until wait is called.
+// The deliveries do not use the context of the daemon, so the runs drained on
+// shutdown are still notified.
+func (n *notifier) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	n.stop, n.stopped, n.cancel = make(chan struct{}), make(chan struct{}), cancel
+	ch := events.subscribe(eventJobFinished, eventSafetyCapReached, eventDeleteErrors, eventSizeLimitExceeded)
+	go func() {
+		defer close(n.stopped)
+		for {
+			select {
+			case event := <-ch:
+				n.notify(ctx, newNotification(event))
+			case <-n.stop:
+				events.unsubscribe(ch)
+				for len(ch) > 0 {
+					n.notify(ctx, newNotification(<-ch))
+				}
+				return
+			}
+		}
+	}()
+}
+
+func (n *notifier) notify(ctx context.Context, note notification) {
+	n.mu.Lock()
+	webhooks := n.webhooks
+	n.mu.Unlock()
+	for _, webhook := range webhooks {
+		if !webhook.Wants(note.Event, note.Rule) {
+			continue
+		}
+		if webhook.SkipEmptyRuns && note.Event == pkg.NotifyRunCompleted && note.Result.DeletedFiles == 0 && note.Result.Errors == 0 && note.Result.Error == "" {
+			continue
+		}
+		n.wg.Add(1)
+		go func(webhook pkg.WebhookConfig) {
+			defer n.wg.Done()
+			if _, err := deliver(ctx, webhook, note); err != nil {
+				log.WithField("webhook", webhook.DisplayName()).Errorf("Error sending %s notification: %v", note.Event, err)
+				errorsTotal.add(1, note.Rule, "notify")
+			}
+		}(webhook)
+	}
+}
+
+// wait stops start, notifying the events still queued, and waits up to
+// timeout for the deliveries in progress. The ones still running are
+// abandoned then.
+func (n *notifier) wait(timeout time.Duration) {
+	if n.stop != nil {
+		close(n.stop)
+		<-n.stopped
+		n.stop = nil
+		defer n.cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// permanentError is a failed delivery that is not retried.
+type permanentError struct{ error }
+
+// webhookBackoff is the wait before the first retry of a delivery, doubled
+// for every further one up to a minute.
+var webhookBackoff = time.Second
+
+// deliver posts note to webhook and returns the number of attempts it took.
+// Failed attempts are retried with exponential backoff, except for client
+// errors other than timeouts and rate limits.
+func deliver(ctx context.Context, webhook pkg.WebhookConfig, note notification) (int, error) {
+	tmpl, err := webhook.BodyTemplate()
+	if err != nil {
+		return 0, err
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, note); err != nil {
+		return 0, fmt.Errorf("rendering body: %w", err)
+	}
+	headers, err := webhook.ParsedHeaders()
+	if err != nil {
+		return 0, err
+	}
+	attempts := webhook.MaxAttempts
+	if attempts <= 0 {
+		attempts = pkg.DefaultWebhookAttempts
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if webhook.TimeoutSeconds > 0 {
+		client.Timeout = time.Duration(webhook.TimeoutSeconds) * time.Second
+	}
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := post(ctx, client, webhook.URL, headers, body.Bytes())
+		var permanent permanentError
+		if err == nil || errors.As(err, &permanent) || attempt == attempts {
+			return attempt, err
+		}
+		log.WithField("webhook", webhook.DisplayName()).Debugf("Attempt %d failed, retrying in %s: %v", attempt, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return attempt, err
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+func post(ctx context.Context, client *http.Client, url string, headers [][2]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FileCleanup/"+RootCmd.Version)
+	for _, header := range headers {
+		req.Header.Set(header[0], header[1])
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return permanentError{fmt.Errorf("answered %s", resp.Status)}
+	default:
+		return fmt.Errorf("answered %s", resp.Status)
+	}
+}
+
+func notifyCmd() *cobra.Command {
+	var notifyCmd = &cobra.Command{
+		Use:   "notify",
+		Short: "Test the webhook and email notifications",
+	}
+	notifyCmd.AddCommand(notifyTestCmd())
+	notifyCmd.AddCommand(notifyListenCmd())
+	notifyCmd.AddCommand(notifyEmailCmd())
+	notifyCmd.AddCommand(notifyListenSMTPCmd())
+	return notifyCmd
+}
+
+func notifyTestCmd() *cobra.Command {
+	var event, rule string
+	var adHoc pkg.WebhookConfig
+	var notifyTestCmd = &cobra.Command{
+		Use:   "test [WEBHOOK...]",
+		Short: "Send a sample notification to the webhooks",
+		Long: "Send a sample notification to the webhooks of the configuration, or the named ones, ignoring their event and rule filters. " +
+			"With --url it is sent to that URL instead.",
+		Example: `fileCleanup notify test
+fileCleanup notify test --url http://127.0.0.1:8099 --format slack --event size_limit_exceeded`,
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			config, err := loadRules()
+			if err != nil {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			names := make([]string, 0, len(config.Webhooks))
+			for _, webhook := range config.Webhooks {
+				names = append(names, webhook.DisplayName())
+			}
+			return names, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !slices.Contains(pkg.NotifyEvents, event) {
+				return fmt.Errorf("unknown event %q, expected one of %s", event, strings.Join(pkg.NotifyEvents, ", "))
+			}
+			webhooks := AppConfig.Webhooks
+			if adHoc.URL != "" {
+				webhooks = []pkg.WebhookConfig{adHoc}
+			}
+			if len(args) > 0 {
+				var named []pkg.WebhookConfig
+				for _, name := range args {
+					found := false
+					for _, webhook := range webhooks {
+						if webhook.DisplayName() == name {
+							named, found = append(named, webhook), true
+						}
+					}
+					if !found {
+						return fmt.Errorf("no webhook named %s", name)
+					}
+				}
+				webhooks = named
+			}
+			if len(webhooks) == 0 {
+				return fmt.Errorf("no webhooks in %s, add one to webhooks or pass --url", ConfigFilePath)
+			}
+			note := newNotification(sampleEvent(event, rule))
+			failed := 0
+			for _, webhook := range webhooks {
+				attempts, err := deliver(cmd.Context(), webhook, note)
+				tries := "on the first attempt"
+				if attempts > 1 {
+					tries = fmt.Sprintf("after %d attempts", attempts)
+				}
+				if err != nil {
+					failed++
+					fmt.Fprintf(cmd.OutOrStdout(), "%s: failed %s: %v\n", webhook.DisplayName(), tries, err)
+					continue
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: sent %s notification %s\n", webhook.DisplayName(), note.Event, tries)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d webhooks failed", failed, len(webhooks))
+			}
+			return nil
+		},
+	}
+	notifyTestCmd.Flags().StringVar(&event, "event", pkg.NotifyRunCompleted, "Event of the sample notification")
+	notifyTestCmd.Flags().StringVar(&rule, "rule", "example", "Rule of the sample notification")
+	notifyTestCmd.Flags().StringVar(&adHoc.URL, "url", "", "Send to this URL instead of the configured webhooks")
+	notifyTestCmd.Flags().StringVar(&adHoc.Format, "format", "", "Body format for --url: json, slack or teams")
+	notifyTestCmd.Flags().IntVar(&adHoc.MaxAttempts, "max-attempts", 1, "Attempts for --url")
+	_ = notifyTestCmd.RegisterFlagCompletionFunc("event", cobra.FixedCompletions(pkg.NotifyEvents, cobra.ShellCompDirectiveNoFileComp))
+	_ = notifyTestCmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions([]string{pkg.WebhookFormatJSON, pkg.WebhookFormatSlack, pkg.WebhookFormatTeams}, cobra.ShellCompDirectiveNoFileComp))
+	_ = notifyTestCmd.RegisterFlagCompletionFunc("rule", completeRuleNames)
+	return notifyTestCmd
+}
+
+// sampleEvent returns an event of type for rule with made up totals.
+func sampleEvent(event, rule string) daemonEvent {
+	result := runResult{DeletedFiles: 212, ReclaimedBytes: 1503238553}
+	sample := daemonEvent{Time: time.Now(), Type: event, Rule: rule, Job: retentionJob, Result: &result}
+	switch event {
+	case pkg.NotifyRunCompleted:
+		sample.Type = eventJobFinished
+	case eventSafetyCapReached:
+		result.Capped = true
+	case eventDeleteErrors:
+		result.Errors, result.FirstError = 3, "remove /var/log/app/app.log.1: permission denied"
+	case eventSizeLimitExceeded:
+		sample.Job = sizeJob
+		result.FolderBytes, result.LimitBytes = 12<<30, 10<<30
+	}
+	return sample
+}
+
+func notifyListenCmd() *cobra.Command {
+	var address string
+	var failFirst int
+	var notifyListenCmd = &cobra.Command{
+		Use:   "listen",
+		Short: "Print the notifications posted to a local address",
+		Long: "Stand in for a webhook receiver: print every request posted to the address until interrupted. " +
+			"--fail-first answers the first requests with 503 to try out the retries.",
+		Example: `fileCleanup notify listen --address 127.0.0.1:8099 &
+fileCleanup notify test --url http://127.0.0.1:8099 --format teams`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listener, err := net.Listen("tcp", address)
+			if err != nil {
+				return err
+			}
+			var mu sync.Mutex
+			received := 0
+			server := &http.Server{
+				ReadHeaderTimeout: 10 * time.Second,
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					body, _ := io.ReadAll(io.LimitReader(req.Body, 1<<20))
+					mu.Lock()
+					defer mu.Unlock()
+					received++
+					status := http.StatusOK
+					if received <= failFirst {
+						status = http.StatusServiceUnavailable
+					}
+					out := cmd.OutOrStdout()
+					fmt.Fprintf(out, "%s %s %s -> %d\n", time.Now().Format(time.DateTime), req.Method, req.URL, status)
+					for _, name := range []string{"Content-Type", "User-Agent", "Authorization"} {
+						if value := req.Header.Get(name); value != "" {
+							fmt.Fprintf(out, "%s: %s\n", name, value)
+						}
+					}
+					fmt.Fprintf(out, "%s\n\n", bytes.TrimSpace(body))
+					w.WriteHeader(status)
+				}),
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Listening on http://%s\n", listener.Addr())
+			go func() {
+				<-cmd.Context().Done()
+				server.Close()
+			}()
+			if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+	notifyListenCmd.Flags().StringVar(&address, "address", "127.0.0.1:8099", "Address to listen on")
+	notifyListenCmd.Flags().IntVar(&failFirst, "fail-first", 0, "Answer this many requests with 503 first")
+	return notifyListenCmd
+}
+
+func init() {
+	RootCmd.AddCommand(notifyCmd())
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer records the requests posted to it and answers them with the
+// status returned by answer for the attempt.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	times    []time.Time
+	bodies   [][]byte
+	requests []*http.Request
+}
+
+func newWebhookServer(t *testing.T, answer func(attempt int, w http.ResponseWriter, req *http.Request)) *webhookServer {
+	t.Helper()
+	s := &webhookServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		s.mu.Lock()
+		s.times = append(s.times, time.Now())
+		s.bodies = append(s.bodies, body)
+		s.requests = append(s.requests, req)
+		attempt := len(s.times)
+		s.mu.Unlock()
+		answer(attempt, w, req)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) received() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.times)
+}
+
+func fastBackoff(t *testing.T) {
+	t.Helper()
+	previous := webhookBackoff
+	webhookBackoff = 20 * time.Millisecond
+	t.Cleanup(func() { webhookBackoff = previous })
+}
+
+func TestDeliverRetriesServerErrorsWithBackoff(t *testing.T) {
+	fastBackoff(t)
+	server := newWebhookServer(t, func(attempt int, w http.ResponseWriter, req *http.Request) {
+		if attempt < 4 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	webhook := pkg.WebhookConfig{URL: server.URL, MaxAttempts: 5}
+	attempts, err := deliver(context.Background(), webhook, newNotification(sampleEvent(pkg.NotifyRunCompleted, "logs")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 4 || server.received() != 4 {
+		t.Fatalf("took %d attempts and %d requests, want 4", attempts, server.received())
+	}
+	for i := 1; i < len(server.times); i++ {
+		gap := server.times[i].Sub(server.times[i-1])
+		if want := webhookBackoff << (i - 1); gap < want {
+			t.Errorf("retry %d after %s, want at least %s", i, gap, want)
+		}
+	}
+}
+
+func TestDeliverGivesUpAfterMaxAttempts(t *testing.T) {
+	fastBackoff(t)
+	server := newWebhookServer(t, func(attempt int, w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	webhook := pkg.WebhookConfig{URL: server.URL, MaxAttempts: 3}
+	attempts, err := deliver(context.Background(), webhook, newNotification(sampleEvent(pkg.NotifyRunCompleted, "logs")))
+	if err == nil || attempts != 3 || server.received() != 3 {
+		t.Fatalf("got %v after %d attempts and %d requests, want an error after 3", err, attempts, server.received())
+	}
+}
+
+func TestDeliverRetriesTimeouts(t *testing.T) {
+	fastBackoff(t)
+	server := newWebhookServer(t, func(attempt int, w http.ResponseWriter, req *http.Request) {
+		if attempt == 1 {
+			select {
+			case <-req.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	})
+
+	webhook := pkg.WebhookConfig{URL: server.URL, MaxAttempts: 2, TimeoutSeconds: 1}
+	attempts, err := deliver(context.Background(), webhook, newNotification(sampleEvent(pkg.NotifyRunCompleted, "logs")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("took %d attempts, want 2", attempts)
+	}
+}
+
+func TestDeliverDoesNotRetryClientErrors(t *testing.T) {
+	fastBackoff(t)
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound} {
+		server := newWebhookServer(t, func(attempt int, w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(status)
+		})
+		webhook := pkg.WebhookConfig{URL: server.URL, MaxAttempts: 4}
+		attempts, err := deliver(context.Background(), webhook, newNotification(sampleEvent(pkg.NotifyRunCompleted, "logs")))
+		if err == nil || attempts != 1 || server.received() != 1 {
+			t.Errorf("status %d: got %v after %d attempts, want an error after 1", status, err, attempts)
+		}
+	}
+
+	// Rate limits are retried
+	server := newWebhookServer(t, func(attempt int, w http.ResponseWriter, req *http.Request) {
+		if attempt == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	webhook := pkg.WebhookConfig{URL: server.URL, MaxAttempts: 4}
+	if attempts, err := deliver(context.Background(), webhook, newNotification(sampleEvent(pkg.NotifyRunCompleted, "logs"))); err != nil || attempts != 2 {
+		t.Errorf("status 429: got %v after %d attempts, want success after 2", err, attempts)
+	}
+}
+
+func TestDeliverFormats(t *testing.T) {
+	note := newNotification(sampleEvent(pkg.NotifySizeLimitExceeded, "logs"))
+	tests := []struct {
+		format string
+		check  func(t *testing.T, body map[string]any)
+	}{
+		{pkg.WebhookFormatJSON, func(t *testing.T, body map[string]any) {
+			if body["event"] != pkg.NotifySizeLimitExceeded || body["rule"] != "logs" || body["title"] != note.Title {
+				t.Errorf("json body %v", body)
+			}
+			result, _ := body["result"].(map[string]any)
+			if result["folder_bytes"] != float64(12<<30) {
+				t.Errorf("json result %v", result)
+			}
+		}},
+		{pkg.WebhookFormatSlack, func(t *testing.T, body map[string]any) {
+			if want := "*" + note.Title + "*\n" + note.Message; body["text"] != want {
+				t.Errorf("slack text %q, want %q", body["text"], want)
+			}
+		}},
+		{pkg.WebhookFormatTeams, func(t *testing.T, body map[string]any) {
+			if body["@type"] != "MessageCard" || body["title"] != note.Title || body["summary"] != note.Title || body["text"] != note.Message {
+				t.Errorf("teams body %v", body)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			server := newWebhookServer(t, func(attempt int, w http.ResponseWriter, req *http.Request) {})
+			webhook := pkg.WebhookConfig{URL: server.URL, Format: test.format, Headers: []string{"Authorization: Bearer secret"}}
+			if _, err := deliver(context.Background(), webhook, note); err != nil {
+				t.Fatal(err)
+			}
+			req := server.requests[0]
+			if req.Header.Get("Content-Type") != "application/json" || req.Header.Get("Authorization") != "Bearer secret" {
+				t.Errorf("headers %v", req.Header)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(server.bodies[0], &body); err != nil {
+				t.Fatalf("body %s is not JSON: %v", server.bodies[0], err)
+			}
+			test.check(t, body)
+		})
+	}
+}
+
+func TestNotifyFiltersEvents(t *testing.T) {
+	server := newWebhookServer(t, func(attempt int, w http.ResponseWriter, req *http.Request) {})
+	webhooks := []pkg.WebhookConfig{
+		{Name: "all", URL: server.URL + "/all"},
+		{Name: "errors", URL: server.URL + "/errors", Events: []string{pkg.NotifyDeleteErrors}},
+		{Name: "logs", URL: server.URL + "/logs", Rules: []string{"logs"}},
+		{Name: "busy", URL: server.URL + "/busy", SkipEmptyRuns: true},
+	}
+	n := &notifier{}
+	n.configure(webhooks)
+
+	empty := sampleEvent(pkg.NotifyRunCompleted, "cache")
+	empty.Result.DeletedFiles, empty.Result.ReclaimedBytes = 0, 0
+	n.notify(context.Background(), newNotification(empty))
+	n.notify(context.Background(), newNotification(sampleEvent(pkg.NotifyDeleteErrors, "logs")))
+	n.notify(context.Background(), newNotification(sampleEvent(pkg.NotifyRunCompleted, "logs")))
+	n.wait(10 * time.Second)
+
+	got := make(map[string]int)
+	for _, req := range server.requests {
+		got[req.URL.Path]++
+	}
+	want := map[string]int{"/all": 3, "/errors": 1, "/logs": 2, "/busy": 2}
+	for path, count := range want {
+		if got[path] != count {
+			t.Errorf("%s notified %d times, want %d", path, got[path], count)
+		}
+	}
+}
+
+func TestNotifierNotifiesQueuedEventsOnStop(t *testing.T) {
+	server := newWebhookServer(t, func(attempt int, w http.ResponseWriter, req *http.Request) {})
+	n := &notifier{}
+	n.configure([]pkg.WebhookConfig{{URL: server.URL}})
+	n.start()
+
+	for _, rule := range []string{"logs", "cache", "tmp"} {
+		events.publish(sampleEvent(pkg.NotifyRunCompleted, rule))
+	}
+	events.publish(sampleEvent(pkg.NotifyDeleteErrors, "logs"))
+	n.wait(10 * time.Second)
+
+	if got := server.received(); got != 4 {
+		t.Errorf("%d notifications sent, want 4", got)
+	}
+}
+
+func TestNotifierWaitAbandonsDeliveries(t *testing.T) {
+	abandoned := make(chan struct{})
+	server := newWebhookServer(t, func(attempt int, w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+		close(abandoned)
+	})
+	n := &notifier{}
+	n.configure([]pkg.WebhookConfig{{URL: server.URL, TimeoutSeconds: 60}})
+	n.start()
+
+	events.publish(sampleEvent(pkg.NotifyRunCompleted, "logs"))
+	start := time.Now()
+	n.wait(200 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("wait returned after %s", elapsed)
+	}
+	select {
+	case <-abandoned:
+	case <-time.After(5 * time.Second):
+		t.Error("delivery was not abandoned after the timeout")
+	}
+}
@@ -3,6 +3,7 @@ package benchmark
 import (
 	"FileCleanup/cmd"
 	_const "FileCleanup/const"
+	"FileCleanup/pkg"
 	"errors"
 	"fmt"
 	"image/color"
@@ -262,7 +263,9 @@ func benchmarkCmd() *cobra.Command {
 			log.Println("Total Benchmark Time:", time.Since(totalTime).Seconds())
 		},
 		Args: func(cmd *cobra.Command, args []string) error {
-			InitFilesPath()
+			if err := InitFilesPath(); err != nil {
+				return err
+			}
 			FileSizeMB = FileSizeMB * _const.MB
 			return nil
 		},
@@ -276,12 +279,7 @@ func init() {
 }
 
 func compareFlags(cmd *cobra.Command) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatalln(err)
-		return
-	}
-	cmd.Flags().StringVarP(&TestFilesPath, "path", "p", filepath.Join(home, ".fileCleanup", "benchmarkFiles"), fmt.Sprintf("Test files path. default: %s", filepath.Join(home, ".fileCleanup", "benchmarkFiles")))
+	cmd.Flags().StringVarP(&TestFilesPath, "path", "p", "", "Test files path, defaults to benchmarkFiles in the configuration directory")
 	cmd.Flags().Int64VarP(&FileSizeMB, "size", "s", 10, "File size in MB")
 	// 4 * 1024 * 1024 = 4194304 bytes (4MB)
 	cmd.Flags().Int64VarP(&BufferSizeByte, "buffer", "b", 4194304, "Buffer size in bytes")
@@ -289,6 +287,7 @@ func compareFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVarP(&Name, "name", "n", "", "Disk Name")
 	cmd.Flags().BoolVarP(&Parallel, "parallel", "m", false, "Run in parallel (true) or sequential (false)")
 	cmd.Flags().StringVarP(&Mode, "mode", "M", "all", "Benchmark mode: 'all', 'write', 'read', 'delete'")
+	_ = cmd.RegisterFlagCompletionFunc("mode", cobra.FixedCompletions([]string{"all", "write", "read", "delete"}, cobra.ShellCompDirectiveNoFileComp))
 }
 
 func measureDeleteBenchmark(filePath string) (float64, float64, float64, error) {
@@ -449,11 +448,18 @@ func randomColor() color.RGBA {
 	return color.RGBA{R: uint8(randGen.Intn(256)), G: uint8(randGen.Intn(256)), B: uint8(randGen.Intn(256)), A: 255}
 }
 
-func InitFilesPath() {
-	if _, err := os.Stat(TestFilesPath); errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(TestFilesPath, 0777)
+// InitFilesPath creates the test files folder, benchmarkFiles in the
+// configuration directory unless --path is given.
+func InitFilesPath() error {
+	if TestFilesPath == "" {
+		dir, err := pkg.ConfigDir()
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+		TestFilesPath = filepath.Join(dir, "benchmarkFiles")
 	}
+	if _, err := os.Stat(TestFilesPath); errors.Is(err, os.ErrNotExist) {
+		return os.MkdirAll(TestFilesPath, 0777)
+	}
+	return nil
 }
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// reportRow sums up the records of a rule on a day.
+type reportRow struct {
+	Day      string `json:"day"`
+	Rule     string `json:"rule"`
+	Deleted  int64  `json:"deleted_files"`
+	Bytes    int64  `json:"reclaimed_bytes"`
+	NotFound int64  `json:"not_found"`
+	Errors   int64  `json:"errors"`
+}
+
+// reportSummary groups audit records by local day and rule as they are read,
+// so only one row per rule and day is kept in memory.
+type reportSummary map[[2]string]*reportRow
+
+// add counts record in the row of its rule and day.
+func (s reportSummary) add(record pkg.AuditRecord) {
+	key := [2]string{record.Time.Local().Format(time.DateOnly), record.Rule}
+	row, ok := s[key]
+	if !ok {
+		row = &reportRow{Day: key[0], Rule: key[1]}
+		s[key] = row
+	}
+	switch record.Result {
+	case pkg.AuditResultDeleted:
+		row.Deleted++
+		row.Bytes += record.Size
+	case pkg.AuditResultNotFound:
+		row.NotFound++
+	case pkg.AuditResultError:
+		row.Errors++
+	}
+}
+
+// rows returns the rows sorted by day then rule.
+func (s reportSummary) rows() []reportRow {
+	result := make([]reportRow, 0, len(s))
+	for _, row := range s {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Day != result[j].Day {
+			return result[i].Day < result[j].Day
+		}
+		return result[i].Rule < result[j].Rule
+	})
+	return result
+}
+
+func reportCmd() *cobra.Command {
+	var query auditQuery
+	var reportCmd = &cobra.Command{
+		Use:   "report [...FLAGS]",
+		Short: "Summarize the audit log per rule and day",
+		Long: "Sum up the deleted files, reclaimed bytes, files already gone and errors of the audit log for every rule and day. " +
+			"Days are in the local time zone.",
+		Example: `fileCleanup report --since 7d
+fileCleanup report --since 2026-01-01 --until 2026-02-01 -o csv > january.csv`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter, err := query.filter(time.Now())
+			if err != nil {
+				return err
+			}
+			summary := make(reportSummary)
+			if err := query.read(cmd, filter, summary.add); err != nil {
+				return err
+			}
+			return writeReport(cmd.OutOrStdout(), query.output, summary.rows())
+		},
+	}
+	query.addFlags(reportCmd)
+	return reportCmd
+}
+
+func writeReport(w io.Writer, format string, rows []reportRow) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	case "csv":
+		out := csv.NewWriter(w)
+		out.Write([]string{"day", "rule", "deleted_files", "reclaimed_bytes", "not_found", "errors"})
+		for _, row := range rows {
+			out.Write([]string{row.Day, row.Rule, strconv.FormatInt(row.Deleted, 10), strconv.FormatInt(row.Bytes, 10),
+				strconv.FormatInt(row.NotFound, 10), strconv.FormatInt(row.Errors, 10)})
+		}
+		out.Flush()
+		return out.Error()
+	default:
+		var total reportRow
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "DAY\tRULE\tDELETED\tRECLAIMED\tNOT FOUND\tERRORS\t")
+		for _, row := range rows {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%d\t\n", row.Day, row.Rule, row.Deleted, pkg.FormatBytes(row.Bytes), row.NotFound, row.Errors)
+			total.Deleted += row.Deleted
+			total.Bytes += row.Bytes
+			total.NotFound += row.NotFound
+			total.Errors += row.Errors
+		}
+		fmt.Fprintf(tw, "TOTAL\t\t%d\t%s\t%d\t%d\t\n", total.Deleted, pkg.FormatBytes(total.Bytes), total.NotFound, total.Errors)
+		return tw.Flush()
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(reportCmd())
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReportSummaryPerDay(t *testing.T) {
+	// Days are local, a record just before midnight counts for that day
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 0, 0, time.Local)
+	}
+	records := []pkg.AuditRecord{
+		{Time: at(10, 23, 59), Rule: "uploads", Result: pkg.AuditResultDeleted, Size: 100},
+		{Time: at(10, 8, 0), Rule: "uploads", Result: pkg.AuditResultDeleted, Size: 50},
+		{Time: at(11, 0, 0), Rule: "uploads", Result: pkg.AuditResultDeleted, Size: 7},
+		{Time: at(10, 9, 0), Rule: "logs", Result: pkg.AuditResultNotFound, Size: 1000},
+		{Time: at(10, 9, 0), Rule: "logs", Result: pkg.AuditResultError, Size: 1000},
+		{Time: at(10, 9, 1), Rule: "logs", Result: pkg.AuditResultDeleted, Size: 1},
+	}
+	summary := make(reportSummary)
+	for _, record := range records {
+		summary.add(record)
+	}
+	want := []reportRow{
+		{Day: "2026-03-10", Rule: "logs", Deleted: 1, Bytes: 1, NotFound: 1, Errors: 1},
+		{Day: "2026-03-10", Rule: "uploads", Deleted: 2, Bytes: 150},
+		{Day: "2026-03-11", Rule: "uploads", Deleted: 1, Bytes: 7},
+	}
+	if rows := summary.rows(); !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %+v, want %+v", rows, want)
+	}
+
+	var csv bytes.Buffer
+	if err := writeReport(&csv, "csv", want); err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := "day,rule,deleted_files,reclaimed_bytes,not_found,errors\n2026-03-10,logs,1,1,1,1\n2026-03-10,uploads,2,150,0,0\n2026-03-11,uploads,1,7,0,0\n"
+	if csv.String() != wantCSV {
+		t.Errorf("csv %q, want %q", csv.String(), wantCSV)
+	}
+}
+
+func TestAuditQueryFilter(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		since string
+		want  time.Time
+		ok    bool
+	}{
+		{"", time.Time{}, true},
+		{"7d", now.Add(-7 * 24 * time.Hour), true},
+		{"36h", now.Add(-36 * time.Hour), true},
+		{"2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local), true},
+		{"2026-03-01 08:30", time.Date(2026, 3, 1, 8, 30, 0, 0, time.Local), true},
+		{"2026-03-01T08:30:00Z", time.Date(2026, 3, 1, 8, 30, 0, 0, time.UTC), true},
+		{"yesterday", time.Time{}, false},
+	}
+	for _, test := range tests {
+		query := auditQuery{since: test.since, rules: []string{"uploads"}, output: "table"}
+		filter, err := query.filter(now)
+		if test.ok && (err != nil || !filter.Since.Equal(test.want) || !reflect.DeepEqual(filter.Rules, []string{"uploads"})) {
+			t.Errorf("--since %s: filter %+v, %v, want since %s", test.since, filter, err, test.want)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("--since %s: want an error", test.since)
+		}
+	}
+
+	if _, err := (&auditQuery{output: "xml"}).filter(now); err == nil {
+		t.Error("expected an error for the xml output format")
+	}
+}
@@ -0,0 +1,366 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+func ruleCmd() *cobra.Command {
+	var ruleCmd = &cobra.Command{
+		Use:   "rule",
+		Short: "List and change the cleanup rules of the configuration file",
+		Long: "List and change the cleanup rules of the configuration file. Rules are named by their name or target folder. " +
+			"Changes are written to the file the rule comes from and are only saved when the resulting configuration is valid. " +
+			"JSON and YAML files keep their key order and comments, TOML and HCL files are rewritten without comments.",
+	}
+	ruleCmd.AddCommand(ruleListCmd())
+	ruleCmd.AddCommand(ruleShowCmd())
+	ruleCmd.AddCommand(ruleAddCmd())
+	ruleCmd.AddCommand(ruleEditCmd())
+	ruleCmd.AddCommand(ruleRemoveCmd())
+	return ruleCmd
+}
+
+// findRule returns the rule of config named name, by its name or its target
+// folder.
+func findRule(config pkg.Config, name string) (pkg.DeleteConfig, error) {
+	for _, rule := range config.DeleteConfig {
+		if rule.Name == name {
+			return rule, nil
+		}
+	}
+	for _, rule := range config.DeleteConfig {
+		if rule.TargetFolder != "" && filepath.Clean(rule.TargetFolder) == filepath.Clean(name) {
+			return rule, nil
+		}
+	}
+	return pkg.DeleteConfig{}, fmt.Errorf("no rule named %s in %s", name, ConfigFilePath)
+}
+
+// completeRuleNames completes the NAME argument of the rule commands with
+// the names of the configured rules.
+func completeRuleNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	config, err := loadRules()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := make([]string, 0, len(config.DeleteConfig))
+	for _, rule := range config.DeleteConfig {
+		name := rule.DisplayName()
+		if rule.Description != "" {
+			name += "\t" + rule.Description
+		}
+		names = append(names, name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeRuleKeys completes KEY=VALUE arguments with the keys of a rule.
+func completeRuleKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if strings.Contains(toComplete, "=") {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	keys := pkg.RuleKeys()
+	for i := range keys {
+		keys[i] += "="
+	}
+	return keys, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// loadRules loads the configuration the rule commands work on.
+func loadRules() (pkg.Config, error) {
+	return pkg.LoadConfig(ConfigFilePath)
+}
+
+func ruleListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Short:   "List the rules",
+		Aliases: []string{"ls"},
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadRules()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tENABLED\tTARGET FOLDER\tRETENTION\tMAX SIZE\tSOURCE")
+			for _, rule := range config.DeleteConfig {
+				fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\t%s\n", rule.DisplayName(), rule.Enabled, rule.TargetFolder,
+					formatRetention(rule.RetentionDays), formatMaxSize(rule), rule.Source)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func ruleShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "show NAME",
+		Short:             "Show a rule with the settings it inherits",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRuleNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadRules()
+			if err != nil {
+				return err
+			}
+			rule, err := findRule(config, args[0])
+			if err != nil {
+				return err
+			}
+			data, err := yaml.Marshal(rule)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "# %s, delete_config[%d]\n%s", rule.Source, rule.SourceIndex, data)
+			return nil
+		},
+	}
+}
+
+func ruleAddCmd() *cobra.Command {
+	var (
+		name string
+		file string
+	)
+	var addCmd = &cobra.Command{
+		Use:   "add TARGET_FOLDER [KEY=VALUE...]",
+		Short: "Add a rule",
+		Long: "Add a rule for TARGET_FOLDER, setting the given keys. Without a defaults block in the configuration, " +
+			"the retention, interval and size keys not given are set to the values of config init.",
+		Example: `fileCleanup rule add /var/log/app --name app-logs retention_days=2w max_folder_size_mb=5GiB`,
+		Args:    cobra.MinimumNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return nil, cobra.ShellCompDirectiveFilterDirs
+			}
+			return completeRuleKeys(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadRules()
+			if err != nil {
+				return err
+			}
+			values, err := parseRuleValues(args[1:])
+			if err != nil {
+				return err
+			}
+			values = append(values, pkg.RuleValue{Key: "target_folder", Value: args[0]})
+			if name != "" {
+				values = append(values, pkg.RuleValue{Key: "name", Value: name})
+			}
+			if config.Defaults == nil {
+				values = withExampleValues(values)
+			}
+
+			if file == "" {
+				file = ConfigFilePath
+			}
+			created, err := createConfigFile(file)
+			if err != nil {
+				return err
+			}
+			doc, err := pkg.OpenConfigDocument(file)
+			if err == nil {
+				err = doc.AddRule(values)
+			}
+			if err == nil {
+				err = saveDocument(cmd, doc)
+			}
+			if err != nil {
+				if created {
+					os.Remove(file)
+				}
+				return err
+			}
+
+			config, err = loadRules()
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				name = args[0]
+			}
+			if rule, err := findRule(config, name); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s is not included by %s, the rule has no effect\n", file, ConfigFilePath)
+			} else if _, err := os.Stat(rule.TargetFolder); errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: target folder %s does not exist, the rule deletes nothing until it is created\n", rule.TargetFolder)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Added rule", name, "to", file)
+			return nil
+		},
+	}
+	addCmd.Flags().StringVar(&name, "name", "", "Name of the rule")
+	addCmd.Flags().StringVar(&file, "file", "", "File to add the rule to, such as a conf.d file, defaults to the --config path")
+	_ = addCmd.MarkFlagFilename("file", "json", "yaml", "yml", "toml", "hcl")
+	return addCmd
+}
+
+func ruleEditCmd() *cobra.Command {
+	var unset []string
+	var editCmd = &cobra.Command{
+		Use:     "edit NAME KEY=VALUE...",
+		Short:   "Change the settings of a rule",
+		Example: `fileCleanup rule edit app-logs enabled=false retention_days=1w`,
+		Args:    cobra.MinimumNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return completeRuleNames(cmd, args, toComplete)
+			}
+			return completeRuleKeys(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			values, err := parseRuleValues(args[1:])
+			if err != nil {
+				return err
+			}
+			for _, key := range unset {
+				if err := pkg.CheckRuleKey(key); err != nil {
+					return err
+				}
+			}
+			if len(values) == 0 && len(unset) == 0 {
+				return errors.New("nothing to change, give KEY=VALUE settings or --unset KEY")
+			}
+			return editRule(cmd, args[0], func(doc *pkg.ConfigDocument, index int) error {
+				return doc.EditRule(index, values, unset)
+			}, "Updated rule")
+		},
+	}
+	editCmd.Flags().StringSliceVar(&unset, "unset", nil, "Remove a key from the rule so it is inherited from the defaults again")
+	_ = editCmd.RegisterFlagCompletionFunc("unset", cobra.FixedCompletions(pkg.RuleKeys(), cobra.ShellCompDirectiveNoFileComp))
+	return editCmd
+}
+
+func ruleRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "remove NAME",
+		Short:             "Remove a rule",
+		Aliases:           []string{"rm"},
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRuleNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return editRule(cmd, args[0], func(doc *pkg.ConfigDocument, index int) error {
+				return doc.RemoveRule(index)
+			}, "Removed rule")
+		},
+	}
+}
+
+// editRule applies change to the rule named name in the file it comes from.
+func editRule(cmd *cobra.Command, name string, change func(doc *pkg.ConfigDocument, index int) error, done string) error {
+	config, err := loadRules()
+	if err != nil {
+		return err
+	}
+	rule, err := findRule(config, name)
+	if err != nil {
+		return err
+	}
+	doc, err := pkg.OpenConfigDocument(rule.Source)
+	if err != nil {
+		return err
+	}
+	if err := change(doc, rule.SourceIndex); err != nil {
+		return err
+	}
+	if err := saveDocument(cmd, doc); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), done, name, "in", rule.Source)
+	return nil
+}
+
+func saveDocument(cmd *cobra.Command, doc *pkg.ConfigDocument) error {
+	if !doc.PreservesComments() {
+		fmt.Fprintf(cmd.ErrOrStderr(), "warning: comments of %s are not preserved\n", doc.Path)
+	}
+	return doc.Save(ConfigFilePath)
+}
+
+// createConfigFile creates an empty configuration file at path when there is
+// none, reporting whether it did.
+func createConfigFile(path string) (bool, error) {
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	format, err := pkg.ConfigFormat(path)
+	if err != nil {
+		return false, err
+	}
+	content := ""
+	if format == pkg.FormatJSON {
+		content = "{}\n"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(path, []byte(content), 0o644)
+}
+
+func parseRuleValues(args []string) ([]pkg.RuleValue, error) {
+	values := make([]pkg.RuleValue, 0, len(args))
+	for _, arg := range args {
+		value, err := pkg.ParseRuleValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// withExampleValues adds the settings of config init a rule needs and values
+// does not set.
+func withExampleValues(values []pkg.RuleValue) []pkg.RuleValue {
+	settings := defaultInitSettings()
+	examples := []pkg.RuleValue{
+		{Key: "retention_days", Value: settings.retention},
+		{Key: "delete_interval_seconds", Value: settings.deleteInterval},
+		{Key: "max_folder_size_mb", Value: settings.maxSize},
+		{Key: "check_size_interval_secs", Value: settings.checkInterval},
+	}
+	for _, example := range examples {
+		set := false
+		for _, value := range values {
+			set = set || value.Key == example.Key
+		}
+		if !set {
+			values = append(values, example)
+		}
+	}
+	return values
+}
+
+// formatRetention writes a retention in days as the shortest duration.
+func formatRetention(days float64) string {
+	if days == float64(int64(days)) {
+		return strconv.FormatInt(int64(days), 10) + "d"
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(time.Duration(days*float64(24*time.Hour)).String(), "0s"), "0m")
+}
+
+func formatMaxSize(rule pkg.DeleteConfig) string {
+	if rule.MaxFolderPercentEnabled {
+		return fmt.Sprintf("%d%%", rule.MaxFolderSizePercent)
+	}
+	return fmt.Sprintf("%d MB", rule.MaxFolderSizeMB)
+}
+
+func init() {
+	RootCmd.AddCommand(ruleCmd())
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultScanConcurrency is used when a rule does not set scan_concurrency.
+	defaultScanConcurrency = 8
+	// scanProgressInterval is how often a running scan reports its progress.
+	scanProgressInterval = 10 * time.Second
+)
+
+// visitDirFunc handles a single directory and returns the subdirectories that
+// should be scanned next.
+type visitDirFunc func(dir string) ([]string, error)
+
+// folderScanner walks a directory tree with a bounded pool of workers. Every
+// worker lists one directory at a time, so the scan is limited by I/O rather
+// than by a single goroutine.
+type folderScanner struct {
+	concurrency int
+	index       *FileIndex
+	visit       visitDirFunc
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []string
+	pending int
+
+	dirs  atomic.Int64
+	files atomic.Int64
+}
+
+func newFolderScanner(concurrency int, index *FileIndex, visit visitDirFunc) *folderScanner {
+	if concurrency <= 0 {
+		concurrency = defaultScanConcurrency
+	}
+	s := &folderScanner{
+		concurrency: concurrency,
+		index:       index,
+		visit:       visit,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Scan visits root and every directory below it. An error on root aborts the
+// scan, errors on nested directories are logged and the directory is skipped.
+func (s *folderScanner) Scan(root string) error {
+	subdirs, err := s.visit(root)
+	if err != nil {
+		return err
+	}
+	s.dirs.Add(1)
+	s.push(subdirs...)
+
+	done := make(chan struct{})
+	go s.reportProgress(root, done)
+
+	var wg sync.WaitGroup
+	for range s.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work()
+		}()
+	}
+	wg.Wait()
+	close(done)
+	return nil
+}
+
+func (s *folderScanner) work() {
+	for {
+		dir, ok := s.pop()
+		if !ok {
+			return
+		}
+		subdirs, err := s.visit(dir)
+		if err != nil {
+			log.Warningln("Skipping folder:", err)
+		} else {
+			s.dirs.Add(1)
+		}
+		s.push(subdirs...)
+		s.finish()
+	}
+}
+
+func (s *folderScanner) push(dirs ...string) {
+	if len(dirs) == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.queue = append(s.queue, dirs...)
+	s.pending += len(dirs)
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// pop blocks until a directory is queued or the scan is complete.
+func (s *folderScanner) pop() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 && s.pending > 0 {
+		s.cond.Wait()
+	}
+	if len(s.queue) == 0 {
+		return "", false
+	}
+	dir := s.queue[len(s.queue)-1]
+	s.queue = s.queue[:len(s.queue)-1]
+	return dir, true
+}
+
+// finish marks a popped directory as done and wakes the workers once the
+// whole tree has been visited.
+func (s *folderScanner) finish() {
+	s.mu.Lock()
+	s.pending--
+	last := s.pending == 0
+	s.mu.Unlock()
+	if last {
+		s.cond.Broadcast()
+	}
+}
+
+func (s *folderScanner) reportProgress(root string, done <-chan struct{}) {
+	ticker := time.NewTicker(scanProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			queued := len(s.queue)
+			s.mu.Unlock()
+			log.Infof("Scanning %s - %d folders, %d files indexed, %d folders queued", root, s.dirs.Load(), s.files.Load(), queued)
+		}
+	}
+}
+
+// listDir adds the files of dir to the scanner's index and returns its subdirectories.
+func (s *folderScanner) listDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var subdirs []string
+	files := make(map[string]FileInfo, len(entries))
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			subdirs = append(subdirs, path)
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return subdirs, err
+		}
+		files[path] = FileInfo{
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+	}
+
+	mutex.Lock()
+	for path, info := range files {
+		s.index.Put(path, info)
+	}
+	mutex.Unlock()
+	s.files.Add(int64(len(files)))
+	return subdirs, nil
+}
+
+// recordDir stores the modification time of dir for the persistent index.
+func recordDir(dir string) (time.Time, error) {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	mutex.Lock()
+	dirModTimes[dir] = stat.ModTime()
+	mutex.Unlock()
+	return stat.ModTime(), nil
+}
@@ -0,0 +1,233 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type jobKind string
+
+const (
+	retentionJob jobKind = "retention"
+	sizeJob      jobKind = "size"
+)
+
+// runResult sums up a run of a cleanup job.
+type runResult struct {
+	DeletedFiles   uint64 `json:"deleted_files"`
+	ReclaimedBytes int64  `json:"reclaimed_bytes"`
+	Errors         uint64 `json:"errors"`
+	// FirstError is the error of the first file that could not be deleted.
+	FirstError string `json:"first_error,omitempty"`
+	// Capped is set when the run stopped at the max_deletes_per_run of the
+	// rule.
+	Capped bool `json:"capped,omitempty"`
+	// FolderBytes and LimitBytes are the indexed size of the folder after a
+	// size run and its limit.
+	FolderBytes int64 `json:"folder_bytes,omitempty"`
+	LimitBytes  int64 `json:"limit_bytes,omitempty"`
+	// Error is why the run stopped early or did not run.
+	Error string `json:"error,omitempty"`
+}
+
+// aboveLimit reports whether a size run left the folder above its limit.
+func (r runResult) aboveLimit() bool {
+	return r.LimitBytes > 0 && r.FolderBytes > r.LimitBytes
+}
+
+// scheduledJob runs one kind of cleanup for a single rule on a fixed interval.
+type scheduledJob struct {
+	kind     jobKind
+	rule     *rule
+	interval time.Duration
+	run      func(ctx context.Context, r *rule) runResult
+	// trigger receives the requests to run the job now, see runNow.
+	trigger chan chan runResult
+	// stopped is closed once the scheduler of the job is stopped.
+	stopped <-chan struct{}
+
+	mu           sync.Mutex
+	running      bool
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastResult   runResult
+	nextRun      time.Time
+}
+
+// scheduler owns the periodic cleanup jobs of a rule. Each job runs in its
+// own goroutine so a slow job never delays the others, and a job never
+// overlaps with itself.
+type scheduler struct {
+	jobs     []*scheduledJob
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// errRuleStopped is returned when running a job of a rule that was removed or
+// replaced in the meantime.
+var errRuleStopped = errors.New("rule was stopped")
+
+func newScheduler(r *rule) *scheduler {
+	s := &scheduler{stopped: make(chan struct{})}
+	s.add(retentionJob, r, time.Duration(r.config.DeleteIntervalSeconds)*time.Second, DeleteOldFiles)
+	s.add(sizeJob, r, time.Duration(r.config.CheckSizeIntervalSecs)*time.Second, DeleteExcessFiles)
+	return s
+}
+
+func (s *scheduler) add(kind jobKind, r *rule, interval time.Duration, run func(ctx context.Context, r *rule) runResult) {
+	if interval <= 0 {
+		r.logger().Warningf("Not scheduling %s cleanup - interval must be positive", kind)
+		return
+	}
+	s.jobs = append(s.jobs, &scheduledJob{
+		kind:     kind,
+		rule:     r,
+		interval: interval,
+		run:      run,
+		trigger:  make(chan chan runResult),
+		stopped:  s.stopped,
+	})
+}
+
+// Start runs every job until ctx is cancelled or Stop is called.
+func (s *scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go func(job *scheduledJob) {
+			defer s.wg.Done()
+			job.loop(ctx)
+		}(job)
+	}
+}
+
+// Stop cancels the jobs and waits up to timeout for running jobs to drain.
+// It reports whether every job finished in time.
+func (s *scheduler) Stop(timeout time.Duration) bool {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.stopOnce.Do(func() { close(s.stopped) })
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// job returns the job of kind, or nil when it is not scheduled.
+func (s *scheduler) job(kind jobKind) *scheduledJob {
+	for _, job := range s.jobs {
+		if job.kind == kind {
+			return job
+		}
+	}
+	return nil
+}
+
+// LogStatus writes the state of every job to the log.
+func (s *scheduler) LogStatus() {
+	for _, job := range s.jobs {
+		job.mu.Lock()
+		state := "idle"
+		if job.running {
+			state = "running"
+		}
+		lastRun := "never"
+		if !job.lastRun.IsZero() {
+			lastRun = job.lastRun.Format(time.DateTime) + " (took " + job.lastDuration.Round(time.Millisecond).String() + ")"
+		}
+		log.Infof("Rule %s | %s job %s | every %s | last run %s | next run %s",
+			job.rule.config.DisplayName(), job.kind, state, job.interval, lastRun, job.nextRun.Format(time.DateTime))
+		job.mu.Unlock()
+	}
+}
+
+func (j *scheduledJob) loop(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	j.mu.Lock()
+	j.nextRun = time.Now().Add(j.interval)
+	j.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case reply := <-j.trigger:
+			reply <- j.execute(ctx)
+		case <-ticker.C:
+			j.mu.Lock()
+			j.nextRun = time.Now().Add(j.interval)
+			j.mu.Unlock()
+			if j.rule.paused.Load() {
+				j.rule.logger().Infof("Skipping %s cleanup, rule is paused", j.kind)
+				events.publish(daemonEvent{Type: eventJobSkipped, Rule: j.rule.config.DisplayName(), Job: j.kind})
+				continue
+			}
+			j.execute(ctx)
+		}
+	}
+}
+
+// runNow runs the job outside of its schedule, even when its rule is paused,
+// and waits for the result. A run in progress is finished first.
+func (j *scheduledJob) runNow(ctx context.Context) (runResult, error) {
+	reply := make(chan runResult, 1)
+	select {
+	case j.trigger <- reply:
+	case <-j.stopped:
+		return runResult{}, errRuleStopped
+	case <-ctx.Done():
+		return runResult{}, ctx.Err()
+	}
+	select {
+	case result := <-reply:
+		return result, nil
+	case <-ctx.Done():
+		return runResult{}, ctx.Err()
+	}
+}
+
+func (j *scheduledJob) execute(ctx context.Context) runResult {
+	start := time.Now()
+	j.mu.Lock()
+	j.running = true
+	j.mu.Unlock()
+	events.publish(daemonEvent{Time: start, Type: eventJobStarted, Rule: j.rule.config.DisplayName(), Job: j.kind})
+
+	result := j.run(ctx, j.rule)
+
+	j.mu.Lock()
+	j.running = false
+	j.lastRun = start
+	j.lastDuration = time.Since(start)
+	j.lastResult = result
+	j.mu.Unlock()
+	finished := daemonEvent{Type: eventJobFinished, Rule: j.rule.config.DisplayName(), Job: j.kind, Result: &result, Error: result.Error}
+	events.publish(finished)
+	if result.Capped {
+		finished.Type = eventSafetyCapReached
+		events.publish(finished)
+	}
+	if result.Errors > 0 {
+		finished.Type, finished.Error = eventDeleteErrors, result.FirstError
+		events.publish(finished)
+	}
+	if result.aboveLimit() {
+		finished.Type = eventSizeLimitExceeded
+		events.publish(finished)
+	}
+	return result
+}
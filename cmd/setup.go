@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func setupCmd() *cobra.Command {
+	var (
+		nonInteractive bool
+		noCompletion   bool
+	)
+	var setupCmd = &cobra.Command{
+		Use:   "setup",
+		Short: "Create the configuration file and install shell completion",
+		Long: "Create the configuration file at the --config path when it does not exist, asking for the first rule on a terminal like config init, " +
+			"and install tab completion for the current shell. FileCleanup changes nothing in the home directory unless asked to with this or another command.",
+		Args: cobra.NoArgs,
+		// The configuration is created here, so it is not loaded first.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return resolveConfigFilePath() },
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(ConfigFilePath); errors.Is(err, os.ErrNotExist) {
+				format, err := pkg.ConfigFormat(ConfigFilePath)
+				if err != nil {
+					return err
+				}
+				interactive := !nonInteractive && isTerminal(os.Stdin)
+				if err := writeInitConfig(cmd, defaultInitSettings(), format, ConfigFilePath, interactive); err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err
+			} else {
+				fmt.Fprintln(cmd.OutOrStdout(), "Using configuration file", ConfigFilePath)
+			}
+
+			if noCompletion {
+				return nil
+			}
+			switch shell := currentShell(); shell {
+			case "cmd":
+				fmt.Fprintln(cmd.OutOrStdout(), "cmd.exe doesn't support tab-completion; use PowerShell instead.")
+			case "bash", "zsh", "fish", "powershell":
+				home, err := os.UserHomeDir()
+				if err != nil {
+					return err
+				}
+				return installCompletion(cmd, shell, home)
+			default:
+				fmt.Fprintln(cmd.OutOrStdout(), "Unknown shell, skipping completion setup, see completion install")
+			}
+			return nil
+		},
+	}
+	setupCmd.Flags().BoolVar(&nonInteractive, "non-interactive", false, "Do not prompt, write the example configuration")
+	setupCmd.Flags().BoolVar(&noCompletion, "no-completion", false, "Do not install shell completion")
+	return setupCmd
+}
+
+func init() {
+	RootCmd.AddCommand(setupCmd())
+}
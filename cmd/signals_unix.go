@@ -0,0 +1,15 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// reloadSignals make a running daemon reload its configuration.
+	reloadSignals = []os.Signal{syscall.SIGHUP}
+	// statusSignals make a running daemon dump its status to the log.
+	statusSignals = []os.Signal{syscall.SIGUSR1}
+)
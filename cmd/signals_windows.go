@@ -0,0 +1,9 @@
+package cmd
+
+import "os"
+
+// Windows has no equivalent of SIGHUP and SIGUSR1.
+var (
+	reloadSignals []os.Signal
+	statusSignals []os.Signal
+)
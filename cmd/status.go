@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"FileCleanup/pkg"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// SocketPath overrides socket_path of the configuration, set with --socket.
+var SocketPath string
+
+// statusSocketPath returns the Unix socket the daemon answers on.
+func statusSocketPath() (string, error) {
+	switch {
+	case SocketPath != "":
+		return SocketPath, nil
+	case AppConfig.SocketPath != "":
+		return AppConfig.SocketPath, nil
+	}
+	return pkg.DefaultSocketPath()
+}
+
+// daemonStatus is the answer of GET /status.
+type daemonStatus struct {
+	Version    string       `json:"version"`
+	PID        int          `json:"pid"`
+	StartedAt  time.Time    `json:"started_at"`
+	Ready      bool         `json:"ready"`
+	ConfigPath string       `json:"config_path"`
+	Rules      []ruleStatus `json:"rules"`
+}
+
+type ruleStatus struct {
+	Name          string  `json:"name"`
+	TargetFolder  string  `json:"target_folder"`
+	IndexedFiles  int     `json:"indexed_files"`
+	FolderBytes   int64   `json:"folder_bytes"`
+	RetentionDays float64 `json:"retention_days"`
+	Paused        bool    `json:"paused"`
+	Indexing      bool    `json:"indexing,omitempty"`
+	// MaxFolderBytes is the size limit, for percentage limits as of now.
+	MaxFolderBytes   int64       `json:"max_folder_bytes"`
+	MaxFolderPercent int64       `json:"max_folder_percent,omitempty"`
+	Jobs             []jobStatus `json:"jobs"`
+}
+
+type jobStatus struct {
+	Kind         jobKind    `json:"kind"`
+	State        string     `json:"state"`
+	Interval     string     `json:"interval"`
+	NextRun      time.Time  `json:"next_run"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastResult   *runResult `json:"last_result,omitempty"`
+}
+
+// status describes the daemon and every active rule.
+func (d *daemon) status() daemonStatus {
+	status := daemonStatus{
+		Version:    RootCmd.Version,
+		PID:        os.Getpid(),
+		StartedAt:  d.startedAt,
+		Ready:      d.isReady(),
+		ConfigPath: d.configPath,
+		Rules:      []ruleStatus{},
+	}
+	for _, r := range activeRules() {
+		mutex.Lock()
+		rs := ruleStatus{
+			Name:          r.config.DisplayName(),
+			TargetFolder:  r.config.TargetFolder,
+			IndexedFiles:  r.index.Len(),
+			FolderBytes:   r.index.Size(),
+			RetentionDays: r.config.RetentionDays,
+			Paused:        r.paused.Load(),
+			Indexing:      !r.indexed.Load(),
+		}
+		mutex.Unlock()
+		if r.config.MaxFolderPercentEnabled {
+			rs.MaxFolderPercent = r.config.MaxFolderSizePercent
+		}
+		rs.MaxFolderBytes, _ = sizeLimit(r.config)
+		for _, job := range r.sched.jobs {
+			rs.Jobs = append(rs.Jobs, job.status())
+		}
+		status.Rules = append(status.Rules, rs)
+	}
+	return status
+}
+
+func (j *scheduledJob) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := jobStatus{Kind: j.kind, State: "idle", Interval: j.interval.String(), NextRun: j.nextRun}
+	if j.running {
+		status.State = "running"
+	}
+	if !j.lastRun.IsZero() {
+		lastRun, result := j.lastRun, j.lastResult
+		status.LastRun = &lastRun
+		status.LastDuration = j.lastDuration.Round(time.Millisecond).String()
+		status.LastResult = &result
+	}
+	return status
+}
+
+// getDaemonStatus asks the daemon for its status.
+func getDaemonStatus(ctx context.Context, client *controlClient) (daemonStatus, error) {
+	var status daemonStatus
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	err := client.call(ctx, http.MethodGet, "/status", nil, &status)
+	return status, err
+}
+
+func statusCmd() *cobra.Command {
+	var asJSON bool
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show what a running clean daemon is doing",
+		Long: "Ask the clean daemon over its Unix socket for the size, limits and jobs of every active rule. " +
+			"The socket is socket_path of the configuration, or fileCleanup.sock in $XDG_RUNTIME_DIR or the configuration directory.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newControlClient("", "")
+			if err != nil {
+				return err
+			}
+			status, err := getDaemonStatus(cmd.Context(), client)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return writeIndentedJSON(cmd.OutOrStdout(), status)
+			}
+			writeStatus(cmd.OutOrStdout(), status)
+			return nil
+		},
+	}
+	statusCmd.Flags().BoolVar(&asJSON, "json", false, "Print the status as JSON")
+	statusCmd.Flags().StringVar(&SocketPath, "socket", "", "Unix socket of the daemon, overriding socket_path of the configuration")
+	return statusCmd
+}
+
+func writeStatus(w io.Writer, status daemonStatus) {
+	state := "ready"
+	if !status.Ready {
+		state = "indexing"
+	}
+	fmt.Fprintf(w, "FileCleanup %s | pid %d | up %s | %s | %s\n\n", status.Version, status.PID,
+		time.Since(status.StartedAt).Round(time.Second), state, status.ConfigPath)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tSTATE\tTARGET FOLDER\tFILES\tSIZE\tLIMIT\tRETENTION")
+	for _, rule := range status.Rules {
+		limit := pkg.FormatBytes(rule.MaxFolderBytes)
+		if rule.MaxFolderPercent > 0 {
+			limit = fmt.Sprintf("%d%% (%s)", rule.MaxFolderPercent, limit)
+		}
+		state := "active"
+		if rule.Paused {
+			state = "paused"
+		}
+		if rule.Indexing {
+			state = "indexing"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", rule.Name, state, rule.TargetFolder, rule.IndexedFiles, pkg.FormatBytes(rule.FolderBytes),
+			limit, formatRetention(rule.RetentionDays))
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tJOB\tSTATE\tEVERY\tLAST RUN\tTOOK\tLAST RESULT\tNEXT RUN")
+	for _, rule := range status.Rules {
+		for _, job := range rule.Jobs {
+			lastRun, result := "never", ""
+			if job.LastRun != nil {
+				lastRun = job.LastRun.Local().Format(time.DateTime)
+				result = formatRunResult(*job.LastResult)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rule.Name, job.Kind, job.State, job.Interval, lastRun,
+				job.LastDuration, result, job.NextRun.Local().Format(time.DateTime))
+		}
+	}
+	tw.Flush()
+}
+
+func formatRunResult(result runResult) string {
+	parts := []string{fmt.Sprintf("%d deleted", result.DeletedFiles), pkg.FormatBytes(result.ReclaimedBytes) + " freed"}
+	if result.Errors > 0 {
+		parts = append(parts, fmt.Sprintf("%d errors", result.Errors))
+	}
+	if result.Capped {
+		parts = append(parts, "safety cap reached")
+	}
+	if result.aboveLimit() {
+		parts = append(parts, fmt.Sprintf("still above limit at %s of %s", pkg.FormatBytes(result.FolderBytes), pkg.FormatBytes(result.LimitBytes)))
+	}
+	if result.Error != "" {
+		parts = append(parts, result.Error)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func init() {
+	RootCmd.AddCommand(statusCmd())
+}
@@ -3,68 +3,29 @@ package cmd
 import (
 	constant "FileCleanup/const"
 	"FileCleanup/pkg"
-	"encoding/json"
-	"os"
-	"runtime"
-	"syscall"
-	"unsafe"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func getFolderSizeMB() int64 {
-	var size int64
-
-	for _, fileInfo := range fileInfoMap {
-		size += fileInfo.Size
-	}
+func getFolderSizeMB(index *FileIndex) int64 {
+	mutex.Lock()
+	defer mutex.Unlock()
 
 	// Convert bytes to MB
-	return size / constant.MB
-}
-
-func getFileAvgSizeMB() int64 {
-	if len(fileInfoMap) == 0 {
-		return 0
-	}
-
-	totalSize := int64(0)
-	for _, fileInfo := range fileInfoMap {
-		totalSize += fileInfo.Size
-	}
-	return totalSize / int64(len(fileInfoMap)) / constant.MB
+	return index.Size() / constant.MB
 }
 
-func getFolderSizePercent(config pkg.DeleteConfig) (int64, float64, float64) {
-	var totalSize int64
-	var freeSize int64
-	switch goos := runtime.GOOS; goos {
-	case "windows":
-		kernelDLL := syscall.MustLoadDLL("kernel32.dll")
-		GetDiskFreeSpaceExW := kernelDLL.MustFindProc("GetDiskFreeSpaceExW")
-
-		var avail int64
-
-		path := config.TargetFolder
-		_, _, lastErr := GetDiskFreeSpaceExW.Call(
-			uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(path))),
-			uintptr(unsafe.Pointer(&freeSize)),
-			uintptr(unsafe.Pointer(&totalSize)),
-			uintptr(unsafe.Pointer(&avail)),
-		)
-
-		log.Debugln(lastErr)
-		log.Debugf("Free: %f GB - Total: %f GB", float64(freeSize)/constant.GB, float64(totalSize)/constant.GB)
-		break
-	default:
-		log.Fatal("Unsupported platform.")
+func getFolderSizePercent(config pkg.DeleteConfig, index *FileIndex) (int64, float64, float64, error) {
+	freeSize, totalSize, err := diskUsage(config.TargetFolder)
+	if err != nil {
+		return 0, 0, 0, err
 	}
+	log.Debugf("Free: %f GB - Total: %f GB", float64(freeSize)/constant.GB, float64(totalSize)/constant.GB)
 
 	// Calculate the total size of all files in the folder
-	var folderSize int64
-	for _, fileInfo := range fileInfoMap {
-		folderSize += fileInfo.Size
-	}
+	mutex.Lock()
+	folderSize := index.Size()
+	mutex.Unlock()
 
 	log.Printf("Folder size: %f GB | Available size: %f GB | Total Drive size: %f GB", float64(folderSize)/constant.GB, float64(freeSize)/constant.GB, float64(totalSize)/constant.GB)
 
@@ -72,19 +33,24 @@ func getFolderSizePercent(config pkg.DeleteConfig) (int64, float64, float64) {
 	var folderSizePercent int
 	if config.MaxFolderPercentFromAvailableSize {
 		folderSizePercent = int((float64(folderSize) / float64(freeSize)) * 100)
-		return int64(folderSizePercent), float64(folderSize) / constant.MB, float64(freeSize) / constant.MB
+		return int64(folderSizePercent), float64(folderSize) / constant.MB, float64(freeSize) / constant.MB, nil
 	}
 	folderSizePercent = int((float64(folderSize) / float64(totalSize)) * 100)
-	return int64(folderSizePercent), float64(folderSize) / constant.MB, float64(totalSize) / constant.MB
+	return int64(folderSizePercent), float64(folderSize) / constant.MB, float64(totalSize) / constant.MB, nil
 }
 
-func UnmarshalJson(filepath string, obj *pkg.Config) {
-	fileBytes, err := os.ReadFile(filepath)
-	if err != nil {
-		log.Fatalf("Error in reading file - reading %s - %s", filepath, err)
+// sizeLimit returns the folder size limit of config in bytes, percentage
+// limits resolved against the disk as of now.
+func sizeLimit(config pkg.DeleteConfig) (int64, error) {
+	if !config.MaxFolderPercentEnabled {
+		return config.MaxFolderSizeMB * constant.MB, nil
 	}
-	err = json.Unmarshal(fileBytes, obj)
+	free, total, err := diskUsage(config.TargetFolder)
 	if err != nil {
-		log.Fatalf("Error in unmarshling json file - reading %s - %s", filepath, err)
+		return 0, err
+	}
+	if config.MaxFolderPercentFromAvailableSize {
+		total = free
 	}
+	return total * config.MaxFolderSizePercent / 100, nil
 }
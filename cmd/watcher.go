@@ -5,23 +5,37 @@ import (
 	"os"
 )
 
+// HandleNewFile adds filePath to the index of every rule whose target folder contains it.
 func HandleNewFile(filePath string) {
-	mutex.Lock()
-	defer mutex.Unlock()
-
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		log.Errorln("Error getting file info:", err)
 		return
 	}
+	if fileInfo.IsDir() {
+		return
+	}
 
-	// Update fileInfoMap with the new file's information
-	fileInfoMap[filePath] = FileInfo{
-		Size:    fileInfo.Size(),
-		ModTime: fileInfo.ModTime(),
+	mutex.Lock()
+	var added []string
+	for _, r := range rules {
+		if !isWithin(filePath, r.config.TargetFolder) {
+			continue
+		}
+		// Update the rule's index with the new file's information
+		r.index.Put(filePath, FileInfo{
+			Size:    fileInfo.Size(),
+			ModTime: fileInfo.ModTime(),
+		})
+		added = append(added, r.config.DisplayName())
 	}
+	detailed := AppConfig.IsDetailedLogEnabled
+	mutex.Unlock()
 
-	if AppConfig.IsDetailedLogEnabled {
+	for _, name := range added {
+		events.publish(daemonEvent{Type: eventFileAdded, Rule: name, Path: filePath, Size: fileInfo.Size()})
+	}
+	if len(added) > 0 && detailed {
 		log.Infoln("Added:", filePath)
 	}
 }
@@ -0,0 +1,182 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AuditLogConfig enables the audit log, a JSON Lines file recording every
+// deletion. It is rotated separately from the operational log.
+type AuditLogConfig struct {
+	Path       string `json:"path" yaml:"path" toml:"path" comment:"File the audit records are appended to, one JSON object per line" schema:"required"`
+	MaxSizeMB  int    `json:"max_size_mb,omitempty" yaml:"max_size_mb,omitempty" toml:"max_size_mb,omitempty" comment:"Size in MB at which the file is rotated, defaults to 100"`
+	MaxBackups int    `json:"max_backups,omitempty" yaml:"max_backups,omitempty" toml:"max_backups,omitempty" comment:"Number of rotated files kept, 0 keeps all of them"`
+	MaxAgeDays int    `json:"max_age_days,omitempty" yaml:"max_age_days,omitempty" toml:"max_age_days,omitempty" comment:"Days rotated files are kept, 0 keeps them forever"`
+	Compress   bool   `json:"compress,omitempty" yaml:"compress,omitempty" toml:"compress,omitempty" comment:"Compress rotated files with gzip"`
+}
+
+// Values of the action, reason and result fields of an AuditRecord.
+const (
+	AuditActionDelete = "delete"
+
+	// AuditReasonRetention marks files older than the retention of the rule,
+	// AuditReasonSize the oldest files of a folder over its size limit.
+	AuditReasonRetention = "retention"
+	AuditReasonSize      = "size"
+
+	AuditResultDeleted = "deleted"
+	// AuditResultNotFound marks files removed by someone else first.
+	AuditResultNotFound = "not_found"
+	AuditResultError    = "error"
+)
+
+// AuditRecord is a line of the audit log.
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Rule    string    `json:"rule"`
+	Action  string    `json:"action"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Reason  string    `json:"reason"`
+	Result  string    `json:"result"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// AuditFiles returns the rotated backups of the audit log at path followed by
+// the log itself, oldest first. Files that do not exist are left out.
+func AuditFiles(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext) + "-"
+	var backups []string
+	for _, pattern := range []string{prefix + "*" + ext, prefix + "*" + ext + ".gz"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, matches...)
+	}
+	// Backups are named by the time they were rotated
+	sort.Slice(backups, func(i, j int) bool {
+		return strings.TrimSuffix(backups[i], ".gz") < strings.TrimSuffix(backups[j], ".gz")
+	})
+	if _, err := os.Stat(path); err == nil {
+		backups = append(backups, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return backups, nil
+}
+
+// ReadAuditLog calls fn with every record of the audit log at path and its
+// rotated backups, oldest first. Lines that are not records, such as a line
+// cut short by a crash, are skipped and counted.
+func ReadAuditLog(path string, fn func(AuditRecord) error) (skipped int, err error) {
+	files, err := AuditFiles(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no audit log at %s", path)
+	}
+	for _, file := range files {
+		n, err := readAuditFile(file, fn)
+		skipped += n
+		if err != nil {
+			return skipped, fmt.Errorf("reading audit log %s: %w", file, err)
+		}
+	}
+	return skipped, nil
+}
+
+func readAuditFile(path string, fn func(AuditRecord) error) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	skipped := 0
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Action == "" {
+			skipped++
+			continue
+		}
+		if err := fn(record); err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, scanner.Err()
+}
+
+// AuditFilter selects audit records. Empty fields match every record.
+type AuditFilter struct {
+	Rules   []string
+	Actions []string
+	Results []string
+	// Path is a glob pattern matched against the whole path, or against the
+	// file name when it has no path separator.
+	Path  string
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether record passes the filter.
+func (f AuditFilter) Match(record AuditRecord) bool {
+	if !matchAny(f.Rules, record.Rule) || !matchAny(f.Actions, record.Action) || !matchAny(f.Results, record.Result) {
+		return false
+	}
+	if !f.Since.IsZero() && record.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !record.Time.Before(f.Until) {
+		return false
+	}
+	if f.Path != "" {
+		name := record.Path
+		if !strings.ContainsAny(f.Path, `/\`) {
+			name = filepath.Base(record.Path)
+		}
+		if ok, err := filepath.Match(f.Path, name); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
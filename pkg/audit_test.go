@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func auditLine(t *testing.T, record AuditRecord) string {
+	t.Helper()
+	line, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(line) + "\n"
+}
+
+func TestReadAuditLogRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	record := func(name string) string {
+		return auditLine(t, AuditRecord{Rule: "logs", Action: AuditActionDelete, Path: "/" + name, Result: AuditResultDeleted})
+	}
+
+	// Backups are named by the time they were rotated, compressed or not
+	files := map[string]string{
+		"audit-2026-01-02T10-00-00.000.jsonl": record("2") + "\n" + `{"time": "2026-01-02T09:00:00Z"}` + "\n",
+		"audit-2026-01-03T10-00-00.000.jsonl": record("3") + record("4"),
+		"audit.jsonl":                         record("5") + `{"rule": "logs", "action": "del`,
+		"audit-notes.txt":                     record("ignored"),
+		"other-2026-01-01T10-00-00.000.jsonl": record("ignored"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gz, err := os.Create(filepath.Join(dir, "audit-2026-01-01T10-00-00.000.jsonl.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := gzip.NewWriter(gz)
+	writer.Write([]byte(record("1")))
+	writer.Close()
+	gz.Close()
+
+	listed, err := AuditFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"audit-2026-01-01T10-00-00.000.jsonl.gz", "audit-2026-01-02T10-00-00.000.jsonl", "audit-2026-01-03T10-00-00.000.jsonl", "audit.jsonl"}
+	var names []string
+	for _, file := range listed {
+		names = append(names, filepath.Base(file))
+	}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("audit files %v, want %v", names, want)
+	}
+
+	var paths []string
+	skipped, err := ReadAuditLog(path, func(record AuditRecord) error {
+		paths = append(paths, record.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The record without an action and the line cut short are skipped
+	if strings.Join(paths, " ") != "/1 /2 /3 /4 /5" || skipped != 2 {
+		t.Errorf("read %v skipping %d lines, want /1 to /5 skipping 2", paths, skipped)
+	}
+}
+
+func TestReadAuditLogMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if _, err := ReadAuditLog(path, func(AuditRecord) error { return nil }); err == nil || !strings.Contains(err.Error(), "no audit log") {
+		t.Errorf("got %v, want an error about the missing audit log", err)
+	}
+
+	// Only rotated files are left after the log was turned off
+	backup := filepath.Join(filepath.Dir(path), "audit-2026-01-01T10-00-00.000.jsonl")
+	if err := os.WriteFile(backup, []byte(auditLine(t, AuditRecord{Action: AuditActionDelete})), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	if _, err := ReadAuditLog(path, func(AuditRecord) error { count++; return nil }); err != nil || count != 1 {
+		t.Errorf("read %d records, %v, want the record of the backup", count, err)
+	}
+}
+
+func TestAuditFilterMatch(t *testing.T) {
+	day := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	record := AuditRecord{Time: day, Rule: "uploads", Action: AuditActionDelete, Path: "/srv/uploads/2026/report.pdf", Result: AuditResultDeleted}
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   bool
+	}{
+		{"empty", AuditFilter{}, true},
+		{"rule", AuditFilter{Rules: []string{"logs", "uploads"}}, true},
+		{"other rule", AuditFilter{Rules: []string{"logs"}}, false},
+		{"action", AuditFilter{Actions: []string{AuditActionDelete}}, true},
+		{"result", AuditFilter{Results: []string{AuditResultError, AuditResultNotFound}}, false},
+		{"since before", AuditFilter{Since: day.Add(-time.Hour)}, true},
+		{"since is inclusive", AuditFilter{Since: day}, true},
+		{"since after", AuditFilter{Since: day.Add(time.Second)}, false},
+		{"until after", AuditFilter{Until: day.Add(time.Second)}, true},
+		{"until is exclusive", AuditFilter{Until: day}, false},
+		{"range", AuditFilter{Since: day.Add(-time.Hour), Until: day.Add(time.Hour), Rules: []string{"uploads"}}, true},
+		{"file name glob", AuditFilter{Path: "*.pdf"}, true},
+		{"file name glob mismatch", AuditFilter{Path: "*.log"}, false},
+		{"path glob", AuditFilter{Path: "/srv/uploads/*/*.pdf"}, true},
+		{"path glob across folders", AuditFilter{Path: "/srv/*.pdf"}, false},
+		{"invalid glob", AuditFilter{Path: "[.pdf"}, false},
+	}
+	for _, test := range tests {
+		if got := test.filter.Match(record); got != test.want {
+			t.Errorf("%s: Match = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -5,10 +5,6 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
-	_ "syscall"
-	"unsafe"
-
-	"golang.org/x/sys/windows"
 )
 
 func DetectShell() string {
@@ -41,33 +37,3 @@ func DetectShell() string {
 
 	return "unknown"
 }
-
-func getParentProcessPath() (string, error) {
-	ppid := uint32(os.Getppid())
-
-	// Open process handle
-	h, err := windows.OpenProcess(windows.PROCESS_QUERY_INFORMATION|windows.PROCESS_VM_READ, false, ppid)
-	if err != nil {
-		return "", err
-	}
-	defer windows.CloseHandle(h)
-
-	// Resolve Psapi.GetModuleFileNameExW
-	modPsapi := windows.NewLazySystemDLL("psapi.dll")
-	procGetModuleFileNameExW := modPsapi.NewProc("GetModuleFileNameExW")
-
-	buf := make([]uint16, windows.MAX_PATH)
-
-	r0, _, e1 := procGetModuleFileNameExW.Call(
-		uintptr(h),
-		0,
-		uintptr(unsafe.Pointer(&buf[0])),
-		uintptr(len(buf)),
-	)
-
-	if r0 == 0 {
-		return "", e1
-	}
-
-	return windows.UTF16ToString(buf), nil
-}
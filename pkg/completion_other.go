@@ -0,0 +1,10 @@
+//go:build !windows
+
+package pkg
+
+import "errors"
+
+// getParentProcessPath is only used to detect the shell on Windows.
+func getParentProcessPath() (string, error) {
+	return "", errors.New("parent process lookup is only supported on windows")
+}
@@ -1,66 +1,132 @@
 package pkg
 
 import (
-	"encoding/json"
-	"errors"
-	"os"
-	"path/filepath"
-
-	log "github.com/sirupsen/logrus"
+	"fmt"
+	"time"
 )
 
+// DeleteConfig is a single cleanup rule. The json, yaml and toml tags use the
+// same keys so a rule reads the same in every supported format, the comment
+// tag documents the key in generated configuration files.
 type DeleteConfig struct {
-	TargetFolder                      string  `json:"target_folder"`
-	RetentionDays                     float64 `json:"retention_days"`
-	DeleteIntervalSeconds             int     `json:"delete_interval_seconds"`
-	MaxFolderSizeMB                   int64   `json:"max_folder_size_mb"`
-	MaxFolderSizePercent              int64   `json:"max_folder_size_percent"`
-	MaxFolderPercentEnabled           bool    `json:"max_folder_percent_enabled"`
-	MaxFolderPercentFromAvailableSize bool    `json:"max_folder_percent_from_available_size"`
-	CheckSizeIntervalSecs             int     `json:"check_size_interval_secs"`
+	// Name identifies the rule in logs and commands, Enabled switches it off
+	// without removing it.
+	Name                              string  `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty" comment:"Name identifying the rule in logs and commands, defaults to the target folder" schema:"pattern=^[A-Za-z0-9._-]+$"`
+	Enabled                           bool    `json:"enabled" yaml:"enabled" toml:"enabled" comment:"Set to false to switch the rule off without removing it"`
+	Description                       string  `json:"description,omitempty" yaml:"description,omitempty" toml:"description,omitempty" comment:"What the rule is for"`
+	TargetFolder                      string  `json:"target_folder" yaml:"target_folder" toml:"target_folder" comment:"Folder whose files are cleaned up" schema:"required"`
+	RetentionDays                     float64 `json:"retention_days" yaml:"retention_days" toml:"retention_days" comment:"Files older than this are deleted, in days or as a duration such as 36h or 2w"`
+	DeleteIntervalSeconds             int     `json:"delete_interval_seconds" yaml:"delete_interval_seconds" toml:"delete_interval_seconds" comment:"How often old files are deleted, in seconds or as a duration such as 1d"`
+	MaxFolderSizeMB                   int64   `json:"max_folder_size_mb" yaml:"max_folder_size_mb" toml:"max_folder_size_mb" comment:"Oldest files are deleted while the folder is larger than this, in MB or as a size such as 500MiB or 1.5TB"`
+	MaxFolderSizePercent              int64   `json:"max_folder_size_percent" yaml:"max_folder_size_percent" toml:"max_folder_size_percent" comment:"Size limit as a percentage of the drive, used when max_folder_percent_enabled is set" schema:"minimum=0,maximum=100"`
+	MaxFolderPercentEnabled           bool    `json:"max_folder_percent_enabled" yaml:"max_folder_percent_enabled" toml:"max_folder_percent_enabled" comment:"Limit the folder by max_folder_size_percent instead of max_folder_size_mb"`
+	MaxFolderPercentFromAvailableSize bool    `json:"max_folder_percent_from_available_size" yaml:"max_folder_percent_from_available_size" toml:"max_folder_percent_from_available_size" comment:"Take the percentage of the free space instead of the drive size"`
+	CheckSizeIntervalSecs             int     `json:"check_size_interval_secs" yaml:"check_size_interval_secs" toml:"check_size_interval_secs" comment:"How often the folder size is checked, in seconds or as a duration such as 12h"`
+	// ScanConcurrency is the number of folders listed in parallel while indexing.
+	ScanConcurrency int `json:"scan_concurrency,omitempty" yaml:"scan_concurrency,omitempty" toml:"scan_concurrency,omitempty" comment:"Number of folders listed in parallel while indexing" schema:"minimum=0"`
+	// DeleteWorkers is the number of files removed in parallel.
+	DeleteWorkers int `json:"delete_workers,omitempty" yaml:"delete_workers,omitempty" toml:"delete_workers,omitempty" comment:"Number of files deleted in parallel" schema:"minimum=0"`
+	// MaxDeletesPerSecond and MaxDeleteBytesPerSecond throttle deletion, zero means unlimited.
+	MaxDeletesPerSecond     float64 `json:"max_deletes_per_second,omitempty" yaml:"max_deletes_per_second,omitempty" toml:"max_deletes_per_second,omitempty" comment:"Maximum files deleted per second, 0 is unlimited"`
+	MaxDeleteBytesPerSecond int64   `json:"max_delete_bytes_per_second,omitempty" yaml:"max_delete_bytes_per_second,omitempty" toml:"max_delete_bytes_per_second,omitempty" comment:"Maximum bytes deleted per second or a size such as 100MiB, 0 is unlimited"`
+	// MaxDeletesPerRun is a safety cap stopping a run after that many files,
+	// zero means unlimited.
+	MaxDeletesPerRun int `json:"max_deletes_per_run,omitempty" yaml:"max_deletes_per_run,omitempty" toml:"max_deletes_per_run,omitempty" comment:"Stop a run after deleting this many files and notify safety_cap_reached, 0 is unlimited" schema:"minimum=0"`
+	// ThrottleWindows override the rate limits during the given times of day.
+	ThrottleWindows []ThrottleWindow `json:"throttle_windows,omitempty" yaml:"throttle_windows,omitempty" toml:"throttle_windows,omitempty" comment:"Rate limits that apply during the given times of day"`
+	// Source is the file the rule was loaded from and SourceIndex its position
+	// in that file, set by LoadConfig.
+	Source      string `json:"-" yaml:"-" toml:"-"`
+	SourceIndex int    `json:"-" yaml:"-" toml:"-"`
 }
 
-type Config struct {
-	DeleteConfig         []DeleteConfig `json:"delete_config"`
-	IsDetailedLogEnabled bool           `json:"detailed_log"`
-	LogFilePath          string         `json:"log_file_path"`
+// ThrottleWindow applies its own deletion rate limits between Start and End,
+// given as "15:04" in local time. A window whose End is before its Start spans midnight.
+type ThrottleWindow struct {
+	Start                   string  `json:"start" yaml:"start" toml:"start" comment:"Start of the window, HH:MM local time" schema:"required,pattern=^([01]?\\d|2[0-3]):[0-5]\\d$"`
+	End                     string  `json:"end" yaml:"end" toml:"end" comment:"End of the window, HH:MM local time" schema:"required,pattern=^([01]?\\d|2[0-3]):[0-5]\\d$"`
+	MaxDeletesPerSecond     float64 `json:"max_deletes_per_second,omitempty" yaml:"max_deletes_per_second,omitempty" toml:"max_deletes_per_second,omitempty" comment:"Maximum files deleted per second during the window"`
+	MaxDeleteBytesPerSecond int64   `json:"max_delete_bytes_per_second,omitempty" yaml:"max_delete_bytes_per_second,omitempty" toml:"max_delete_bytes_per_second,omitempty" comment:"Maximum bytes deleted per second or a size such as 10MiB during the window"`
 }
 
-func InitConfigDir() {
-	dirname, err := os.UserHomeDir()
+// Contains reports whether the time of day of t falls inside the window.
+func (w ThrottleWindow) Contains(t time.Time) (bool, error) {
+	start, err := time.Parse("15:04", w.Start)
 	if err != nil {
-		log.Fatal(err)
+		return false, fmt.Errorf("invalid throttle window start %q: %w", w.Start, err)
 	}
-	if _, err := os.Stat(filepath.Join(dirname, ".fileCleanup")); errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(filepath.Join(dirname, ".fileCleanup"), 0777)
-		if err != nil {
-			log.Fatal(err)
-		}
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return false, fmt.Errorf("invalid throttle window end %q: %w", w.End, err)
 	}
+	minute := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from <= to {
+		return minute >= from && minute < to, nil
+	}
+	return minute >= from || minute < to, nil
 }
 
-func InitConfigFile() {
-	dirname, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if _, err := os.Stat(filepath.Join(dirname, ".fileCleanup", ".fileCleanup.json")); errors.Is(err, os.ErrNotExist) {
-		file, err := os.Create(filepath.Join(dirname, ".fileCleanup", ".fileCleanup.json"))
-		if err != nil {
-			log.Fatal(err)
+// DeleteRateLimits returns the deletion rate limits that apply at t. The first
+// matching throttle window wins, otherwise the rule wide limits are used.
+func (d DeleteConfig) DeleteRateLimits(t time.Time) (float64, int64) {
+	for _, window := range d.ThrottleWindows {
+		if ok, err := window.Contains(t); err == nil && ok {
+			return window.MaxDeletesPerSecond, window.MaxDeleteBytesPerSecond
 		}
-		log.Infof("Created configuration file %s at %s", file.Name(), filepath.Join(dirname, ".fileCleanup"))
-		initJsonData(file)
 	}
+	return d.MaxDeletesPerSecond, d.MaxDeleteBytesPerSecond
+}
+
+type Config struct {
+	// Version is the version of the configuration format, older files are
+	// migrated when loaded.
+	Version              int            `json:"version" yaml:"version" toml:"version" comment:"Version of the configuration format, older files are migrated when loaded"`
+	DeleteConfig         []DeleteConfig `json:"delete_config" yaml:"delete_config" toml:"delete_config" comment:"Cleanup rules, one per target folder" schema:"required"`
+	IsDetailedLogEnabled bool           `json:"detailed_log" yaml:"detailed_log" toml:"detailed_log" comment:"Log every added and deleted file"`
+	LogFilePath          string         `json:"log_file_path" yaml:"log_file_path" toml:"log_file_path" comment:"Folder the FileCleanup log is written to"`
+	// IndexFilePath enables the persistent file index when set. The index is
+	// reconciled at startup instead of re-walking every target folder.
+	IndexFilePath string `json:"index_file_path,omitempty" yaml:"index_file_path,omitempty" toml:"index_file_path,omitempty" comment:"File the file index is persisted to, empty disables it"`
+	// Include lists further files whose rules are added, as glob patterns
+	// relative to the directory of the configuration file.
+	Include []string `json:"include,omitempty" yaml:"include,omitempty" toml:"include,omitempty" comment:"Further configuration files adding rules, glob patterns relative to this file"`
+	// HTTPAddress is the address clean serves its metrics, health checks and
+	// status on, empty disables the HTTP server.
+	HTTPAddress string `json:"http_address,omitempty" yaml:"http_address,omitempty" toml:"http_address,omitempty" comment:"Address clean serves Prometheus metrics, health and status on, such as 127.0.0.1:9464, empty disables it"`
+	// SocketPath is the Unix socket clean answers status and control requests on.
+	SocketPath string `json:"socket_path,omitempty" yaml:"socket_path,omitempty" toml:"socket_path,omitempty" comment:"Unix socket clean answers the status and ctl commands on, defaults to fileCleanup.sock in $XDG_RUNTIME_DIR or the configuration directory"`
+	// ControlToken enables the control API on HTTPAddress for requests
+	// carrying it as a bearer token. The socket never needs it.
+	ControlToken string `json:"control_token,omitempty" yaml:"control_token,omitempty" toml:"control_token,omitempty" comment:"Bearer token enabling the control API on http_address, such as ${FILECLEANUP_TOKEN}, empty serves it on the Unix socket only"`
+	// AuditLog enables the audit log of every deletion when set.
+	AuditLog *AuditLogConfig `json:"audit_log,omitempty" yaml:"audit_log,omitempty" toml:"audit_log,omitempty" comment:"JSON Lines log of every deletion, rotated separately from the log"`
+	// Webhooks are notified of finished runs and of problems.
+	Webhooks []WebhookConfig `json:"webhooks,omitempty" yaml:"webhooks,omitempty" toml:"webhooks,omitempty" comment:"URLs notified of finished runs, safety caps, deletion errors and folders left above their limit"`
+	// Email sends a daily digest of the cleanups when set.
+	Email *EmailConfig `json:"email,omitempty" yaml:"email,omitempty" toml:"email,omitempty" comment:"SMTP server and recipients of a daily digest of what every rule deleted, the folder sizes and the errors"`
+	// Defaults holds the settings every rule inherits unless it sets them itself.
+	Defaults *DeleteConfig `json:"defaults,omitempty" yaml:"defaults,omitempty" toml:"defaults,omitempty" comment:"Settings every rule inherits unless it sets them itself"`
+	// rawDefaults is the defaults block as written, inherited by the rules of
+	// included files.
+	rawDefaults map[string]any
+	// Warnings lists the unknown keys and in memory migrations found by
+	// LoadConfig.
+	Warnings []string `json:"-" yaml:"-" toml:"-"`
 }
 
-func initJsonData(file *os.File) {
+// DefaultConfig returns the starter configuration written for new users.
+func DefaultConfig() Config {
 	result := Config{}
-	result.LogFilePath = "/path/to/reference/folder"
+	result.Version = CurrentConfigVersion
+	result.LogFilePath = "~/.fileCleanup/logs"
 	result.IsDetailedLogEnabled = false
 	result.DeleteConfig = []DeleteConfig{
 		{
-			TargetFolder:                      result.LogFilePath,
+			Name:                              "example",
+			Enabled:                           true,
+			TargetFolder:                      "/path/to/reference/folder",
 			RetentionDays:                     5,
 			DeleteIntervalSeconds:             24 * 60 * 60,
 			MaxFolderSizeMB:                   1000,
@@ -70,15 +136,5 @@ func initJsonData(file *os.File) {
 			CheckSizeIntervalSecs:             12 * 60 * 60,
 		},
 	}
-	byteValue, err := json.MarshalIndent(result, "", "  ")
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	// Write back to file
-
-	_, err = file.Write(byteValue)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return result
 }
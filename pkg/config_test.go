@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottleWindowContains(t *testing.T) {
+	day := time.Date(2026, 3, 14, 0, 0, 0, 0, time.Local)
+	at := func(hour, minute int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+	tests := []struct {
+		start, end string
+		t          time.Time
+		want       bool
+	}{
+		{"08:00", "18:00", at(8, 0), true},
+		{"08:00", "18:00", at(12, 30), true},
+		{"08:00", "18:00", at(17, 59), true},
+		{"08:00", "18:00", at(18, 0), false},
+		{"08:00", "18:00", at(7, 59), false},
+		// Windows crossing midnight
+		{"22:00", "06:00", at(22, 0), true},
+		{"22:00", "06:00", at(23, 59), true},
+		{"22:00", "06:00", at(0, 0), true},
+		{"22:00", "06:00", at(5, 59), true},
+		{"22:00", "06:00", at(6, 0), false},
+		{"22:00", "06:00", at(12, 0), false},
+		{"22:00", "06:00", at(21, 59), false},
+		{"23:30", "00:30", at(0, 15), true},
+		{"23:30", "00:30", at(23, 45), true},
+		{"23:30", "00:30", at(0, 30), false},
+		// A window ending where it starts is empty
+		{"10:00", "10:00", at(10, 0), false},
+		{"9:05", "9:10", at(9, 7), true},
+	}
+	for _, test := range tests {
+		window := ThrottleWindow{Start: test.start, End: test.end}
+		got, err := window.Contains(test.t)
+		if err != nil {
+			t.Errorf("%s-%s: %v", test.start, test.end, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s-%s contains %s = %v, want %v", test.start, test.end, test.t.Format("15:04"), got, test.want)
+		}
+	}
+
+	for _, window := range []ThrottleWindow{{Start: "25:00", End: "06:00"}, {Start: "22:00", End: "6pm"}} {
+		if _, err := window.Contains(at(0, 0)); err == nil {
+			t.Errorf("%s-%s: expected an error", window.Start, window.End)
+		}
+	}
+}
+
+func TestDeleteRateLimits(t *testing.T) {
+	config := DeleteConfig{
+		MaxDeletesPerSecond:     100,
+		MaxDeleteBytesPerSecond: 1 << 30,
+		ThrottleWindows: []ThrottleWindow{
+			{Start: "08:00", End: "18:00", MaxDeletesPerSecond: 5, MaxDeleteBytesPerSecond: 1 << 20},
+			{Start: "12:00", End: "13:00", MaxDeletesPerSecond: 1},
+			{Start: "22:00", End: "02:00", MaxDeletesPerSecond: 50},
+		},
+	}
+	day := time.Date(2026, 3, 14, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		hour  int
+		files float64
+		bytes int64
+	}{
+		{7, 100, 1 << 30},
+		{9, 5, 1 << 20},
+		// The first matching window wins
+		{12, 5, 1 << 20},
+		{23, 50, 0},
+		{1, 50, 0},
+		{3, 100, 1 << 30},
+	}
+	for _, test := range tests {
+		files, bytes := config.DeleteRateLimits(day.Add(time.Duration(test.hour) * time.Hour))
+		if files != test.files || bytes != test.bytes {
+			t.Errorf("%02d:00: limits %v files/s and %d bytes/s, want %v and %d", test.hour, files, bytes, test.files, test.bytes)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleValue sets key of a rule to Value.
+type RuleValue struct {
+	Key   string
+	Value any
+}
+
+// ParseRuleValue parses a KEY=VALUE argument. The value is read as a YAML
+// scalar, so true, 5 and 36h become a boolean, a number and a string.
+func ParseRuleValue(arg string) (RuleValue, error) {
+	key, text, ok := strings.Cut(arg, "=")
+	if !ok {
+		return RuleValue{}, fmt.Errorf("invalid setting %q, use KEY=VALUE", arg)
+	}
+	key = strings.TrimSpace(key)
+	if err := CheckRuleKey(key); err != nil {
+		return RuleValue{}, err
+	}
+	var value any
+	if err := yaml.Unmarshal([]byte(text), &value); err != nil {
+		return RuleValue{}, fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	if value == nil {
+		value = ""
+	}
+	return RuleValue{Key: key, Value: value}, nil
+}
+
+// RuleKeys returns the keys a rule can set, in the order they are written.
+func RuleKeys() []string {
+	var keys []string
+	typ := reflect.TypeOf(DeleteConfig{})
+	for i := 0; i < typ.NumField(); i++ {
+		if name := tagName(typ.Field(i), "json"); name != "" && name != "-" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// CheckRuleKey returns an error when key is not a key of a rule.
+func CheckRuleKey(key string) error {
+	for _, known := range RuleKeys() {
+		if key == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown rule key %q", key)
+}
+
+// ConfigDocument is a configuration file opened for editing. JSON and YAML
+// files are edited in place, keeping the order of their keys and the comments
+// of YAML files. TOML and HCL files are rewritten from their values.
+type ConfigDocument struct {
+	Path   string
+	format string
+	// root holds JSON and YAML documents, raw the others.
+	root *yaml.Node
+	raw  map[string]any
+}
+
+// OpenConfigDocument reads the configuration file at path for editing.
+func OpenConfigDocument(path string) (*ConfigDocument, error) {
+	format, err := ConfigFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := &ConfigDocument{Path: path, format: format}
+	switch format {
+	case FormatJSON, FormatYAML:
+		var root yaml.Node
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return nil, fmt.Errorf("reading config %s: %w", path, err)
+		}
+		if root.Kind == 0 {
+			root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+		}
+		if root.Kind != yaml.DocumentNode || root.Content[0].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("reading config %s: the top level must be an object", path)
+		}
+		doc.root = &root
+	default:
+		v := viper.New()
+		v.SetConfigType(format)
+		if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("reading config %s: %w", path, err)
+		}
+		doc.raw = v.AllSettings()
+	}
+	return doc, nil
+}
+
+// PreservesComments reports whether saving keeps the comments of the file.
+func (d *ConfigDocument) PreservesComments() bool {
+	return d.root != nil
+}
+
+// AddRule appends a rule made of values to delete_config.
+func (d *ConfigDocument) AddRule(values []RuleValue) error {
+	values = sortRuleValues(values)
+	if d.root == nil {
+		rule := make(map[string]any, len(values))
+		for _, value := range values {
+			rule[value.Key] = value.Value
+		}
+		d.raw["delete_config"] = append(rawListOf(d.raw["delete_config"]), rule)
+		return nil
+	}
+
+	rules := mappingValue(d.root.Content[0], "delete_config")
+	if rules == nil {
+		rules = &yaml.Node{Kind: yaml.SequenceNode}
+		setMappingValue(d.root.Content[0], "delete_config", rules)
+	}
+	if rules.Kind != yaml.SequenceNode {
+		return errors.New("delete_config must be a list")
+	}
+	rule := &yaml.Node{Kind: yaml.MappingNode}
+	for _, value := range values {
+		node, err := valueNode(value.Value)
+		if err != nil {
+			return err
+		}
+		setMappingValue(rule, value.Key, node)
+	}
+	rules.Content = append(rules.Content, rule)
+	return nil
+}
+
+// EditRule sets values on the rule at index of delete_config and removes the
+// keys in unset.
+func (d *ConfigDocument) EditRule(index int, values []RuleValue, unset []string) error {
+	if d.root == nil {
+		rules := rawItems(d.raw["delete_config"])
+		if index < 0 || index >= len(rules) {
+			return fmt.Errorf("delete_config[%d] not found in %s", index, d.Path)
+		}
+		for _, value := range values {
+			rules[index][value.Key] = value.Value
+		}
+		for _, key := range unset {
+			delete(rules[index], key)
+		}
+		return nil
+	}
+
+	rule, err := d.ruleNode(index)
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		node, err := valueNode(value.Value)
+		if err != nil {
+			return err
+		}
+		setMappingValue(rule, value.Key, node)
+	}
+	for _, key := range unset {
+		removeMappingValue(rule, key)
+	}
+	return nil
+}
+
+// RemoveRule removes the rule at index of delete_config.
+func (d *ConfigDocument) RemoveRule(index int) error {
+	if d.root == nil {
+		rules := rawListOf(d.raw["delete_config"])
+		if index < 0 || index >= len(rules) {
+			return fmt.Errorf("delete_config[%d] not found in %s", index, d.Path)
+		}
+		d.raw["delete_config"] = append(rules[:index], rules[index+1:]...)
+		return nil
+	}
+
+	if _, err := d.ruleNode(index); err != nil {
+		return err
+	}
+	rules := mappingValue(d.root.Content[0], "delete_config")
+	rules.Content = append(rules.Content[:index], rules.Content[index+1:]...)
+	return nil
+}
+
+// Migrate upgrades the document to CurrentConfigVersion. JSON and YAML
+// documents only have the values the migrations changed replaced, so the
+// order of their keys and their comments are kept. It returns the version the
+// document was written in and a note for every change made.
+func (d *ConfigDocument) Migrate() (int, []string, error) {
+	if d.root == nil {
+		return migrateRaw(d.raw)
+	}
+	var raw map[string]any
+	if err := d.root.Content[0].Decode(&raw); err != nil {
+		return 0, nil, err
+	}
+	if raw == nil {
+		raw = make(map[string]any)
+	}
+	version, notes, err := migrateRaw(raw)
+	if err != nil || version == CurrentConfigVersion {
+		return version, notes, err
+	}
+	if err := syncMapping(d.root.Content[0], raw); err != nil {
+		return 0, nil, err
+	}
+	return version, notes, nil
+}
+
+// syncMapping makes mapping hold the values of raw. Keys missing from raw are
+// removed and changed values replaced in place. Keys new in raw, such as the
+// version of a version 1 file, are inserted at the top after $schema.
+func syncMapping(mapping *yaml.Node, raw map[string]any) error {
+	seen := make(map[string]bool, len(raw))
+	for i := 0; i+1 < len(mapping.Content); {
+		key := mapping.Content[i].Value
+		value, ok := raw[key]
+		if !ok {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			continue
+		}
+		seen[key] = true
+		if err := syncNode(mapping.Content[i+1], value); err != nil {
+			return err
+		}
+		i += 2
+	}
+
+	var added []string
+	for key := range raw {
+		if !seen[key] {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+	at := 0
+	if len(mapping.Content) > 0 && mapping.Content[0].Value == "$schema" {
+		at = 2
+	}
+	// The comment above the first key describes the file and stays on top
+	var headComment string
+	if at == 0 && len(added) > 0 && len(mapping.Content) > 0 {
+		headComment, mapping.Content[0].HeadComment = mapping.Content[0].HeadComment, ""
+	}
+	for _, key := range added {
+		node, err := valueNode(raw[key])
+		if err != nil {
+			return err
+		}
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key, HeadComment: headComment}
+		headComment = ""
+		mapping.Content = slices.Insert(mapping.Content, at, keyNode, node)
+		at += 2
+	}
+	return nil
+}
+
+// syncNode makes node hold value, descending into mappings and lists of the
+// same length so only the values that changed lose their formatting.
+func syncNode(node *yaml.Node, value any) error {
+	var current any
+	if err := node.Decode(&current); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(current, value) {
+		return nil
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		if node.Kind == yaml.MappingNode {
+			return syncMapping(node, value)
+		}
+	case []any:
+		if node.Kind == yaml.SequenceNode && len(node.Content) == len(value) {
+			for i, item := range value {
+				if err := syncNode(node.Content[i], item); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	replacement, err := valueNode(value)
+	if err != nil {
+		return err
+	}
+	replacement.HeadComment, replacement.LineComment, replacement.FootComment = node.HeadComment, node.LineComment, node.FootComment
+	*node = *replacement
+	return nil
+}
+
+func (d *ConfigDocument) ruleNode(index int) (*yaml.Node, error) {
+	rules := mappingValue(d.root.Content[0], "delete_config")
+	if rules == nil || rules.Kind != yaml.SequenceNode || index < 0 || index >= len(rules.Content) {
+		return nil, fmt.Errorf("delete_config[%d] not found in %s", index, d.Path)
+	}
+	if rules.Content[index].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("delete_config[%d] in %s is not an object", index, d.Path)
+	}
+	return rules.Content[index], nil
+}
+
+// Bytes encodes the document in its format.
+func (d *ConfigDocument) Bytes() ([]byte, error) {
+	switch {
+	case d.root == nil:
+		return marshalRaw(d.raw, d.format)
+	case d.format == FormatJSON:
+		var buf bytes.Buffer
+		if err := writeJSONNode(&buf, d.root.Content[0], ""); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	default:
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(d.root); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// Save writes the document and checks that the configuration loaded from
+// mainPath, which includes the document, is still valid. An invalid result
+// is rolled back and returned as an error.
+func (d *ConfigDocument) Save(mainPath string) error {
+	data, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	original, err := os.ReadFile(d.Path)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(d.Path, data); err != nil {
+		return err
+	}
+
+	config, err := LoadConfig(mainPath)
+	if err == nil {
+		err = ValidateFile(mainPath, config)
+	}
+	if err != nil {
+		if restoreErr := writeFileAtomic(d.Path, original); restoreErr != nil {
+			return fmt.Errorf("%w\nrestoring %s: %v", err, d.Path, restoreErr)
+		}
+		return fmt.Errorf("change not saved, the configuration would be invalid:\n%w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data, so a running daemon never reads a
+// half written file.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if stat, err := os.Stat(path); err == nil {
+		mode = stat.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// sortRuleValues orders values like the fields of DeleteConfig.
+func sortRuleValues(values []RuleValue) []RuleValue {
+	var sorted []RuleValue
+	for _, key := range RuleKeys() {
+		for _, value := range values {
+			if value.Key == key {
+				sorted = append(sorted, value)
+			}
+		}
+	}
+	return sorted
+}
+
+func rawListOf(value any) []any {
+	switch list := value.(type) {
+	case []any:
+		return list
+	case []map[string]any:
+		result := make([]any, len(list))
+		for i, item := range list {
+			result[i] = item
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			// Keep the comments written next to the old value
+			value.LineComment = mapping.Content[i+1].LineComment
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func removeMappingValue(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func valueNode(value any) (*yaml.Node, error) {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// writeJSONNode writes a node parsed from a JSON file back as JSON, keeping
+// the order of its keys.
+func writeJSONNode(buf *bytes.Buffer, node *yaml.Node, indent string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, _ := json.Marshal(node.Content[i].Value)
+			fmt.Fprintf(buf, "%s  %s: ", indent, key)
+			if err := writeJSONNode(buf, node.Content[i+1], indent+"  "); err != nil {
+				return err
+			}
+			if i+2 < len(node.Content) {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "}")
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range node.Content {
+			buf.WriteString(indent + "  ")
+			if err := writeJSONNode(buf, item, indent+"  "); err != nil {
+				return err
+			}
+			if i+1 < len(node.Content) {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "]")
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!int", "!!float":
+			if _, err := strconv.ParseFloat(node.Value, 64); err != nil {
+				return fmt.Errorf("invalid number %q", node.Value)
+			}
+			buf.WriteString(node.Value)
+		case "!!bool":
+			buf.WriteString(strings.ToLower(node.Value))
+		case "!!null":
+			buf.WriteString("null")
+		default:
+			value, err := json.Marshal(node.Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(value)
+		}
+	default:
+		return fmt.Errorf("unsupported value at line %d", node.Line)
+	}
+	return nil
+}
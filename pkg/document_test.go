@@ -0,0 +1,246 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const documentYAML = `# FileCleanup of the web servers
+version: 2
+delete_config:
+  # uploads are kept for two weeks
+  - name: uploads
+    target_folder: UPLOADS
+    retention_days: 2w # agreed with the web team
+    max_folder_size_mb: 10GiB
+    delete_interval_seconds: 1h
+    check_size_interval_secs: 1h
+    scan_concurrency: 4
+`
+
+const documentJSON = `{
+  "version": 2,
+  "delete_config": [
+    {
+      "name": "uploads",
+      "target_folder": "UPLOADS",
+      "retention_days": 14,
+      "max_folder_size_mb": 10240,
+      "delete_interval_seconds": 3600,
+      "check_size_interval_secs": 3600
+    }
+  ]
+}
+`
+
+// openDocument writes content, with UPLOADS replaced by an existing folder, to
+// a file called name and opens it. It returns a second existing folder for
+// new rules.
+func openDocument(t *testing.T, name, content string) (*ConfigDocument, string, string) {
+	t.Helper()
+	uploads, cache := t.TempDir(), t.TempDir()
+	path := writeConfigAs(t, name, strings.ReplaceAll(content, "UPLOADS", uploads))
+	doc, err := OpenConfigDocument(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc, uploads, cache
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestConfigDocumentRoundTrip(t *testing.T) {
+	for _, name := range []string{"config.yaml", "config.json"} {
+		content := documentYAML
+		if name == "config.json" {
+			content = documentJSON
+		}
+		t.Run(name, func(t *testing.T) {
+			doc, _, _ := openDocument(t, name, content)
+			original := readFile(t, doc.Path)
+			data, err := doc.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != original {
+				t.Errorf("unchanged document encoded as\n%s\nwant\n%s", data, original)
+			}
+		})
+	}
+}
+
+func TestConfigDocumentEditYAML(t *testing.T) {
+	doc, uploads, cache := openDocument(t, "config.yaml", documentYAML)
+	if !doc.PreservesComments() {
+		t.Fatal("YAML documents preserve comments")
+	}
+
+	// The keys of the new rule are ordered like the fields of DeleteConfig
+	if err := doc.AddRule([]RuleValue{
+		{Key: "retention_days", Value: "1d"},
+		{Key: "target_folder", Value: cache},
+		{Key: "max_folder_size_mb", Value: 500},
+		{Key: "delete_interval_seconds", Value: "1h"},
+		{Key: "check_size_interval_secs", Value: "1h"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.EditRule(0, []RuleValue{{Key: "retention_days", Value: "3w"}, {Key: "description", Value: "user uploads"}}, []string{"scan_concurrency"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Save(doc.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# FileCleanup of the web servers
+version: 2
+delete_config:
+  # uploads are kept for two weeks
+  - name: uploads
+    target_folder: ` + uploads + `
+    retention_days: 3w # agreed with the web team
+    max_folder_size_mb: 10GiB
+    delete_interval_seconds: 1h
+    check_size_interval_secs: 1h
+    description: user uploads
+  - target_folder: ` + cache + `
+    retention_days: 1d
+    delete_interval_seconds: 1h
+    max_folder_size_mb: 500
+    check_size_interval_secs: 1h
+`
+	if got := readFile(t, doc.Path); got != want {
+		t.Errorf("saved\n%s\nwant\n%s", got, want)
+	}
+
+	doc, err := OpenConfigDocument(doc.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.RemoveRule(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Save(doc.Path); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, doc.Path); strings.Contains(got, cache) || !strings.Contains(got, "# uploads are kept for two weeks") {
+		t.Errorf("after removing the second rule\n%s", got)
+	}
+}
+
+func TestConfigDocumentEditJSON(t *testing.T) {
+	doc, uploads, cache := openDocument(t, "config.json", documentJSON)
+	if err := doc.AddRule([]RuleValue{
+		{Key: "target_folder", Value: cache},
+		{Key: "retention_days", Value: 1},
+		{Key: "max_folder_size_mb", Value: 500},
+		{Key: "delete_interval_seconds", Value: 60},
+		{Key: "check_size_interval_secs", Value: 60},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.EditRule(0, []RuleValue{{Key: "retention_days", Value: 21}}, []string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.RemoveRule(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.AddRule([]RuleValue{
+		{Key: "target_folder", Value: cache},
+		{Key: "enabled", Value: false},
+		{Key: "retention_days", Value: "1d"},
+		{Key: "max_folder_size_mb", Value: 500},
+		{Key: "delete_interval_seconds", Value: 60},
+		{Key: "check_size_interval_secs", Value: 60},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Save(doc.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "version": 2,
+  "delete_config": [
+    {
+      "target_folder": "` + uploads + `",
+      "retention_days": 21,
+      "max_folder_size_mb": 10240,
+      "delete_interval_seconds": 3600,
+      "check_size_interval_secs": 3600
+    },
+    {
+      "enabled": false,
+      "target_folder": "` + cache + `",
+      "retention_days": "1d",
+      "delete_interval_seconds": 60,
+      "max_folder_size_mb": 500,
+      "check_size_interval_secs": 60
+    }
+  ]
+}
+`
+	if got := readFile(t, doc.Path); got != want {
+		t.Errorf("saved\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestConfigDocumentInvalidEditLeavesFileUnchanged(t *testing.T) {
+	for _, name := range []string{"config.yaml", "config.json"} {
+		content := documentYAML
+		if name == "config.json" {
+			content = documentJSON
+		}
+		t.Run(name, func(t *testing.T) {
+			doc, uploads, _ := openDocument(t, name, content)
+			original := readFile(t, doc.Path)
+
+			// A second rule for the same folder
+			if err := doc.AddRule([]RuleValue{
+				{Key: "target_folder", Value: filepath.Join(uploads, ".")},
+				{Key: "retention_days", Value: 1},
+				{Key: "max_folder_size_mb", Value: 500},
+				{Key: "delete_interval_seconds", Value: 60},
+				{Key: "check_size_interval_secs", Value: 60},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := doc.Save(doc.Path); err == nil || !strings.Contains(err.Error(), "already used by") {
+				t.Errorf("got %v, want the folder conflict", err)
+			}
+			if got := readFile(t, doc.Path); got != original {
+				t.Errorf("file changed to\n%s", got)
+			}
+
+			doc, err := OpenConfigDocument(doc.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := doc.EditRule(0, []RuleValue{{Key: "retention_days", Value: 0}}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := doc.Save(doc.Path); err == nil || !strings.Contains(err.Error(), "retention_days: must be positive") {
+				t.Errorf("got %v, want the invalid retention", err)
+			}
+			if got := readFile(t, doc.Path); got != original {
+				t.Errorf("file changed to\n%s", got)
+			}
+
+			if err := doc.EditRule(1, []RuleValue{{Key: "retention_days", Value: 1}}, nil); err == nil {
+				t.Error("expected an error editing a missing rule")
+			}
+			if err := doc.RemoveRule(-1); err == nil {
+				t.Error("expected an error removing a missing rule")
+			}
+		})
+	}
+}
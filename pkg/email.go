@@ -0,0 +1,217 @@
+package pkg
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"net"
+	"net/mail"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// TLS modes of the SMTP connection.
+const (
+	// EmailTLSAuto upgrades the connection with STARTTLS when the server
+	// offers it.
+	EmailTLSAuto = "auto"
+	// EmailTLSStartTLS fails when the server does not offer STARTTLS.
+	EmailTLSStartTLS = "starttls"
+	// EmailTLSImplicit connects with TLS from the start, usually on port 465.
+	EmailTLSImplicit = "tls"
+	EmailTLSNone     = "none"
+)
+
+// EmailConfig sends a digest of the cleanups by email once a day.
+type EmailConfig struct {
+	Host     string   `json:"host" yaml:"host" toml:"host" comment:"SMTP server" schema:"required"`
+	Port     int      `json:"port,omitempty" yaml:"port,omitempty" toml:"port,omitempty" comment:"Port of the SMTP server, defaults to 465 with tls set to tls and 587 otherwise" schema:"minimum=0,maximum=65535"`
+	TLS      string   `json:"tls,omitempty" yaml:"tls,omitempty" toml:"tls,omitempty" comment:"auto upgrades with STARTTLS when offered, starttls requires it, tls connects with TLS, none never encrypts; defaults to auto" schema:"enum=auto|starttls|tls|none"`
+	Username string   `json:"username,omitempty" yaml:"username,omitempty" toml:"username,omitempty" comment:"User to authenticate as, empty sends without authentication"`
+	Password string   `json:"password,omitempty" yaml:"password,omitempty" toml:"password,omitempty" comment:"Password of the user, such as ${SMTP_PASSWORD}"`
+	From     string   `json:"from" yaml:"from" toml:"from" comment:"Sender address" schema:"required"`
+	To       []string `json:"to" yaml:"to" toml:"to" comment:"Recipient addresses" schema:"required"`
+	// InsecureSkipVerify accepts any certificate, for test servers.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty" toml:"insecure_skip_verify,omitempty" comment:"Accept any certificate of the server, only for test servers"`
+	// SendAt is the time of day the digest is sent, covering the day since
+	// the previous one.
+	SendAt    string `json:"send_at,omitempty" yaml:"send_at,omitempty" toml:"send_at,omitempty" comment:"Time of day the digest is sent, HH:MM local time, defaults to 07:00" schema:"pattern=^([01]?\\d|2[0-3]):[0-5]\\d$"`
+	SkipEmpty bool   `json:"skip_empty,omitempty" yaml:"skip_empty,omitempty" toml:"skip_empty,omitempty" comment:"Do not send a digest when nothing was deleted and nothing failed"`
+	Subject   string `json:"subject,omitempty" yaml:"subject,omitempty" toml:"subject,omitempty" comment:"Go template of the subject" expand:"-"`
+	// TextTemplateFile and HTMLTemplateFile replace the built in bodies.
+	TextTemplateFile string `json:"text_template_file,omitempty" yaml:"text_template_file,omitempty" toml:"text_template_file,omitempty" comment:"File holding the Go template of the plain text body"`
+	HTMLTemplateFile string `json:"html_template_file,omitempty" yaml:"html_template_file,omitempty" toml:"html_template_file,omitempty" comment:"File holding the Go template of the HTML body"`
+}
+
+// DefaultDigestTime is when the digest is sent when send_at is not set.
+const DefaultDigestTime = "07:00"
+
+// The built in templates of the digest. A digest holds Host, Since, Until,
+// the totals DeletedFiles, ReclaimedBytes and Errors, and Rules with the
+// same totals per rule along with Name, TargetFolder, Runs, Paused,
+// FolderBytes, LimitBytes, AboveLimit and ErrorMessages.
+const (
+	defaultDigestSubject = `FileCleanup on {{.Host}}: {{.DeletedFiles}} files deleted, {{bytes .ReclaimedBytes}} freed{{if .Errors}}, {{.Errors}} errors{{end}}`
+
+	defaultDigestText = `FileCleanup digest for {{.Host}}
+{{.Since.Format "2006-01-02 15:04"}} to {{.Until.Format "2006-01-02 15:04"}}
+{{range .Rules}}
+{{.Name}} - {{.TargetFolder}}{{if .Paused}} (paused){{end}}
+  Deleted: {{.DeletedFiles}} files, {{bytes .ReclaimedBytes}} in {{.Runs}} runs
+  Size:    {{bytes .FolderBytes}}{{if .LimitBytes}} of {{bytes .LimitBytes}} ({{percent .FolderBytes .LimitBytes}}%){{end}}{{if .AboveLimit}} ABOVE THE LIMIT{{end}}
+{{- if .Errors}}
+  Errors:  {{.Errors}}
+{{- range .ErrorMessages}}
+    {{.}}
+{{- end}}
+{{- end}}
+{{end}}
+Total: {{.DeletedFiles}} files deleted, {{bytes .ReclaimedBytes}} freed, {{.Errors}} errors
+`
+
+	defaultDigestHTML = `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h2>FileCleanup digest for {{.Host}}</h2>
+<p>{{.Since.Format "2006-01-02 15:04"}} to {{.Until.Format "2006-01-02 15:04"}}:
+<b>{{.DeletedFiles}}</b> files deleted, <b>{{bytes .ReclaimedBytes}}</b> freed, <b>{{.Errors}}</b> errors.</p>
+<table cellpadding="6" style="border-collapse: collapse">
+<tr style="text-align: left; border-bottom: 1px solid #ccc"><th>Rule</th><th>Target folder</th><th>Runs</th><th>Deleted</th><th>Freed</th><th>Size</th><th>Limit</th><th>Errors</th></tr>
+{{- range .Rules}}
+<tr style="border-bottom: 1px solid #eee">
+<td>{{.Name}}{{if .Paused}} (paused){{end}}</td><td>{{.TargetFolder}}</td><td>{{.Runs}}</td><td>{{.DeletedFiles}}</td><td>{{bytes .ReclaimedBytes}}</td>
+<td{{if .AboveLimit}} style="color: #c62828; font-weight: bold"{{end}}>{{bytes .FolderBytes}}{{if .LimitBytes}} ({{percent .FolderBytes .LimitBytes}}%){{end}}</td><td>{{if .LimitBytes}}{{bytes .LimitBytes}}{{else}}none{{end}}</td>
+<td{{if .Errors}} style="color: #c62828"{{end}}>{{.Errors}}</td>
+</tr>
+{{- end}}
+</table>
+{{- range .Rules}}{{if .ErrorMessages}}
+<h3>Errors of {{.Name}}</h3>
+<ul>
+{{- range .ErrorMessages}}
+<li><code>{{.}}</code></li>
+{{- end}}
+</ul>
+{{- end}}{{end}}
+</body>
+</html>
+`
+)
+
+// subjectTemplate returns the template of the subject of the digest.
+func (e EmailConfig) subjectTemplate() (*template.Template, error) {
+	text := e.Subject
+	if text == "" {
+		text = defaultDigestSubject
+	}
+	return template.New("subject").Funcs(TemplateFuncs).Parse(text)
+}
+
+// EmailTemplates returns the templates of the subject and the bodies of the
+// digest. The template files are read on every call, so they are only
+// checked when a digest is sent.
+func (e EmailConfig) EmailTemplates() (*template.Template, *template.Template, *htmltemplate.Template, error) {
+	subject, err := e.subjectTemplate()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("subject: %w", err)
+	}
+	textBody, htmlBody := defaultDigestText, defaultDigestHTML
+	if e.TextTemplateFile != "" {
+		data, err := os.ReadFile(e.TextTemplateFile)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("text_template_file: %w", err)
+		}
+		textBody = string(data)
+	}
+	if e.HTMLTemplateFile != "" {
+		data, err := os.ReadFile(e.HTMLTemplateFile)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("html_template_file: %w", err)
+		}
+		htmlBody = string(data)
+	}
+	text, err := template.New("text").Funcs(TemplateFuncs).Parse(textBody)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("text template: %w", err)
+	}
+	html, err := htmltemplate.New("html").Funcs(htmltemplate.FuncMap(TemplateFuncs)).Parse(htmlBody)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("HTML template: %w", err)
+	}
+	return subject, text, html, nil
+}
+
+// TLSMode returns the TLS mode, auto when not set.
+func (e EmailConfig) TLSMode() string {
+	if e.TLS == "" {
+		return EmailTLSAuto
+	}
+	return e.TLS
+}
+
+// Address returns the host and port of the SMTP server.
+func (e EmailConfig) Address() string {
+	port := e.Port
+	if port == 0 {
+		port = 587
+		if e.TLSMode() == EmailTLSImplicit {
+			port = 465
+		}
+	}
+	return net.JoinHostPort(e.Host, strconv.Itoa(port))
+}
+
+// NextDigest returns the first time after now the digest is sent.
+func (e EmailConfig) NextDigest(now time.Time) (time.Time, error) {
+	sendAt := e.SendAt
+	if sendAt == "" {
+		sendAt = DefaultDigestTime
+	}
+	at, err := time.Parse("15:04", sendAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid send_at %q: %w", e.SendAt, err)
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, nil
+}
+
+// problems adds the problems of the email block.
+func (e EmailConfig) problems(add func(path, format string, args ...any)) {
+	if e.Host == "" {
+		add("email.host", "is required")
+	}
+	if e.Port < 0 || e.Port > 65535 {
+		add("email.port", "must be between 0 and 65535")
+	}
+	switch e.TLS {
+	case "", EmailTLSAuto, EmailTLSStartTLS, EmailTLSImplicit, EmailTLSNone:
+	default:
+		add("email.tls", "unknown mode %q, expected auto, starttls, tls or none", e.TLS)
+	}
+	if e.From == "" {
+		add("email.from", "is required")
+	} else if _, err := mail.ParseAddress(e.From); err != nil {
+		add("email.from", "%v", err)
+	}
+	if len(e.To) == 0 {
+		add("email.to", "at least one recipient is required")
+	}
+	for i, to := range e.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			add(fmt.Sprintf("email.to[%d]", i), "%v", err)
+		}
+	}
+	if e.Password != "" && e.Username == "" {
+		add("email.password", "has no effect without username")
+	}
+	if _, err := e.NextDigest(time.Now()); err != nil {
+		add("email.send_at", "%v", err)
+	}
+	if _, err := e.subjectTemplate(); err != nil {
+		add("email.subject", "%v", err)
+	}
+}
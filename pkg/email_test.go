@@ -0,0 +1,29 @@
+package pkg
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func emailProblems(e EmailConfig) []string {
+	var problems []string
+	e.problems(func(path, format string, args ...any) {
+		problems = append(problems, path)
+	})
+	return problems
+}
+
+func TestEmailProblemsDoNotReadTemplateFiles(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.tmpl")
+	e := EmailConfig{Host: "smtp.example.com", From: "cleanup@example.com", To: []string{"ops@example.com"},
+		TextTemplateFile: missing, HTMLTemplateFile: missing}
+	if problems := emailProblems(e); len(problems) != 0 {
+		t.Errorf("problems %v, template files are only read when sending", problems)
+	}
+
+	e.Subject = "{{.Host"
+	if problems := emailProblems(e); strings.Join(problems, ",") != "email.subject" {
+		t.Errorf("problems %v, want email.subject", problems)
+	}
+}
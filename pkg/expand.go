@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix prefixes the environment variables overriding scalar settings,
+// such as FILECLEANUP_LOG_FILE_PATH for log_file_path.
+const EnvPrefix = "FILECLEANUP"
+
+// builtinVariables are expanded before the environment is consulted.
+var builtinVariables = map[string]func() (string, error){
+	"hostname": os.Hostname,
+	"date": func() (string, error) {
+		return time.Now().Format("2006-01-02"), nil
+	},
+}
+
+var variablePattern = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+// ExpandValue expands ${hostname}, ${date} and ${ENV_VAR} references and a
+// leading ~ in s. $$ stands for a literal $. An undefined variable is an error
+// rather than an empty string, so a typo cannot turn into a wrong path, unless
+// a default is given as ${ENV_VAR:-default}.
+func ExpandValue(s string) (string, error) {
+	var expandErr error
+	result := variablePattern.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		name := match[2 : len(match)-1]
+		if name, fallback, ok := strings.Cut(name, ":-"); ok {
+			if value := os.Getenv(name); value != "" {
+				return value
+			}
+			return fallback
+		}
+		if builtin, ok := builtinVariables[name]; ok {
+			value, err := builtin()
+			if err != nil && expandErr == nil {
+				expandErr = fmt.Errorf("expanding ${%s}: %w", name, err)
+			}
+			return value
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok && expandErr == nil {
+			expandErr = fmt.Errorf("undefined variable ${%s}", name)
+		}
+		return value
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+
+	if result == "~" || strings.HasPrefix(result, "~/") || strings.HasPrefix(result, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("expanding ~: %w", err)
+		}
+		result = filepath.Join(home, result[1:])
+	}
+	return result, nil
+}
+
+// expandConfig expands every string value of config in place, except those of
+// fields tagged expand:"-" such as Go templates, whose own syntax may contain
+// ${...} or $$.
+func expandConfig(config *Config) error {
+	return expandStrings(reflect.ValueOf(config).Elem(), "")
+}
+
+func expandStrings(value reflect.Value, path string) error {
+	switch value.Kind() {
+	case reflect.String:
+		expanded, err := ExpandValue(value.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		value.SetString(expanded)
+	case reflect.Pointer:
+		if !value.IsNil() {
+			return expandStrings(value.Elem(), path)
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			if err := expandStrings(value.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			name := tagName(field, "json")
+			if name == "" || name == "-" || field.Tag.Get("expand") == "-" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := expandStrings(value.Field(i), name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bindEnvOverrides lets FILECLEANUP_<KEY> environment variables override the
+// top level scalar settings of the configuration.
+func bindEnvOverrides(v *viper.Viper) error {
+	v.SetEnvPrefix(EnvPrefix)
+	v.AutomaticEnv()
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := tagName(field, "json")
+		if name == "" || name == "-" || field.Type.Kind() == reflect.Slice || field.Type.Kind() == reflect.Pointer {
+			continue
+		}
+		if err := v.BindEnv(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandValue(t *testing.T) {
+	t.Setenv("FC_TEST_ROOT", "/srv/data")
+	t.Setenv("FC_TEST_EMPTY", "")
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"/var/log/app", "/var/log/app", true},
+		{"${FC_TEST_ROOT}/uploads", "/srv/data/uploads", true},
+		{"${FC_TEST_ROOT}/${hostname}", "/srv/data/" + hostname, true},
+		{"logs-${date}", "logs-" + time.Now().Format("2006-01-02"), true},
+		{"${FC_TEST_UNDEFINED}/uploads", "", false},
+		{"${FC_TEST_UNDEFINED:-/srv/default}/uploads", "/srv/default/uploads", true},
+		{"${FC_TEST_ROOT:-/srv/default}", "/srv/data", true},
+		{"${FC_TEST_EMPTY:-/srv/default}", "/srv/default", true},
+		{"${FC_TEST_UNDEFINED:-}", "", true},
+		{"${FC_TEST_EMPTY}", "", true},
+		{"price $$5", "price $5", true},
+		{"$${FC_TEST_UNDEFINED}", "${FC_TEST_UNDEFINED}", true},
+		{"$HOME", "$HOME", true},
+		{"~", home, true},
+		{"~/logs", filepath.Join(home, "logs"), true},
+		{"/data/~/logs", "/data/~/logs", true},
+		{"~user/logs", "~user/logs", true},
+	}
+	for _, test := range tests {
+		got, err := ExpandValue(test.in)
+		if test.ok && (err != nil || got != test.want) {
+			t.Errorf("ExpandValue(%q) = %q, %v, want %q", test.in, got, err, test.want)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ExpandValue(%q) = %q, want an error", test.in, got)
+		}
+	}
+}
+
+func TestLoadConfigExpandsValues(t *testing.T) {
+	t.Setenv("FC_TEST_ROOT", "/srv/data")
+	path := writeConfig(t, `version: 2
+log_file_path: ${FC_TEST_ROOT}/logs
+delete_config:
+  - target_folder: ${FC_TEST_ROOT:-/srv}/uploads
+webhooks:
+  - url: https://example.com/${FC_TEST_ROOT}
+    template: '{{with $x := .Rule}}${{$x}}{{end}}'
+email:
+  host: smtp.example.com
+  from: cleanup@example.com
+  to: [ops@example.com]
+  subject: 'Cleanup costs ${{.DeletedFiles}}'
+`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.LogFilePath != "/srv/data/logs" || config.DeleteConfig[0].TargetFolder != "/srv/data/uploads" {
+		t.Errorf("paths not expanded: %s, %s", config.LogFilePath, config.DeleteConfig[0].TargetFolder)
+	}
+	if config.Webhooks[0].URL != "https://example.com//srv/data" {
+		t.Errorf("url not expanded: %s", config.Webhooks[0].URL)
+	}
+	// Templates are left to the template engine
+	if config.Webhooks[0].Template != "{{with $x := .Rule}}${{$x}}{{end}}" {
+		t.Errorf("webhook template expanded to %q", config.Webhooks[0].Template)
+	}
+	if config.Email.Subject != "Cleanup costs ${{.DeletedFiles}}" {
+		t.Errorf("email subject expanded to %q", config.Email.Subject)
+	}
+
+	path = writeConfig(t, "version: 2\nlog_file_path: ${FC_TEST_UNDEFINED}/logs\n")
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected an error for the undefined variable")
+	}
+}
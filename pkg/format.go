@@ -0,0 +1,210 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported configuration file formats.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+	FormatHCL  = "hcl"
+)
+
+// ConfigFormats lists the supported formats in the order default configuration
+// files are looked up.
+var ConfigFormats = []string{FormatJSON, FormatYAML, FormatTOML, FormatHCL}
+
+// ConfigFormat returns the format of the configuration file at path, detected
+// by its extension.
+func ConfigFormat(path string) (string, error) {
+	switch ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")); ext {
+	case "json":
+		return FormatJSON, nil
+	case "yaml", "yml":
+		return FormatYAML, nil
+	case "toml":
+		return FormatTOML, nil
+	case "hcl":
+		return FormatHCL, nil
+	default:
+		return "", fmt.Errorf("unsupported config file extension %q, use one of .json, .yaml, .yml, .toml or .hcl", filepath.Ext(path))
+	}
+}
+
+// MarshalConfig encodes config in format. JSON starts with a $schema reference
+// to the published schema, every other format carries the comment tag of each
+// key as a comment above it.
+func MarshalConfig(config Config, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		data, err := json.MarshalIndent(struct {
+			Schema string `json:"$schema"`
+			Config
+		}{SchemaURL, config}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FormatYAML:
+		return marshalYAML(config)
+	case FormatTOML:
+		return toml.Marshal(config)
+	case FormatHCL:
+		return marshalHCL(config), nil
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+func marshalYAML(config Config) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(config); err != nil {
+		return nil, err
+	}
+	commentYAML(&node, reflect.TypeOf(config))
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// commentYAML sets the comment tag of every struct field as the head comment
+// of its key.
+func commentYAML(node *yaml.Node, typ reflect.Type) {
+	for typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			commentYAML(child, typ)
+		}
+	case yaml.MappingNode:
+		if typ.Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			field, ok := fieldByTag(typ, "yaml", node.Content[i].Value)
+			if !ok {
+				continue
+			}
+			node.Content[i].HeadComment = field.Tag.Get("comment")
+			commentYAML(node.Content[i+1], field.Type)
+		}
+	}
+}
+
+// fieldByTag finds the field of typ whose tag key names name.
+func fieldByTag(typ reflect.Type, key, name string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if tagName(field, key) == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func tagName(field reflect.StructField, key string) string {
+	name, _, _ := strings.Cut(field.Tag.Get(key), ",")
+	return name
+}
+
+func tagHasOption(field reflect.StructField, key, option string) bool {
+	_, options, _ := strings.Cut(field.Tag.Get(key), ",")
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalHCL writes config as HCL, using the json tags as keys and repeated
+// blocks for lists of structs.
+func marshalHCL(config Config) []byte {
+	var buf bytes.Buffer
+	writeHCLBody(&buf, reflect.ValueOf(config), "")
+	return buf.Bytes()
+}
+
+func writeHCLBody(buf *bytes.Buffer, value reflect.Value, indent string) {
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := tagName(field, "json")
+		if name == "" || name == "-" {
+			continue
+		}
+		fieldValue := value.Field(i)
+		if tagHasOption(field, "json", "omitempty") && fieldValue.IsZero() {
+			continue
+		}
+		if comment := field.Tag.Get("comment"); comment != "" {
+			fmt.Fprintf(buf, "%s# %s\n", indent, comment)
+		}
+		if fieldValue.Kind() == reflect.Pointer && fieldValue.Type().Elem().Kind() == reflect.Struct {
+			if !fieldValue.IsNil() {
+				fmt.Fprintf(buf, "%s%s {\n", indent, name)
+				writeHCLBody(buf, fieldValue.Elem(), indent+"  ")
+				fmt.Fprintf(buf, "%s}\n", indent)
+			}
+			continue
+		}
+		if fieldValue.Kind() == reflect.Slice && fieldValue.Type().Elem().Kind() == reflect.Struct {
+			for j := 0; j < fieldValue.Len(); j++ {
+				fmt.Fprintf(buf, "%s%s {\n", indent, name)
+				writeHCLBody(buf, fieldValue.Index(j), indent+"  ")
+				fmt.Fprintf(buf, "%s}\n", indent)
+			}
+			continue
+		}
+		fmt.Fprintf(buf, "%s%s = %s\n", indent, name, hclValue(fieldValue))
+	}
+}
+
+func hclValue(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.String:
+		return strconv.Quote(value.String())
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'f', -1, 64)
+	case reflect.Interface, reflect.Pointer:
+		if value.IsNil() {
+			return `""`
+		}
+		return hclValue(value.Elem())
+	case reflect.Slice:
+		items := make([]string, value.Len())
+		for i := range items {
+			items[i] = hclValue(value.Index(i))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return strconv.Quote(fmt.Sprint(value.Interface()))
+	}
+}
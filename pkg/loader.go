@@ -0,0 +1,271 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// HomeEnv names the environment variable overriding the directory the
+// configuration file is looked up in.
+const HomeEnv = "FILECLEANUP_HOME"
+
+// ConfigDir returns the directory the default configuration file lives in:
+// $FILECLEANUP_HOME when set, ~/.fileCleanup when it exists, and otherwise
+// $XDG_CONFIG_HOME/fileCleanup when XDG_CONFIG_HOME is set. It does not
+// create the directory.
+func ConfigDir() (string, error) {
+	if dir := os.Getenv(HomeEnv); dir != "" {
+		return filepath.Abs(dir)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	legacy := filepath.Join(home, ".fileCleanup")
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, nil
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(xdg) {
+		return filepath.Join(xdg, "fileCleanup"), nil
+	}
+	return legacy, nil
+}
+
+// DefaultSocketPath returns the Unix socket clean answers status requests on
+// when socket_path is not set: fileCleanup.sock in $XDG_RUNTIME_DIR when set,
+// and otherwise in ConfigDir.
+func DefaultSocketPath() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "fileCleanup.sock"), nil
+	}
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fileCleanup.sock"), nil
+}
+
+// DefaultConfigFilePath returns the configuration file used when none is given,
+// the first existing .fileCleanup file of any supported format in ConfigDir,
+// falling back to JSON.
+func DefaultConfigFilePath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	for _, format := range ConfigFormats {
+		path := filepath.Join(dir, ".fileCleanup."+format)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return filepath.Join(dir, ".fileCleanup."+FormatJSON), nil
+}
+
+// DropInDirName is the directory next to the configuration file whose files
+// each add rules, so packages and teams can ship rules without editing the
+// main file.
+const DropInDirName = "conf.d"
+
+// LoadConfig reads the configuration file at path together with the files it
+// includes and the files of its conf.d directory. It is the single entry
+// point every command and the daemon's reload use to build a Config.
+//
+// Included files are merged in the order of the include patterns, each
+// pattern's matches sorted by name, followed by the conf.d files sorted by
+// name. They may only contain delete_config rules, and a target folder
+// configured by two files is an error.
+func LoadConfig(path string) (Config, error) {
+	config, err := loadConfigFile(path, nil)
+	if err != nil {
+		return Config{}, err
+	}
+	owners := make(map[string]string)
+	for i := range config.DeleteConfig {
+		config.DeleteConfig[i].Source = path
+		config.DeleteConfig[i].SourceIndex = i
+		if folder := config.DeleteConfig[i].TargetFolder; folder != "" {
+			owners[filepath.Clean(folder)] = path
+		}
+	}
+
+	files, err := ConfigSources(path, config)
+	if err != nil {
+		return Config{}, err
+	}
+	for _, file := range files[1:] {
+		dropIn, err := loadConfigFile(file, &config)
+		if err != nil {
+			return Config{}, err
+		}
+		config.Warnings = append(config.Warnings, dropIn.Warnings...)
+		if dropIn.LogFilePath != "" || dropIn.IsDetailedLogEnabled || dropIn.IndexFilePath != "" || len(dropIn.Include) > 0 || dropIn.Defaults != nil || dropIn.AuditLog != nil || dropIn.HTTPAddress != "" || dropIn.SocketPath != "" || dropIn.ControlToken != "" || len(dropIn.Webhooks) > 0 || dropIn.Email != nil {
+			return Config{}, fmt.Errorf("%s: included files may only contain delete_config", file)
+		}
+		for i, rule := range dropIn.DeleteConfig {
+			rule.Source = file
+			rule.SourceIndex = i
+			if rule.TargetFolder != "" {
+				folder := filepath.Clean(rule.TargetFolder)
+				if owner, ok := owners[folder]; ok {
+					return Config{}, fmt.Errorf("%s: delete_config[%d].target_folder %s is already configured in %s", file, i, rule.TargetFolder, owner)
+				}
+				owners[folder] = file
+			}
+			config.DeleteConfig = append(config.DeleteConfig, rule)
+		}
+	}
+	return config, nil
+}
+
+// ConfigSources returns the files the configuration at path is built from,
+// path first followed by the included and conf.d files in merge order.
+func ConfigSources(path string, config Config) ([]string, error) {
+	files := []string{path}
+	seen := map[string]bool{filepath.Clean(path): true}
+	add := func(file string) {
+		if !seen[filepath.Clean(file)] {
+			seen[filepath.Clean(file)] = true
+			files = append(files, file)
+		}
+	}
+
+	dir := filepath.Dir(path)
+	for _, pattern := range config.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %w", pattern, err)
+		}
+		if len(matches) == 0 && !hasGlobMeta(pattern) {
+			return nil, fmt.Errorf("include %s: no such file", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			add(match)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, DropInDirName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if _, err := ConfigFormat(entry.Name()); err != nil {
+			continue
+		}
+		add(filepath.Join(dir, DropInDirName, entry.Name()))
+	}
+	return files, nil
+}
+
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[`)
+}
+
+// loadConfigFile reads the single configuration file at path, fills its rules
+// from the defaults block and expands the variables of its values. main is
+// the configuration including the file, or nil for the main file; only the
+// main file takes the FILECLEANUP_* environment variables and included files
+// inherit its defaults.
+func loadConfigFile(path string, main *Config) (Config, error) {
+	format, err := ConfigFormat(path)
+	if err != nil {
+		return Config{}, err
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType(format)
+	if err := v.ReadInConfig(); err != nil {
+		return Config{}, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	raw := v.AllSettings()
+	version, notes, err := migrateRaw(raw)
+	if err != nil {
+		return Config{}, fmt.Errorf("migrating config %s: %w", path, err)
+	}
+	var warnings []string
+	if version < CurrentConfigVersion {
+		warnings = append(warnings, fmt.Sprintf("%s: version %d migrated in memory, run config migrate to update the file", path, version))
+	}
+	for _, note := range notes {
+		warnings = append(warnings, fmt.Sprintf("%s: %s", path, note))
+	}
+	for _, warning := range unknownKeys(raw, reflect.TypeOf(Config{}), "") {
+		warnings = append(warnings, fmt.Sprintf("%s: %s", path, warning))
+	}
+	defaults, err := rawDefaults(raw)
+	if err != nil {
+		return Config{}, fmt.Errorf("decoding config %s: %w", path, err)
+	}
+	if main != nil {
+		defaults = main.rawDefaults
+	}
+	for _, rule := range rawRules(raw) {
+		applyRuleDefaults(rule, defaults)
+	}
+	if err := normalizeRules(raw); err != nil {
+		return Config{}, fmt.Errorf("decoding config %s: %w", path, err)
+	}
+
+	// The migrated settings are decoded as the configuration of a new viper,
+	// so the FILECLEANUP_* variables still take precedence over them.
+	v = viper.New()
+	if err := v.MergeConfigMap(raw); err != nil {
+		return Config{}, fmt.Errorf("decoding config %s: %w", path, err)
+	}
+	if main == nil {
+		if err := bindEnvOverrides(v); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var config Config
+	if err := v.Unmarshal(&config, decoderOptions); err != nil {
+		return Config{}, fmt.Errorf("decoding config %s: %w", path, err)
+	}
+	if err := expandConfig(&config); err != nil {
+		return Config{}, fmt.Errorf("expanding config %s: %w", path, err)
+	}
+	config.Warnings = warnings
+	config.rawDefaults = defaults
+	return config, nil
+}
+
+// normalizeRules converts the human friendly durations and sizes of every rule
+// and of the defaults of raw into the numbers the configuration keys are
+// decoded from.
+func normalizeRules(raw map[string]any) error {
+	if defaults, _ := rawDefaults(raw); defaults != nil {
+		if err := normalizeUnits(defaults); err != nil {
+			return fmt.Errorf("defaults.%w", err)
+		}
+		raw["defaults"] = defaults
+	}
+	for i, rule := range rawRules(raw) {
+		if err := normalizeUnits(rule); err != nil {
+			return fmt.Errorf("delete_config[%d].%w", i, err)
+		}
+	}
+	return nil
+}
+
+// decoderOptions makes viper decode using the json struct tags, so the same
+// keys are used whichever way the configuration is read.
+func decoderOptions(config *mapstructure.DecoderConfig) {
+	config.TagName = "json"
+}
@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	return writeConfigAs(t, "config.yaml", content)
+}
+
+// writeConfigAs writes content to a file called name in a temporary folder.
+func writeConfigAs(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigEnvOverridesFile(t *testing.T) {
+	path := writeConfig(t, `version: 2
+log_file_path: /from/file
+delete_config:
+  - target_folder: /tmp/target
+    retention_days: 2d
+    max_folder_size_mb: 1GiB
+    delete_interval_seconds: 1m
+    check_size_interval_secs: 1h
+`)
+	t.Setenv(EnvPrefix+"_LOG_FILE_PATH", "/from/env")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.LogFilePath != "/from/env" {
+		t.Errorf("log_file_path = %q, want the environment value /from/env", config.LogFilePath)
+	}
+	rule := config.DeleteConfig[0]
+	if rule.RetentionDays != 2 || rule.MaxFolderSizeMB != 1024 || rule.DeleteIntervalSeconds != 60 || rule.CheckSizeIntervalSecs != 3600 {
+		t.Errorf("units not normalized: %+v", rule)
+	}
+}
+
+func TestLoadConfigFileWithoutEnv(t *testing.T) {
+	path := writeConfig(t, `version: 2
+log_file_path: /from/file
+`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.LogFilePath != "/from/file" {
+		t.Errorf("log_file_path = %q, want /from/file", config.LogFilePath)
+	}
+}
+
+func TestLoadConfigRejectsValuesRoundingToZero(t *testing.T) {
+	for _, value := range []string{`max_folder_size_mb: 500KB`, `delete_interval_seconds: 400ms`} {
+		path := writeConfig(t, "version: 2\ndelete_config:\n  - target_folder: /tmp/target\n    "+value+"\n")
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+// writeDropIns writes the main configuration and the conf.d files of a
+// temporary folder, returning the path of the main file.
+func writeDropIns(t *testing.T, main string, dropIns map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range dropIns {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(main), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigMergesDropInsInOrder(t *testing.T) {
+	path := writeDropIns(t, `version: 2
+include: [teams/*.yaml, extra.json]
+delete_config:
+  - target_folder: /srv/main
+`, map[string]string{
+		"conf.d/20-b.yaml":   "delete_config:\n  - target_folder: /srv/conf-b\n",
+		"conf.d/10-a.toml":   "[[delete_config]]\ntarget_folder = \"/srv/conf-a\"\n",
+		"conf.d/.hidden.yml": "delete_config:\n  - target_folder: /srv/hidden\n",
+		"conf.d/README":      "not a configuration file",
+		"teams/web.yaml":     "delete_config:\n  - target_folder: /srv/web\n  - target_folder: /srv/web-cache\n",
+		"teams/db.yaml":      "delete_config:\n  - target_folder: /srv/db\n",
+		"extra.json":         `{"delete_config": [{"target_folder": "/srv/extra"}]}`,
+	})
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Dir(path)
+	want := []struct {
+		folder string
+		source string
+		index  int
+	}{
+		{"/srv/main", path, 0},
+		{"/srv/db", filepath.Join(dir, "teams/db.yaml"), 0},
+		{"/srv/web", filepath.Join(dir, "teams/web.yaml"), 0},
+		{"/srv/web-cache", filepath.Join(dir, "teams/web.yaml"), 1},
+		{"/srv/extra", filepath.Join(dir, "extra.json"), 0},
+		{"/srv/conf-a", filepath.Join(dir, "conf.d/10-a.toml"), 0},
+		{"/srv/conf-b", filepath.Join(dir, "conf.d/20-b.yaml"), 0},
+	}
+	if len(config.DeleteConfig) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(config.DeleteConfig), len(want))
+	}
+	for i, rule := range config.DeleteConfig {
+		if rule.TargetFolder != want[i].folder || rule.Source != want[i].source || rule.SourceIndex != want[i].index {
+			t.Errorf("rule %d is %s from %s[%d], want %s from %s[%d]", i, rule.TargetFolder, rule.Source, rule.SourceIndex, want[i].folder, want[i].source, want[i].index)
+		}
+	}
+}
+
+func TestLoadConfigDropInsInheritDefaults(t *testing.T) {
+	path := writeDropIns(t, `version: 2
+defaults:
+  retention_days: 2w
+delete_config:
+  - target_folder: /srv/main
+`, map[string]string{
+		"conf.d/team.yaml": "delete_config:\n  - target_folder: /srv/team\n  - target_folder: /srv/team-tmp\n    retention_days: 1d\n",
+	})
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{14, 14, 1} {
+		if got := config.DeleteConfig[i].RetentionDays; got != want {
+			t.Errorf("rule %d has a retention of %v days, want %v", i, got, want)
+		}
+	}
+}
+
+func TestLoadConfigDropInConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		dropIns map[string]string
+		want    string
+	}{
+		{"folder of the main file", map[string]string{
+			"conf.d/team.yaml": "delete_config:\n  - target_folder: /srv/main/\n",
+		}, "conf.d/team.yaml: delete_config[0].target_folder /srv/main/ is already configured in "},
+		{"folder of another drop-in", map[string]string{
+			"conf.d/a.yaml": "delete_config:\n  - target_folder: /srv/shared\n",
+			"conf.d/b.yaml": "delete_config:\n  - target_folder: /srv/other\n  - target_folder: /srv/shared\n",
+		}, "conf.d/b.yaml: delete_config[1].target_folder /srv/shared is already configured in "},
+		{"log_file_path", map[string]string{
+			"conf.d/team.yaml": "log_file_path: /var/log/team\n",
+		}, "conf.d/team.yaml: included files may only contain delete_config"},
+		{"defaults", map[string]string{
+			"conf.d/team.yaml": "defaults:\n  retention_days: 1\ndelete_config:\n  - target_folder: /srv/team\n",
+		}, "conf.d/team.yaml: included files may only contain delete_config"},
+		{"webhooks", map[string]string{
+			"conf.d/team.yaml": "webhooks:\n  - url: https://example.com\n",
+		}, "conf.d/team.yaml: included files may only contain delete_config"},
+		{"include", map[string]string{
+			"conf.d/team.yaml": "include: [other.yaml]\n",
+		}, "conf.d/team.yaml: included files may only contain delete_config"},
+	}
+	for _, test := range tests {
+		path := writeDropIns(t, "version: 2\ndelete_config:\n  - target_folder: /srv/main\n", test.dropIns)
+		_, err := LoadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestLoadConfigMissingInclude(t *testing.T) {
+	path := writeDropIns(t, "version: 2\ninclude: [missing.yaml, teams/*.yaml]\n", nil)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "missing.yaml: no such file") {
+		t.Errorf("got %v, want an error about missing.yaml", err)
+	}
+
+	// A pattern matching nothing is fine
+	path = writeDropIns(t, "version: 2\ninclude: [teams/*.yaml]\n", nil)
+	if _, err := LoadConfig(path); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,222 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentConfigVersion is the version of the configuration format written by
+// this release. Files without a version key are version 1.
+const CurrentConfigVersion = 2
+
+// configMigrations upgrade a raw configuration from the version they are keyed
+// by to the next one, returning a note for every change they make.
+var configMigrations = map[int]func(raw map[string]any) []string{
+	1: migrateV1,
+}
+
+// migrateV1 drops the percentage settings of rules that do not enable them.
+// Version 1 silently ignored them, version 2 reports them as conflicting.
+func migrateV1(raw map[string]any) []string {
+	var notes []string
+	for i, rule := range rawRules(raw) {
+		if enabled, _ := rule["max_folder_percent_enabled"].(bool); enabled {
+			continue
+		}
+		for _, key := range []string{"max_folder_size_percent", "max_folder_percent_from_available_size"} {
+			value, ok := rule[key]
+			if !ok {
+				continue
+			}
+			delete(rule, key)
+			if !reflect.ValueOf(value).IsZero() {
+				notes = append(notes, fmt.Sprintf("delete_config[%d]: removed %s, it has no effect without max_folder_percent_enabled", i, key))
+			}
+		}
+	}
+	return notes
+}
+
+// migrateRaw upgrades raw in place to CurrentConfigVersion. It returns the
+// version raw was written in and a note for every change made.
+func migrateRaw(raw map[string]any) (int, []string, error) {
+	version := 1
+	if value, ok := raw["version"]; ok {
+		number, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+		if err != nil || number != math.Trunc(number) || number < 1 {
+			return 0, nil, fmt.Errorf("invalid version %v", value)
+		}
+		version = int(number)
+	}
+	if version > CurrentConfigVersion {
+		return 0, nil, fmt.Errorf("version %d is newer than the version %d this FileCleanup supports", version, CurrentConfigVersion)
+	}
+	var notes []string
+	for v := version; v < CurrentConfigVersion; v++ {
+		notes = append(notes, configMigrations[v](raw)...)
+	}
+	raw["version"] = CurrentConfigVersion
+	return version, notes, nil
+}
+
+// rawRules returns the rules of a raw configuration. Depending on the format
+// viper reads them as a list of maps or as a list of values holding maps.
+func rawRules(raw map[string]any) []map[string]any {
+	switch list := raw["delete_config"].(type) {
+	case []map[string]any:
+		return list
+	case []any:
+		rules := make([]map[string]any, 0, len(list))
+		for _, item := range list {
+			if rule, ok := item.(map[string]any); ok {
+				rules = append(rules, rule)
+			}
+		}
+		return rules
+	default:
+		return nil
+	}
+}
+
+// unknownKeys reports the keys of raw that no field of typ reads. They were
+// silently ignored before, most of them are typos.
+func unknownKeys(raw map[string]any, typ reflect.Type, path string) []string {
+	var warnings []string
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		if path == "" && key == "$schema" {
+			continue
+		}
+		field, ok := fieldByTag(typ, "json", key)
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("unknown key %s is ignored", keyPath))
+			continue
+		}
+		switch elem := field.Type; {
+		case elem.Kind() == reflect.Slice && elem.Elem().Kind() == reflect.Struct:
+			for i, item := range rawItems(raw[key]) {
+				warnings = append(warnings, unknownKeys(item, elem.Elem(), fmt.Sprintf("%s[%d]", keyPath, i))...)
+			}
+		case elem.Kind() == reflect.Pointer && elem.Elem().Kind() == reflect.Struct:
+			items := rawItems(raw[key])
+			if block, ok := raw[key].(map[string]any); ok {
+				items = []map[string]any{block}
+			}
+			for _, item := range items {
+				warnings = append(warnings, unknownKeys(item, elem.Elem(), keyPath)...)
+			}
+		}
+	}
+	return warnings
+}
+
+func rawItems(value any) []map[string]any {
+	return rawRules(map[string]any{"delete_config": value})
+}
+
+// MigrateConfigFile upgrades the configuration file at path to
+// CurrentConfigVersion. Unless dryRun is set the file is rewritten after a
+// copy of the original is saved next to it. JSON and YAML files keep the
+// order of their keys and their comments. It returns the version the file was
+// written in and a note for every change made.
+func MigrateConfigFile(path string, dryRun bool) (int, []string, error) {
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	doc, err := OpenConfigDocument(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	version, notes, err := doc.Migrate()
+	if err != nil {
+		return 0, nil, fmt.Errorf("migrating config %s: %w", path, err)
+	}
+	if version == CurrentConfigVersion || dryRun {
+		return version, notes, nil
+	}
+
+	data, err := doc.Bytes()
+	if err != nil {
+		return 0, nil, err
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", path, version)
+	if err := os.WriteFile(backup, original, 0o644); err != nil {
+		return 0, nil, err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return 0, nil, err
+	}
+	if !doc.PreservesComments() {
+		notes = append(notes, "comments of "+path+" are not preserved")
+	}
+	return version, append(notes, "saved the original file as "+backup), nil
+}
+
+// marshalRaw encodes a raw configuration in format, keeping the values as
+// written instead of the normalized numbers Config holds.
+func marshalRaw(raw map[string]any, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		data, err := json.MarshalIndent(raw, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FormatYAML:
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(raw); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatTOML:
+		return toml.Marshal(raw)
+	case FormatHCL:
+		var buf bytes.Buffer
+		writeHCLMap(&buf, raw, "")
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+func writeHCLMap(buf *bytes.Buffer, raw map[string]any, indent string) {
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if items := rawItems(raw[key]); len(items) > 0 {
+			for _, item := range items {
+				fmt.Fprintf(buf, "%s%s {\n", indent, key)
+				writeHCLMap(buf, item, indent+"  ")
+				fmt.Fprintf(buf, "%s}\n", indent)
+			}
+			continue
+		}
+		fmt.Fprintf(buf, "%s%s = %s\n", indent, key, hclValue(reflect.ValueOf(raw[key])))
+	}
+}
@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrateV1(t *testing.T) {
+	raw := map[string]any{"delete_config": []any{
+		map[string]any{"target_folder": "/a", "max_folder_percent_enabled": true, "max_folder_size_percent": 50},
+		map[string]any{"target_folder": "/b", "max_folder_size_percent": 50, "max_folder_percent_from_available_size": true},
+		map[string]any{"target_folder": "/c", "max_folder_percent_enabled": false, "max_folder_size_percent": 0},
+		map[string]any{"target_folder": "/d"},
+	}}
+	notes := migrateV1(raw)
+
+	want := []map[string]any{
+		{"target_folder": "/a", "max_folder_percent_enabled": true, "max_folder_size_percent": 50},
+		{"target_folder": "/b"},
+		{"target_folder": "/c", "max_folder_percent_enabled": false},
+		{"target_folder": "/d"},
+	}
+	if rules := rawRules(raw); !reflect.DeepEqual(rules, want) {
+		t.Errorf("migrated to %v, want %v", rules, want)
+	}
+	// Zero values are dropped without a note
+	wantNotes := []string{
+		"delete_config[1]: removed max_folder_size_percent, it has no effect without max_folder_percent_enabled",
+		"delete_config[1]: removed max_folder_percent_from_available_size, it has no effect without max_folder_percent_enabled",
+	}
+	if !reflect.DeepEqual(notes, wantNotes) {
+		t.Errorf("notes %q, want %q", notes, wantNotes)
+	}
+}
+
+func TestMigrateRawVersions(t *testing.T) {
+	tests := []struct {
+		raw     map[string]any
+		version int
+		ok      bool
+	}{
+		{map[string]any{}, 1, true},
+		{map[string]any{"version": 1}, 1, true},
+		{map[string]any{"version": 2.0}, 2, true},
+		{map[string]any{"version": "2"}, 2, true},
+		{map[string]any{"version": 3}, 0, false},
+		{map[string]any{"version": 1.5}, 0, false},
+		{map[string]any{"version": "two"}, 0, false},
+	}
+	for _, test := range tests {
+		in := fmt.Sprint(test.raw)
+		version, _, err := migrateRaw(test.raw)
+		if test.ok && (err != nil || version != test.version || test.raw["version"] != CurrentConfigVersion) {
+			t.Errorf("%s: version %d, %v, raw %v, want version %d", in, version, err, test.raw, test.version)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: want an error", in)
+		}
+	}
+}
+
+func TestMigrateConfigFileKeepsComments(t *testing.T) {
+	original := `# FileCleanup of the web servers
+log_file_path: /var/log/fileCleanup # next to the other logs
+delete_config:
+  # uploads are kept for two weeks
+  - target_folder: /srv/uploads
+    retention_days: 2w
+    max_folder_size_mb: 10GiB # the disk is 50GiB
+    max_folder_size_percent: 80
+    delete_interval_seconds: 1h
+  - target_folder: /srv/tmp
+    max_folder_percent_enabled: true
+    max_folder_size_percent: 50
+`
+	path := writeConfig(t, original)
+
+	version, notes, err := MigrateConfigFile(path, true)
+	if err != nil || version != 1 || len(notes) != 1 {
+		t.Fatalf("dry run: version %d, notes %q, %v", version, notes, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Fatal("dry run changed the file")
+	}
+
+	version, notes, err = MigrateConfigFile(path, false)
+	if err != nil || version != 1 {
+		t.Fatalf("version %d, %v", version, err)
+	}
+	if notes[len(notes)-1] != "saved the original file as "+path+".v1.bak" {
+		t.Errorf("notes %q", notes)
+	}
+	if backup, _ := os.ReadFile(path + ".v1.bak"); string(backup) != original {
+		t.Errorf("backup holds %q", backup)
+	}
+	want := `# FileCleanup of the web servers
+version: 2
+log_file_path: /var/log/fileCleanup # next to the other logs
+delete_config:
+  # uploads are kept for two weeks
+  - target_folder: /srv/uploads
+    retention_days: 2w
+    max_folder_size_mb: 10GiB # the disk is 50GiB
+    delete_interval_seconds: 1h
+  - target_folder: /srv/tmp
+    max_folder_percent_enabled: true
+    max_folder_size_percent: 50
+`
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Errorf("migrated to\n%s\nwant\n%s", data, want)
+	}
+
+	version, _, err = MigrateConfigFile(path, false)
+	if err != nil || version != CurrentConfigVersion {
+		t.Errorf("second migration: version %d, %v", version, err)
+	}
+}
+
+func TestMigrateConfigFileKeepsJSONKeyOrder(t *testing.T) {
+	path := writeConfigAs(t, "config.json", `{
+  "$schema": "https://example.com/schema.json",
+  "log_file_path": "/var/log/fileCleanup",
+  "delete_config": [
+    {
+      "target_folder": "/srv/uploads",
+      "retention_days": 14,
+      "max_folder_size_percent": 0,
+      "max_folder_size_mb": 1000
+    }
+  ],
+  "detailed_log": false
+}
+`)
+	if _, _, err := MigrateConfigFile(path, false); err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "$schema": "https://example.com/schema.json",
+  "version": 2,
+  "log_file_path": "/var/log/fileCleanup",
+  "delete_config": [
+    {
+      "target_folder": "/srv/uploads",
+      "retention_days": 14,
+      "max_folder_size_mb": 1000
+    }
+  ],
+  "detailed_log": false
+}
+`
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Errorf("migrated to\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestMigrateConfigFileTOML(t *testing.T) {
+	path := writeConfigAs(t, "config.toml", `# comments are lost
+log_file_path = "/var/log/fileCleanup"
+
+[[delete_config]]
+target_folder = "/srv/uploads"
+max_folder_size_percent = 80
+`)
+	_, notes, err := MigrateConfigFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(notes, "\n"), "comments of "+path+" are not preserved") {
+		t.Errorf("notes %q do not mention the lost comments", notes)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Warnings) != 0 || config.Version != CurrentConfigVersion || config.LogFilePath != "/var/log/fileCleanup" || config.DeleteConfig[0].MaxFolderSizePercent != 0 {
+		t.Errorf("migrated file loads as %+v", config)
+	}
+}
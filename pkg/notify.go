@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// Events notifications are sent for.
+const (
+	// NotifyRunCompleted is sent after every cleanup run with its totals.
+	NotifyRunCompleted = "run_completed"
+	// NotifySafetyCapReached is sent when a run stopped at the
+	// max_deletes_per_run of its rule.
+	NotifySafetyCapReached = "safety_cap_reached"
+	// NotifyDeleteErrors is sent when files of a run could not be deleted.
+	NotifyDeleteErrors = "delete_errors"
+	// NotifySizeLimitExceeded is sent when a folder is still above its size
+	// limit after a size cleanup.
+	NotifySizeLimitExceeded = "size_limit_exceeded"
+)
+
+// NotifyEvents lists every event notifications are sent for.
+var NotifyEvents = []string{NotifyRunCompleted, NotifySafetyCapReached, NotifyDeleteErrors, NotifySizeLimitExceeded}
+
+// Body formats of a webhook.
+const (
+	WebhookFormatJSON  = "json"
+	WebhookFormatSlack = "slack"
+	WebhookFormatTeams = "teams"
+)
+
+// DefaultWebhookAttempts is how often a notification is sent before giving up
+// when max_attempts is not set.
+const DefaultWebhookAttempts = 4
+
+// webhookTemplates render the body of each format. The notification holds
+// Event, Time, Host, Rule, Job, Title, Message and Result.
+var webhookTemplates = map[string]string{
+	WebhookFormatJSON:  `{{json .}}`,
+	WebhookFormatSlack: `{"text": {{json (printf "*%s*\n%s" .Title .Message)}}}`,
+	WebhookFormatTeams: `{"@type": "MessageCard", "@context": "https://schema.org/extensions", "summary": {{json .Title}}, "title": {{json .Title}}, "text": {{json .Message}}}`,
+}
+
+// TemplateFuncs are the functions available to notification templates: json
+// encodes a value as JSON, bytes formats a number of bytes and percent
+// returns how many percent of its second argument the first one is.
+var TemplateFuncs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	"bytes": FormatBytes,
+	"percent": func(part, whole int64) int64 {
+		if whole <= 0 {
+			return 0
+		}
+		return part * 100 / whole
+	},
+}
+
+// WebhookConfig posts a notification to URL on the events it is interested in.
+type WebhookConfig struct {
+	Name          string   `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty" comment:"Name identifying the webhook in logs and commands, defaults to the host of the URL"`
+	URL           string   `json:"url" yaml:"url" toml:"url" comment:"URL the notifications are posted to" schema:"required"`
+	Format        string   `json:"format,omitempty" yaml:"format,omitempty" toml:"format,omitempty" comment:"Body of the notifications: json, slack or teams, defaults to json" schema:"enum=json|slack|teams"`
+	Template      string   `json:"template,omitempty" yaml:"template,omitempty" toml:"template,omitempty" comment:"Go template of the body replacing format, with the json and bytes functions" expand:"-"`
+	Events        []string `json:"events,omitempty" yaml:"events,omitempty" toml:"events,omitempty" comment:"Events to notify: run_completed, safety_cap_reached, delete_errors and size_limit_exceeded, all by default"`
+	Rules         []string `json:"rules,omitempty" yaml:"rules,omitempty" toml:"rules,omitempty" comment:"Names of the rules to notify about, all by default"`
+	SkipEmptyRuns bool     `json:"skip_empty_runs,omitempty" yaml:"skip_empty_runs,omitempty" toml:"skip_empty_runs,omitempty" comment:"Do not notify run_completed for runs that neither deleted a file nor failed"`
+	Headers       []string `json:"headers,omitempty" yaml:"headers,omitempty" toml:"headers,omitempty" comment:"Further request headers as \"Name: value\", such as \"Authorization: Bearer ${TOKEN}\""`
+	// MaxAttempts and TimeoutSeconds bound the delivery, failed attempts are
+	// retried with exponential backoff.
+	MaxAttempts    int `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty" toml:"max_attempts,omitempty" comment:"Attempts to deliver a notification before giving up, defaults to 4" schema:"minimum=0"`
+	TimeoutSeconds int `json:"timeout_seconds,omitempty" yaml:"timeout_seconds,omitempty" toml:"timeout_seconds,omitempty" comment:"Timeout of an attempt in seconds, defaults to 10" schema:"minimum=0"`
+}
+
+// DisplayName returns the name of the webhook, or the host of its URL.
+func (w WebhookConfig) DisplayName() string {
+	if w.Name != "" {
+		return w.Name
+	}
+	if u, err := url.Parse(w.URL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return w.URL
+}
+
+// Wants reports whether the webhook is notified of event about rule.
+func (w WebhookConfig) Wants(event, rule string) bool {
+	return (len(w.Events) == 0 || slices.Contains(w.Events, event)) && (len(w.Rules) == 0 || slices.Contains(w.Rules, rule))
+}
+
+// BodyTemplate returns the template rendering the body of the notifications.
+func (w WebhookConfig) BodyTemplate() (*template.Template, error) {
+	text := w.Template
+	if text == "" {
+		format := w.Format
+		if format == "" {
+			format = WebhookFormatJSON
+		}
+		var ok bool
+		if text, ok = webhookTemplates[format]; !ok {
+			return nil, fmt.Errorf("unknown format %q, expected json, slack or teams", w.Format)
+		}
+	}
+	return template.New(w.DisplayName()).Funcs(TemplateFuncs).Parse(text)
+}
+
+// ParsedHeaders returns the headers as name, value pairs.
+func (w WebhookConfig) ParsedHeaders() ([][2]string, error) {
+	headers := make([][2]string, 0, len(w.Headers))
+	for _, header := range w.Headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("header %q is not \"Name: value\"", header)
+		}
+		headers = append(headers, [2]string{strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+	return headers, nil
+}
+
+// problems adds the problems of the webhook at key.
+func (w WebhookConfig) problems(key string, add func(path, format string, args ...any)) {
+	if w.URL == "" {
+		add(key+".url", "is required")
+	} else if u, err := url.Parse(w.URL); err != nil {
+		add(key+".url", "%v", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		add(key+".url", "must be an http or https URL")
+	}
+	if _, err := w.BodyTemplate(); err != nil {
+		if w.Template != "" {
+			add(key+".template", "%v", err)
+		} else {
+			add(key+".format", "%v", err)
+		}
+	}
+	for _, event := range w.Events {
+		if !slices.Contains(NotifyEvents, event) {
+			add(key+".events", "unknown event %q, expected one of %s", event, strings.Join(NotifyEvents, ", "))
+		}
+	}
+	if _, err := w.ParsedHeaders(); err != nil {
+		add(key+".headers", "%v", err)
+	}
+	if w.MaxAttempts < 0 {
+		add(key+".max_attempts", "must not be negative")
+	}
+	if w.TimeoutSeconds < 0 {
+		add(key+".timeout_seconds", "must not be negative")
+	}
+}
@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ruleNamePattern keeps rule names usable as command line arguments.
+var ruleNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// uninheritableKeys identify a rule and cannot be set in the defaults block.
+var uninheritableKeys = []string{"target_folder", "name", "description"}
+
+// DisplayName returns the name of the rule, or its target folder when it has
+// none. It identifies the rule in logs and command output.
+func (d DeleteConfig) DisplayName() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return d.TargetFolder
+}
+
+// rawDefaults returns the defaults block of a raw configuration.
+func rawDefaults(raw map[string]any) (map[string]any, error) {
+	var defaults map[string]any
+	switch value := raw["defaults"].(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		defaults = value
+	default:
+		// HCL reads a block as a list of blocks
+		items := rawItems(value)
+		if len(items) != 1 {
+			return nil, errors.New("defaults must be a single block")
+		}
+		defaults = items[0]
+	}
+	for _, key := range uninheritableKeys {
+		if _, ok := defaults[key]; ok {
+			return nil, fmt.Errorf("defaults: %s cannot be inherited, set it on each rule", key)
+		}
+	}
+	return defaults, nil
+}
+
+// applyRuleDefaults fills the keys rule does not set from defaults. Rules are
+// enabled unless they or the defaults say otherwise.
+func applyRuleDefaults(rule, defaults map[string]any) {
+	for key, value := range defaults {
+		if _, ok := rule[key]; !ok {
+			rule[key] = value
+		}
+	}
+	if _, ok := rule["enabled"]; !ok {
+		rule["enabled"] = true
+	}
+}
@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SchemaURL is where the published JSON Schema of the configuration lives,
+// referenced by the $schema key of generated JSON configuration files.
+const SchemaURL = "https://raw.githubusercontent.com/MoshPe/FileCleanup/main/schema/config.schema.json"
+
+// Patterns of the string forms accepted by ParseDuration and ParseSize.
+const (
+	durationSchemaPattern = `^\s*(\d+(\.\d+)?\s*|(\d+(\.\d+)?\s*(ms|s|m|h|d|w)\s*)+)$`
+	sizeSchemaPattern     = `^\s*\d+(\.\d+)?\s*([bB]|[kKmMgGtT][iI]?[bB])?\s*$`
+)
+
+// JSONSchema returns the JSON Schema of Config. It is generated from the
+// struct tags: json names the keys, comment describes them and schema holds
+// extra keywords such as required, minimum=0, maximum=100, pattern=... or enum=a|b.
+// Defaults are taken from DefaultConfig.
+func JSONSchema() ([]byte, error) {
+	defaults := DefaultConfig()
+	schema := objectSchema(reflect.TypeOf(Config{}), reflect.ValueOf(defaults))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = SchemaURL
+	schema["title"] = "FileCleanup configuration"
+	schema["properties"].(map[string]any)["$schema"] = map[string]any{
+		"type":        "string",
+		"description": "JSON Schema of this file",
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// objectSchema describes the struct typ, taking defaults from the struct
+// value defaults when it is valid.
+func objectSchema(typ reflect.Type, defaults reflect.Value) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := tagName(field, "json")
+		if name == "" || name == "-" {
+			continue
+		}
+		var fieldDefault reflect.Value
+		if defaults.IsValid() {
+			fieldDefault = defaults.Field(i)
+		}
+		properties[name] = fieldSchema(field, name, fieldDefault)
+		if tagHasKeyword(field, "required") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func fieldSchema(field reflect.StructField, name string, defaults reflect.Value) map[string]any {
+	schema := typeSchema(field.Type, defaults)
+	if _, ok := durationKeys[name]; ok {
+		schema = unitSchema(schema, durationSchemaPattern)
+	}
+	if _, ok := sizeKeys[name]; ok {
+		schema = unitSchema(schema, sizeSchemaPattern)
+	}
+	if comment := field.Tag.Get("comment"); comment != "" {
+		schema["description"] = comment
+	}
+	// Only numbers and switches have meaningful defaults, the default paths
+	// are placeholders.
+	if defaults.IsValid() && defaults.Kind() != reflect.Slice && defaults.Kind() != reflect.String && !defaults.IsZero() {
+		schema["default"] = defaults.Interface()
+	}
+	for _, keyword := range strings.Split(field.Tag.Get("schema"), ",") {
+		key, value, ok := strings.Cut(keyword, "=")
+		if !ok {
+			// Flags such as required apply to the enclosing object.
+			continue
+		}
+		switch key {
+		case "minimum", "maximum":
+			if number, err := strconv.ParseFloat(value, 64); err == nil {
+				schema[key] = number
+			}
+		case "enum":
+			schema[key] = strings.Split(value, "|")
+		default:
+			schema[key] = value
+		}
+	}
+	return schema
+}
+
+func typeSchema(typ reflect.Type, defaults reflect.Value) map[string]any {
+	switch typ.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		var item reflect.Value
+		if defaults.IsValid() && defaults.Len() > 0 {
+			item = defaults.Index(0)
+		}
+		return map[string]any{"type": "array", "items": typeSchema(typ.Elem(), item)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(typ.Elem(), reflect.Value{})}
+	case reflect.Struct:
+		return objectSchema(typ, defaults)
+	case reflect.Pointer:
+		// An optional block, none of its keys are required
+		if defaults.IsValid() && !defaults.IsNil() {
+			defaults = defaults.Elem()
+		} else {
+			defaults = reflect.Value{}
+		}
+		schema := typeSchema(typ.Elem(), defaults)
+		delete(schema, "required")
+		return schema
+	default:
+		return map[string]any{}
+	}
+}
+
+// tagHasKeyword reports whether the schema tag of field holds the flag keyword.
+func tagHasKeyword(field reflect.StructField, keyword string) bool {
+	for _, k := range strings.Split(field.Tag.Get("schema"), ",") {
+		if k == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+// unitSchema lets a numeric key also take a string with a unit.
+func unitSchema(number map[string]any, pattern string) map[string]any {
+	return map[string]any{
+		"anyOf": []any{
+			number,
+			map[string]any{"type": "string", "pattern": pattern},
+		},
+	}
+}
@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	constant "FileCleanup/const"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationUnits are the units accepted by ParseDuration on top of Go's own.
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// sizeUnits follow the usual convention: KB, MB, GB and TB are powers of 1000,
+// KiB, MiB, GiB and TiB powers of 1024.
+var sizeUnits = map[string]float64{
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": constant.KB,
+	"mib": constant.MB,
+	"gib": constant.GB,
+	"tib": constant.TB,
+}
+
+var (
+	durationPart = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*(ms|s|m|h|d|w)`)
+	plainNumber  = regexp.MustCompile(`^-?\d+(?:\.\d+)?$`)
+	sizePattern  = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([a-zA-Z]*)$`)
+)
+
+// FormatBytes writes n bytes in the largest binary unit that keeps it above 1.
+func FormatBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// ParseDuration parses durations such as "90s", "36h", "1.5d" or "2w1d".
+func ParseDuration(s string) (time.Duration, error) {
+	rest := strings.TrimSpace(s)
+	if rest == "" {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var total time.Duration
+	for rest != "" {
+		match := durationPart.FindStringSubmatch(rest)
+		if match == nil {
+			return 0, fmt.Errorf("invalid duration %q, use a number followed by ms, s, m, h, d or w", s)
+		}
+		value, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		part, ok := toInt64(value * float64(durationUnits[match[2]]))
+		if !ok || total > math.MaxInt64-time.Duration(part) {
+			return 0, fmt.Errorf("duration %q is too large", s)
+		}
+		total += time.Duration(part)
+		rest = strings.TrimSpace(rest[len(match[0]):])
+	}
+	return total, nil
+}
+
+// ParseSize parses sizes such as "512B", "500MiB" or "1.5TB" into bytes.
+func ParseSize(s string) (int64, error) {
+	match := sizePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", s, err)
+	}
+	unit, ok := sizeUnits[strings.ToLower(match[2])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q, use B, KB, MB, GB, TB, KiB, MiB, GiB or TiB", s)
+	}
+	size, ok := toInt64(value * unit)
+	if !ok {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	if size == 0 && value > 0 {
+		return 0, fmt.Errorf("size %q rounds to 0 bytes", s)
+	}
+	return size, nil
+}
+
+// toInt64 rounds f to an int64, failing when it does not fit.
+func toInt64(f float64) (int64, bool) {
+	f = math.Round(f)
+	// float64(math.MaxInt64) is 2^63, one above the largest int64
+	if math.IsNaN(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// durationKeys are the keys of a rule that accept durations, with the unit a
+// plain number in them is expressed in.
+var durationKeys = map[string]time.Duration{
+	"retention_days":           24 * time.Hour,
+	"delete_interval_seconds":  time.Second,
+	"check_size_interval_secs": time.Second,
+}
+
+// sizeKeys are the keys of a rule or throttle window that accept sizes, with
+// the unit a plain number in them is expressed in.
+var sizeKeys = map[string]int64{
+	"max_folder_size_mb":          constant.MB,
+	"max_delete_bytes_per_second": 1,
+}
+
+// unitNames name the units plain numbers are counted in.
+var unitNames = map[int64]string{
+	1:                  "bytes",
+	constant.MB:        "MiB",
+	int64(time.Second): "seconds",
+}
+
+// normalizeUnits rewrites the human friendly values of a raw rule, such as
+// "36h" or "500MiB", into the numbers its keys have always held, so existing
+// numeric configurations keep working unchanged. Rules are only decoded by
+// the loader, which normalizes them before decoding.
+func normalizeUnits(rule map[string]any) error {
+	for key, value := range rule {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if unit, ok := durationKeys[key]; ok {
+			number, err := parseWithUnit(text, ParseDuration, unit)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if key == "retention_days" {
+				rule[key] = float64(number) / float64(unit)
+				continue
+			}
+			if rule[key], err = WholeUnits(text, int64(number), int64(unit)); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		if unit, ok := sizeKeys[key]; ok {
+			number, err := parseWithUnit(text, ParseSize, unit)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if rule[key], err = WholeUnits(text, number, unit); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	for i, fields := range rawItems(rule["throttle_windows"]) {
+		if text, ok := fields["max_delete_bytes_per_second"].(string); ok {
+			number, err := parseWithUnit(text, ParseSize, 1)
+			if err == nil {
+				fields["max_delete_bytes_per_second"], err = WholeUnits(text, number, 1)
+			}
+			if err != nil {
+				return fmt.Errorf("throttle_windows[%d].max_delete_bytes_per_second: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseWithUnit parses text with parse, a plain number is taken in unit.
+// Negative plain numbers are passed on for validation to report.
+func parseWithUnit[T time.Duration | int64](text string, parse func(string) (T, error), unit T) (T, error) {
+	if plain := strings.TrimSpace(text); plainNumber.MatchString(plain) {
+		number, _ := strconv.ParseFloat(plain, 64)
+		value, ok := toInt64(number * float64(unit))
+		if !ok {
+			return 0, fmt.Errorf("%q is too large", text)
+		}
+		return T(value), nil
+	}
+	return parse(text)
+}
+
+// WholeUnits rounds value to the nearest whole number of unit, the way the
+// plain numbers of the configuration are stored. A positive value that rounds
+// to 0 is rejected, as 0 turns most settings off or means unlimited; text is
+// the value as written, for the error.
+func WholeUnits(text string, value, unit int64) (int64, error) {
+	number := int64(math.Round(float64(value) / float64(unit)))
+	if value > 0 && number == 0 {
+		return 0, fmt.Errorf("%q rounds to 0, the value is counted in whole %s", text, unitNames[unit])
+	}
+	return number, nil
+}
@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	constant "FileCleanup/const"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"90s", 90 * time.Second, true},
+		{"36h", 36 * time.Hour, true},
+		{"2w", 14 * 24 * time.Hour, true},
+		{"1.5d", 36 * time.Hour, true},
+		{"2w1d", 15 * 24 * time.Hour, true},
+		{"1h 30m", 90 * time.Minute, true},
+		{"500ms", 500 * time.Millisecond, true},
+		{" 7d ", 7 * 24 * time.Hour, true},
+		{"", 0, false},
+		{"7", 0, false},
+		{"-1h", 0, false},
+		{"1y", 0, false},
+		{"d", 0, false},
+		{"1e3s", 0, false},
+		{"300000w", 0, false},
+		{"200000w200000w", 0, false},
+	}
+	for _, test := range tests {
+		got, err := ParseDuration(test.in)
+		if test.ok && (err != nil || got != test.want) {
+			t.Errorf("ParseDuration(%q) = %s, %v, want %s", test.in, got, err, test.want)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ParseDuration(%q) = %s, want an error", test.in, got)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"512B", 512, true},
+		// Plain numbers are taken in the unit of the key by the loader
+		{"512", 0, false},
+		{"1.5TB", 1_500_000_000_000, true},
+		{"10GiB", 10 << 30, true},
+		{"500MiB", 500 << 20, true},
+		{"500mb", 500_000_000, true},
+		{"1.5 KiB", 1536, true},
+		{"", 0, false},
+		{"-1GB", 0, false},
+		{"10XB", 0, false},
+		{"GiB", 0, false},
+		{"9000000TiB", 0, false},
+		{"99999999999999999999", 0, false},
+	}
+	for _, test := range tests {
+		got, err := ParseSize(test.in)
+		if test.ok && (err != nil || got != test.want) {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", test.in, got, err, test.want)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", test.in, got)
+		}
+	}
+}
+
+func TestNormalizeUnits(t *testing.T) {
+	tests := []struct {
+		key  string
+		in   any
+		want any
+		ok   bool
+	}{
+		{"retention_days", "36h", 1.5, true},
+		{"retention_days", "2w", 14.0, true},
+		{"retention_days", "7", 7.0, true},
+		{"retention_days", "1h", 1.0 / 24, true},
+		{"retention_days", 7, 7, true},
+		{"delete_interval_seconds", "1m", int64(60), true},
+		{"delete_interval_seconds", "1.5s", int64(2), true},
+		{"delete_interval_seconds", "500ms", int64(1), true},
+		{"delete_interval_seconds", "400ms", nil, false},
+		{"delete_interval_seconds", "30", int64(30), true},
+		{"check_size_interval_secs", "1d", int64(86400), true},
+		{"max_folder_size_mb", "10GiB", int64(10240), true},
+		{"max_folder_size_mb", "1.5TB", int64(1430511), true},
+		{"max_folder_size_mb", "500", int64(500), true},
+		// Config init and the loader round the same way
+		{"max_folder_size_mb", "1.4MiB", int64(1), true},
+		{"max_folder_size_mb", "1.6MiB", int64(2), true},
+		{"max_folder_size_mb", "500KB", nil, false},
+		{"max_folder_size_mb", "0", int64(0), true},
+		// Negative plain numbers are left for validation to report
+		{"max_folder_size_mb", "-5", int64(-5), true},
+		{"max_folder_size_mb", "-5MiB", nil, false},
+		{"max_folder_size_mb", "1e9", nil, false},
+		{"max_folder_size_mb", "NaN", nil, false},
+		{"max_folder_size_mb", "99999999999999999999", nil, false},
+		{"retention_days", "999999999999", nil, false},
+	}
+	for _, test := range tests {
+		rule := map[string]any{test.key: test.in}
+		err := normalizeUnits(rule)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%s: %v normalized to %v, want an error", test.key, test.in, rule[test.key])
+			}
+			continue
+		}
+		if err != nil || rule[test.key] != test.want {
+			t.Errorf("%s: %v normalized to %v (%T), %v, want %v (%T)", test.key, test.in, rule[test.key], rule[test.key], err, test.want, test.want)
+		}
+	}
+}
+
+func TestNormalizeUnitsThrottleWindows(t *testing.T) {
+	rule := map[string]any{"throttle_windows": []any{
+		map[string]any{"start": "08:00", "end": "18:00", "max_delete_bytes_per_second": "10MiB"},
+		map[string]any{"start": "22:00", "end": "06:00", "max_delete_bytes_per_second": 1000},
+	}}
+	if err := normalizeUnits(rule); err != nil {
+		t.Fatal(err)
+	}
+	windows := rule["throttle_windows"].([]any)
+	if got := windows[0].(map[string]any)["max_delete_bytes_per_second"]; got != int64(10<<20) {
+		t.Errorf("10MiB normalized to %v", got)
+	}
+	if got := windows[1].(map[string]any)["max_delete_bytes_per_second"]; got != 1000 {
+		t.Errorf("1000 changed to %v", got)
+	}
+
+	rule = map[string]any{"throttle_windows": []any{map[string]any{"max_delete_bytes_per_second": "0.4B"}}}
+	if err := normalizeUnits(rule); err == nil {
+		t.Error("expected 0.4B to be rejected")
+	}
+}
+
+func TestWholeUnits(t *testing.T) {
+	if got, err := WholeUnits("1.5MiB", constant.MB*3/2, constant.MB); err != nil || got != 2 {
+		t.Errorf("1.5MiB = %d, %v, want 2", got, err)
+	}
+	if _, err := WholeUnits("100KiB", 100<<10, constant.MB); err == nil {
+		t.Error("expected 100KiB to round to 0 MiB and be rejected")
+	}
+	if got, err := WholeUnits("0s", 0, int64(time.Second)); err != nil || got != 0 {
+		t.Errorf("0s = %d, %v, want 0", got, err)
+	}
+}
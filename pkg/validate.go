@@ -0,0 +1,486 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError is a single problem found in a configuration. Path is the
+// JSON path of the offending key within File, Line its line in File or 0 when
+// unknown.
+type ValidationError struct {
+	File    string
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Path, e.Message)
+	case e.File != "":
+		return fmt.Sprintf("%s: %s: %s", e.File, e.Path, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Message)
+	default:
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+}
+
+// ValidationErrors lists every problem of a configuration.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, problem := range e {
+		messages[i] = problem.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Validate checks the configuration for values the daemon cannot run with. The
+// returned error is a ValidationErrors when the configuration has problems.
+func (c Config) Validate() error {
+	if problems := c.Problems(); len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// ValidateFile validates config, loaded from path, and reports each problem
+// with the file and line it is found on. Problems of rules loaded from
+// included files point into those files.
+func ValidateFile(path string, config Config) error {
+	problems := config.Problems()
+	if len(problems) == 0 {
+		return nil
+	}
+	fileLines := make(map[string]map[string]int)
+	for i := range problems {
+		problem := &problems[i]
+		problem.File = path
+		var index int
+		if _, err := fmt.Sscanf(problem.Path, "delete_config[%d]", &index); err == nil && index < len(config.DeleteConfig) {
+			if rule := config.DeleteConfig[index]; rule.Source != "" {
+				problem.File = rule.Source
+				problem.Path = fmt.Sprintf("delete_config[%d]", rule.SourceIndex) + strings.TrimPrefix(problem.Path, fmt.Sprintf("delete_config[%d]", index))
+			}
+		}
+		lines, ok := fileLines[problem.File]
+		if !ok {
+			lines, _ = keyLines(problem.File)
+			fileLines[problem.File] = lines
+		}
+		problem.Line = lineOf(lines, problem.Path)
+	}
+	sortProblems(problems)
+	return problems
+}
+
+// Problems returns every semantic problem of the configuration: invalid or
+// conflicting values, overlapping target folders and target folders that are
+// files. Missing folders are reported by FolderWarnings instead.
+func (c Config) Problems() ValidationErrors {
+	var problems ValidationErrors
+	add := func(path, format string, args ...any) {
+		problems = append(problems, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(c.DeleteConfig) == 0 {
+		add("delete_config", "at least one rule is required")
+	}
+	if c.HTTPAddress != "" {
+		if _, _, err := net.SplitHostPort(c.HTTPAddress); err != nil {
+			add("http_address", "%v", err)
+		}
+	}
+	if c.ControlToken != "" && len(c.ControlToken) < 16 {
+		add("control_token", "must be at least 16 characters long")
+	}
+	for i, webhook := range c.Webhooks {
+		key := fmt.Sprintf("webhooks[%d]", i)
+		webhook.problems(key, add)
+		for _, name := range webhook.Rules {
+			if !slices.ContainsFunc(c.DeleteConfig, func(rule DeleteConfig) bool { return rule.DisplayName() == name }) {
+				add(key+".rules", "no rule named %s", name)
+			}
+		}
+	}
+	if c.Email != nil {
+		c.Email.problems(add)
+	}
+	if c.AuditLog != nil {
+		if c.AuditLog.Path == "" {
+			add("audit_log.path", "is required")
+		}
+		for _, rule := range c.DeleteConfig {
+			if c.AuditLog.Path != "" && rule.Enabled && rule.TargetFolder != "" && isSubfolder(filepath.Clean(c.AuditLog.Path), filepath.Clean(rule.TargetFolder)) {
+				add("audit_log.path", "lies in the target folder of rule %s and would be cleaned up", rule.DisplayName())
+			}
+		}
+		if c.AuditLog.MaxSizeMB < 0 {
+			add("audit_log.max_size_mb", "must not be negative")
+		}
+		if c.AuditLog.MaxBackups < 0 {
+			add("audit_log.max_backups", "must not be negative")
+		}
+		if c.AuditLog.MaxAgeDays < 0 {
+			add("audit_log.max_age_days", "must not be negative")
+		}
+	}
+	for i, rule := range c.DeleteConfig {
+		prefix := fmt.Sprintf("delete_config[%d]", i)
+		key := func(name string) string { return prefix + "." + name }
+
+		if rule.Name != "" && !ruleNamePattern.MatchString(rule.Name) {
+			add(key("name"), "may only contain letters, digits, '.', '_' and '-'")
+		}
+		for j, other := range c.DeleteConfig[:i] {
+			if rule.Name != "" && other.Name == rule.Name {
+				add(key("name"), "%s is already used by %s", rule.Name, ruleLocation(other, j))
+			}
+		}
+
+		if rule.TargetFolder == "" {
+			add(key("target_folder"), "is required")
+		} else if stat, err := os.Stat(rule.TargetFolder); rule.Enabled && err == nil && !stat.IsDir() {
+			add(key("target_folder"), "%s is not a folder", rule.TargetFolder)
+		}
+
+		if rule.RetentionDays <= 0 {
+			add(key("retention_days"), "must be positive, a retention of 0 deletes every file")
+		}
+		if rule.DeleteIntervalSeconds <= 0 {
+			add(key("delete_interval_seconds"), "must be positive")
+		}
+		if rule.CheckSizeIntervalSecs <= 0 {
+			add(key("check_size_interval_secs"), "must be positive")
+		}
+
+		if rule.MaxFolderPercentEnabled {
+			if rule.MaxFolderSizePercent <= 0 || rule.MaxFolderSizePercent > 100 {
+				add(key("max_folder_size_percent"), "must be between 1 and 100 when max_folder_percent_enabled is set")
+			}
+		} else {
+			if rule.MaxFolderSizeMB <= 0 {
+				add(key("max_folder_size_mb"), "must be positive, a limit of 0 deletes every file")
+			}
+			if rule.MaxFolderSizePercent != 0 {
+				add(key("max_folder_size_percent"), "has no effect unless max_folder_percent_enabled is set")
+			}
+			if rule.MaxFolderPercentFromAvailableSize {
+				add(key("max_folder_percent_from_available_size"), "has no effect unless max_folder_percent_enabled is set")
+			}
+		}
+
+		if rule.ScanConcurrency < 0 {
+			add(key("scan_concurrency"), "must not be negative")
+		}
+		if rule.DeleteWorkers < 0 {
+			add(key("delete_workers"), "must not be negative")
+		}
+		if rule.MaxDeletesPerSecond < 0 {
+			add(key("max_deletes_per_second"), "must not be negative")
+		}
+		if rule.MaxDeleteBytesPerSecond < 0 {
+			add(key("max_delete_bytes_per_second"), "must not be negative")
+		}
+		if rule.MaxDeletesPerRun < 0 {
+			add(key("max_deletes_per_run"), "must not be negative")
+		}
+		for j, window := range rule.ThrottleWindows {
+			windowKey := fmt.Sprintf("%s.throttle_windows[%d]", prefix, j)
+			if _, err := window.Contains(time.Now()); err != nil {
+				add(windowKey, "%v", err)
+			} else if window.Start == window.End {
+				add(windowKey+".end", "must differ from start")
+			}
+			if window.MaxDeletesPerSecond < 0 {
+				add(windowKey+".max_deletes_per_second", "must not be negative")
+			}
+			if window.MaxDeleteBytesPerSecond < 0 {
+				add(windowKey+".max_delete_bytes_per_second", "must not be negative")
+			}
+		}
+
+		if rule.TargetFolder == "" || !rule.Enabled {
+			continue
+		}
+		for j, other := range c.DeleteConfig[:i] {
+			if other.TargetFolder == "" || !other.Enabled {
+				continue
+			}
+			folder, otherFolder := filepath.Clean(rule.TargetFolder), filepath.Clean(other.TargetFolder)
+			switch {
+			case folder == otherFolder:
+				add(key("target_folder"), "folder %s is already used by %s", rule.TargetFolder, ruleLocation(other, j))
+			case isSubfolder(folder, otherFolder), isSubfolder(otherFolder, folder):
+				add(key("target_folder"), "folder %s overlaps with %s of %s", rule.TargetFolder, other.TargetFolder, ruleLocation(other, j))
+			}
+		}
+	}
+	return problems
+}
+
+// FolderWarnings reports the target folders of enabled rules that do not exist
+// or cannot be reached. They are not problems, a share that is not mounted yet
+// must not stop the daemon from starting or reloading. Such a rule deletes
+// nothing until its folder is back and the configuration is reloaded.
+func (c Config) FolderWarnings() []string {
+	var warnings []string
+	for i, rule := range c.DeleteConfig {
+		if rule.TargetFolder == "" || !rule.Enabled {
+			continue
+		}
+		location := ruleLocation(rule, i)
+		if _, err := os.Stat(rule.TargetFolder); errors.Is(err, os.ErrNotExist) {
+			warnings = append(warnings, fmt.Sprintf("%s: target folder %s does not exist", location, rule.TargetFolder))
+		} else if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: target folder %s is not reachable: %v", location, rule.TargetFolder, err))
+		}
+	}
+	return warnings
+}
+
+// ruleLocation names the rule at index of the merged configuration.
+func ruleLocation(rule DeleteConfig, index int) string {
+	if rule.Source == "" {
+		return fmt.Sprintf("delete_config[%d]", index)
+	}
+	return fmt.Sprintf("delete_config[%d] in %s", rule.SourceIndex, rule.Source)
+}
+
+// isSubfolder reports whether path lies below folder.
+func isSubfolder(path, folder string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(folder, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// lineOf returns the line of path, falling back to its closest parent.
+func lineOf(lines map[string]int, path string) int {
+	for path != "" {
+		if line, ok := lines[path]; ok {
+			return line
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+	return 0
+}
+
+// keyLines maps the JSON path of every key in the configuration file at path
+// to its line.
+func keyLines(path string) (map[string]int, error) {
+	format, err := ConfigFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatJSON:
+		return jsonKeyLines(data)
+	case FormatYAML:
+		return yamlKeyLines(data)
+	default:
+		return blockKeyLines(data, format), nil
+	}
+}
+
+func jsonKeyLines(data []byte) (map[string]int, error) {
+	type frame struct {
+		path      string
+		array     bool
+		index     int
+		expectKey bool
+		key       string
+	}
+	lines := make(map[string]int)
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+	// valuePath returns the path of the value about to be read in top.
+	valuePath := func(top *frame) string {
+		if top.array {
+			return fmt.Sprintf("%s[%d]", top.path, top.index)
+		}
+		if top.path == "" {
+			return top.key
+		}
+		return top.path + "." + top.key
+	}
+	// valueDone moves top past the value just read.
+	valueDone := func(top *frame) {
+		if top.array {
+			top.index++
+		} else {
+			top.expectKey = true
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	var stack []*frame
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top != nil && !top.array && top.expectKey {
+			if key, ok := token.(string); ok {
+				top.key = key
+				top.expectKey = false
+				lines[valuePath(top)] = lineAt(decoder.InputOffset())
+				continue
+			}
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			path := ""
+			if top != nil {
+				path = valuePath(top)
+				if top.array {
+					lines[path] = lineAt(decoder.InputOffset())
+				}
+			}
+			stack = append(stack, &frame{path: path, array: token == json.Delim('['), expectKey: true})
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				valueDone(stack[len(stack)-1])
+			}
+		default:
+			if top != nil {
+				if top.array {
+					lines[valuePath(top)] = lineAt(decoder.InputOffset())
+				}
+				valueDone(top)
+			}
+		}
+	}
+}
+
+func yamlKeyLines(data []byte) (map[string]int, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	lines := make(map[string]int)
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i].Value
+				if path != "" {
+					key = path + "." + key
+				}
+				lines[key] = node.Content[i].Line
+				walk(node.Content[i+1], key)
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				item := fmt.Sprintf("%s[%d]", path, i)
+				lines[item] = child.Line
+				walk(child, item)
+			}
+		}
+	}
+	walk(&root, "")
+	return lines, nil
+}
+
+var (
+	tomlTableHeader = regexp.MustCompile(`^\s*\[\[\s*([\w.]+)\s*\]\]`)
+	hclBlockHeader  = regexp.MustCompile(`^\s*(\w+)\s*\{`)
+	keyAssignment   = regexp.MustCompile(`^\s*"?(\w+)"?\s*=`)
+)
+
+// blockKeyLines locates keys in TOML and HCL files line by line. Rules are
+// written as repeated [[delete_config]] tables or delete_config blocks, nested
+// throttle windows are not located and fall back to the line of their rule.
+func blockKeyLines(data []byte, format string) map[string]int {
+	header := tomlTableHeader
+	if format == FormatHCL {
+		header = hclBlockHeader
+	}
+	lines := make(map[string]int)
+	counts := make(map[string]int)
+	current := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for number := 1; scanner.Scan(); number++ {
+		text := scanner.Text()
+		if match := header.FindStringSubmatch(text); match != nil {
+			if match[1] == "delete_config" {
+				current = "delete_config[" + strconv.Itoa(counts[match[1]]) + "]"
+				counts[match[1]]++
+				lines[current] = number
+			} else if current == "" || format == FormatTOML {
+				current = ""
+			}
+			continue
+		}
+		if match := keyAssignment.FindStringSubmatch(text); match != nil {
+			path := match[1]
+			if current != "" {
+				path = current + "." + match[1]
+			}
+			if _, ok := lines[path]; !ok {
+				lines[path] = number
+			}
+		}
+	}
+	lines["delete_config"] = lines["delete_config[0]"]
+	return lines
+}
+
+// sortProblems groups problems by file, in the order the files were merged,
+// and orders them by line and then path within each file.
+func sortProblems(problems ValidationErrors) {
+	rank := make(map[string]int)
+	for _, problem := range problems {
+		if _, ok := rank[problem.File]; !ok {
+			rank[problem.File] = len(rank)
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return rank[problems[i].File] < rank[problems[j].File]
+		}
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Path < problems[j].Path
+	})
+}
@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The first rule has an invalid check_size_interval_secs and the second one a
+// retention of 0. The target folder of the second rule does not exist.
+var validateFiles = map[string]string{
+	"config.json": `{
+  "version": 2,
+  "delete_config": [
+    {
+      "target_folder": %q,
+      "enabled": true,
+      "retention_days": 7,
+      "max_folder_size_mb": 100,
+      "delete_interval_seconds": 60,
+      "check_size_interval_secs": -1
+    },
+    {
+      "target_folder": %q,
+      "enabled": true,
+      "retention_days": 0,
+      "max_folder_size_mb": 100,
+      "delete_interval_seconds": 60,
+      "check_size_interval_secs": 60
+    }
+  ]
+}
+`,
+	"config.yaml": `version: 2
+delete_config:
+  # logs of the app
+  - target_folder: %q
+    enabled: true
+    retention_days: 7
+    max_folder_size_mb: 100
+    delete_interval_seconds: 60
+    check_size_interval_secs: -1
+  - target_folder: %q
+    enabled: true
+    retention_days: 0
+    max_folder_size_mb: 100
+    delete_interval_seconds: 60
+    check_size_interval_secs: 60
+`,
+	"config.toml": `version = 2
+
+[[delete_config]]
+target_folder = %q
+enabled = true
+retention_days = 7
+max_folder_size_mb = 100
+delete_interval_seconds = 60
+check_size_interval_secs = -1
+
+[[delete_config]]
+target_folder = %q
+enabled = true
+retention_days = 0
+max_folder_size_mb = 100
+delete_interval_seconds = 60
+check_size_interval_secs = 60
+`,
+	"config.hcl": `version = 2
+delete_config {
+  target_folder = %q
+  enabled = true
+  retention_days = 7
+  max_folder_size_mb = 100
+  delete_interval_seconds = 60
+  check_size_interval_secs = -1
+}
+delete_config {
+  target_folder = %q
+  enabled = true
+  retention_days = 0
+  max_folder_size_mb = 100
+  delete_interval_seconds = 60
+  check_size_interval_secs = 60
+}
+`,
+}
+
+func TestValidateFileReportsPathsAndLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines [2]int
+	}{
+		{"config.json", [2]int{10, 15}},
+		{"config.yaml", [2]int{9, 12}},
+		{"config.toml", [2]int{9, 14}},
+		{"config.hcl", [2]int{8, 13}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			existing, missing := t.TempDir(), filepath.Join(t.TempDir(), "not-mounted")
+			path := writeConfigAs(t, test.name, fmt.Sprintf(validateFiles[test.name], existing, missing))
+			config, err := LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var problems ValidationErrors
+			if err := ValidateFile(path, config); !errors.As(err, &problems) {
+				t.Fatalf("got %v, want validation errors", err)
+			}
+			want := []ValidationError{
+				{File: path, Path: "delete_config[0].check_size_interval_secs", Line: test.lines[0], Message: "must be positive"},
+				{File: path, Path: "delete_config[1].retention_days", Line: test.lines[1], Message: "must be positive, a retention of 0 deletes every file"},
+			}
+			if len(problems) != len(want) {
+				t.Fatalf("got problems\n%v\nwant %d", problems, len(want))
+			}
+			for i := range want {
+				if problems[i] != want[i] {
+					t.Errorf("got %+v, want %+v", problems[i], want[i])
+				}
+			}
+
+			// The missing folder is only a warning
+			warnings := config.FolderWarnings()
+			if len(warnings) != 1 || warnings[0] != "delete_config[1] in "+path+": target folder "+missing+" does not exist" {
+				t.Errorf("folder warnings %q, want one for the missing folder of delete_config[1]", warnings)
+			}
+		})
+	}
+}
+
+func TestProblemsTargetFolder(t *testing.T) {
+	rule := func(folder string, enabled bool) DeleteConfig {
+		return DeleteConfig{TargetFolder: folder, Enabled: enabled, RetentionDays: 7, MaxFolderSizeMB: 100, DeleteIntervalSeconds: 60, CheckSizeIntervalSecs: 60}
+	}
+	folder := t.TempDir()
+	file := filepath.Join(folder, "file.log")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(folder, "missing")
+
+	tests := []struct {
+		name     string
+		rules    []DeleteConfig
+		problems []string
+		warnings int
+	}{
+		{"existing folder", []DeleteConfig{rule(folder, true)}, nil, 0},
+		{"missing folder", []DeleteConfig{rule(missing, true)}, nil, 1},
+		{"missing folder of a disabled rule", []DeleteConfig{rule(missing, false)}, nil, 0},
+		{"file", []DeleteConfig{rule(file, true)}, []string{"delete_config[0].target_folder: " + file + " is not a folder"}, 0},
+		{"no folder", []DeleteConfig{rule("", true)}, []string{"delete_config[0].target_folder: is required"}, 0},
+		{"overlapping folders", []DeleteConfig{rule(folder, true), rule(missing, true)},
+			[]string{"delete_config[1].target_folder: folder " + missing + " overlaps with " + folder + " of delete_config[0]"}, 1},
+	}
+	for _, test := range tests {
+		config := Config{DeleteConfig: test.rules}
+		var problems []string
+		for _, problem := range config.Problems() {
+			problems = append(problems, problem.Error())
+		}
+		if strings.Join(problems, "\n") != strings.Join(test.problems, "\n") {
+			t.Errorf("%s: problems %q, want %q", test.name, problems, test.problems)
+		}
+		if warnings := config.FolderWarnings(); len(warnings) != test.warnings {
+			t.Errorf("%s: warnings %q, want %d", test.name, warnings, test.warnings)
+		}
+	}
+}